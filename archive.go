@@ -0,0 +1,340 @@
+package aztech
+
+import (
+    "archive/tar"
+    "archive/zip"
+    "compress/gzip"
+    "fmt"
+    "io"
+    "io/fs"
+    "io/ioutil"
+    "os"
+    "path"
+    "sort"
+    "strings"
+    "time"
+)
+
+// fileSource opens the contents of the files a walk found, by the Path of
+// their TOC entries.
+type fileSource interface {
+    Open(name string) (io.ReadCloser, error)
+    Close() error
+}
+
+// dirSource reads files straight off disk, for trees from walkDir.
+type dirSource struct{}
+
+func (dirSource) Open(name string) (io.ReadCloser, error) {
+    return os.Open(name)
+}
+
+func (dirSource) Close() error {
+    return nil
+}
+
+// archiveKind returns "zip", "tar" or "tar.gz" if p looks like an archive
+// we can pack from, going by its extension, or "" otherwise.
+func archiveKind(p string) string {
+    lower := strings.ToLower(p)
+    switch {
+    case strings.HasSuffix(lower, ".zip"):
+        return "zip"
+    case strings.HasSuffix(lower, ".tar"):
+        return "tar"
+    case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+        return "tar.gz"
+    }
+    return ""
+}
+
+// walkArchive builds the same shape of tree as walkDir, from the members
+// of a tar, gzipped tar or zip archive, rooted at ".". Files in the tree
+// are read back through the returned fileSource, which the caller closes.
+func walkArchive(archivePath string, opts walkOptions) (InputFileOrDir, fileSource, error) {
+    switch archiveKind(archivePath) {
+    case "zip":
+        return walkZip(archivePath, opts)
+    case "tar", "tar.gz":
+        return walkTar(archivePath, opts)
+    }
+    return InputFileOrDir{"err", 0, time.Unix(0,0), false, []InputFileOrDir{}}, nil,
+        fmt.Errorf("%v is not a .tar, .tar.gz or .zip archive", archivePath)
+}
+
+func walkZip(archivePath string, opts walkOptions) (InputFileOrDir, fileSource, error) {
+    zr, err := zip.OpenReader(archivePath)
+    if err != nil {
+        return InputFileOrDir{"err", 0, time.Unix(0,0), false, []InputFileOrDir{}}, nil, err
+    }
+    tree := newArchiveTree(opts)
+    members := map[string]*zip.File{}
+    for _, f := range zr.File {
+        fi := f.FileInfo()
+        added, err := tree.add(f.Name, fi, fi.Mode().IsRegular())
+        if err != nil {
+            zr.Close()
+            return InputFileOrDir{"err", 0, time.Unix(0,0), false, []InputFileOrDir{}}, nil, err
+        }
+        if added {
+            members[MemberPath(f.Name)] = f
+        }
+    }
+    return tree.build("."), zipSource{zr, members}, nil
+}
+
+func walkTar(archivePath string, opts walkOptions) (InputFileOrDir, fileSource, error) {
+    src := &tarSource{
+        archivePath: archivePath,
+        gzipped: archiveKind(archivePath) == "tar.gz",
+    }
+    if err := src.rewind(); err != nil {
+        return InputFileOrDir{"err", 0, time.Unix(0,0), false, []InputFileOrDir{}}, nil, err
+    }
+    tree := newArchiveTree(opts)
+    for {
+        hdr, err := src.tr.Next()
+        if err == io.EOF {
+            break
+        }
+        if err == nil {
+            _, err = tree.add(hdr.Name, hdr.FileInfo(), hdr.Typeflag == tar.TypeReg)
+        }
+        if err != nil {
+            src.Close()
+            return InputFileOrDir{"err", 0, time.Unix(0,0), false, []InputFileOrDir{}}, nil, err
+        }
+    }
+    return tree.build("."), src, nil
+}
+
+// walkFS builds the same shape of tree as walkDir from everything in fsys,
+// rooted at ".", with fs.WalkDir. Directories opts.filter leaves out aren't
+// walked. Files are read back through the returned fileSource, which the
+// caller closes; that closes fsys too, if it's an io.Closer.
+func walkFS(fsys fs.FS, opts walkOptions) (InputFileOrDir, fileSource, error) {
+    tree := newArchiveTree(opts)
+    err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+        if err != nil || p == "." {
+            return err
+        }
+        if d.IsDir() && opts.filter.skips(p, true) {
+            opts.skips.note(p, skipExcluded)
+            return fs.SkipDir
+        }
+        fi, err := d.Info()
+        if err != nil {
+            return err
+        }
+        _, err = tree.add(p, fi, fi.Mode().IsRegular())
+        return err
+    })
+    if err != nil {
+        return InputFileOrDir{"err", 0, time.Unix(0,0), false, []InputFileOrDir{}}, nil, err
+    }
+    return tree.build("."), fsSource{fsys}, nil
+}
+
+// fsSource reads files out of an fs.FS, for trees from walkFS.
+type fsSource struct {
+    fsys fs.FS
+}
+
+func (s fsSource) Open(name string) (io.ReadCloser, error) {
+    return s.fsys.Open(name)
+}
+
+func (s fsSource) Close() error {
+    if c, ok := s.fsys.(io.Closer); ok {
+        return c.Close()
+    }
+    return nil
+}
+
+// MemberPath cleans an archive member's name into the path it's known by
+// in the tree, without any leading "/" or "./", and unable to climb out of
+// the root. The root itself is ".".
+func MemberPath(name string) string {
+    p := strings.TrimPrefix(path.Clean("/" + name), "/")
+    if p == "" {
+        return "."
+    }
+    return p
+}
+
+// archiveTree gathers archive members, which can come in any order and
+// needn't list their directories, until the tree can be built.
+type archiveTree struct {
+    opts walkOptions
+    // files and subdirectories, keyed by the directory they're in
+    files map[string][]InputFileOrDir
+    dirs map[string]map[string]bool
+    seen map[string]bool
+}
+
+func newArchiveTree(opts walkOptions) *archiveTree {
+    return &archiveTree{
+        opts: opts,
+        files: map[string][]InputFileOrDir{},
+        dirs: map[string]map[string]bool{},
+        seen: map[string]bool{},
+    }
+}
+
+// add records a member, reporting whether it'll be packed. Members that
+// are neither regular files nor directories are handled like special
+// files in walkDir, and repeats of a member are complained about.
+func (t *archiveTree) add(name string, fi os.FileInfo, regular bool) (bool, error) {
+    p := MemberPath(name)
+    dir := p
+    if !fi.IsDir() {
+        dir = path.Dir(p)
+    }
+    if depth := strings.Count(dir, "/") + 1; dir != "." && t.opts.maxDepth > 0 && depth > t.opts.maxDepth {
+        return false, fmt.Errorf("%v is %d directories deep, more than --max-depth %d", dir, depth, t.opts.maxDepth)
+    }
+    if p != "." && t.opts.filter.skipsPath(p, fi.IsDir()) {
+        t.opts.skips.note(p, skipExcluded)
+        return false, nil
+    }
+    if fi.IsDir() {
+        t.addDir(p)
+        return false, nil
+    }
+    if !regular {
+        err := fmt.Errorf("%v is not a regular file or directory", p)
+        if t.opts.specialFiles == "error" {
+            return false, err
+        }
+        t.opts.skips.note(p, skipSpecialFile)
+        return false, complain(p, "skipping it", "%v", err)
+    }
+    if t.seen[p] {
+        t.opts.skips.note(p, skipDuplicate)
+        return false, complain(p, "skipping all but the first", "%v is in the archive more than once", p)
+    }
+    parent := path.Dir(p)
+    child, err := convertFileInfo(parent, fi)
+    if err != nil {
+        return false, err
+    }
+    t.addDir(parent)
+    t.files[parent] = append(t.files[parent], child)
+    t.seen[p] = true
+    return true, nil
+}
+
+// addDir records p and all of its parents as directories.
+func (t *archiveTree) addDir(p string) {
+    for p != "." {
+        parent := path.Dir(p)
+        if t.dirs[parent] == nil {
+            t.dirs[parent] = map[string]bool{}
+        }
+        if t.dirs[parent][p] {
+            return
+        }
+        t.dirs[parent][p] = true
+        p = parent
+    }
+}
+
+func (t *archiveTree) build(dir string) InputFileOrDir {
+    children := append([]InputFileOrDir{}, t.files[dir]...)
+    for sub := range t.dirs[dir] {
+        children = append(children, t.build(sub))
+    }
+    // match the name order ReadDir gives walkDir
+    sort.Slice(children, func(i, j int) bool {
+        return path.Base(children[i].originalPath) < path.Base(children[j].originalPath)
+    })
+    return InputFileOrDir {
+        originalPath: dir,
+        size: 0,
+        modTime: time.Unix(0, 0),
+        isDir: true,
+        children: children,
+    }
+}
+
+type zipSource struct {
+    zr *zip.ReadCloser
+    members map[string]*zip.File
+}
+
+func (s zipSource) Open(name string) (io.ReadCloser, error) {
+    f, ok := s.members[name]
+    if !ok {
+        return nil, fmt.Errorf("%v is not in the archive", name)
+    }
+    return f.Open()
+}
+
+func (s zipSource) Close() error {
+    return s.zr.Close()
+}
+
+// tarSource reads files out of a tarball, which can only be read forwards.
+// It keeps its place between files and only starts again from the top
+// when asked for one it has already gone past, which is cheap as long as
+// the tarball is in roughly the same order as the TOC.
+type tarSource struct {
+    archivePath string
+    gzipped bool
+    f *os.File
+    tr *tar.Reader
+}
+
+func (s *tarSource) rewind() error {
+    s.Close()
+    f, err := os.Open(s.archivePath)
+    if err != nil {
+        return err
+    }
+    var r io.Reader = f
+    if s.gzipped {
+        gz, err := gzip.NewReader(f)
+        if err != nil {
+            f.Close()
+            return fmt.Errorf("reading %v: %w", s.archivePath, err)
+        }
+        r = gz
+    }
+    s.f = f
+    s.tr = tar.NewReader(r)
+    return nil
+}
+
+// Open returns a reader over name's contents, valid until the next Open.
+func (s *tarSource) Open(name string) (io.ReadCloser, error) {
+    for pass := 0; pass < 2; pass++ {
+        if s.tr == nil || pass > 0 {
+            if err := s.rewind(); err != nil {
+                return nil, err
+            }
+        }
+        for {
+            hdr, err := s.tr.Next()
+            if err == io.EOF {
+                break
+            }
+            if err != nil {
+                return nil, fmt.Errorf("reading %v: %w", s.archivePath, err)
+            }
+            if hdr.Typeflag == tar.TypeReg && MemberPath(hdr.Name) == name {
+                return ioutil.NopCloser(s.tr), nil
+            }
+        }
+    }
+    return nil, fmt.Errorf("%v is not in the archive", name)
+}
+
+func (s *tarSource) Close() error {
+    if s.f == nil {
+        return nil
+    }
+    err := s.f.Close()
+    s.f = nil
+    s.tr = nil
+    return err
+}
@@ -0,0 +1,122 @@
+package aztech
+
+import (
+    "archive/tar"
+    "archive/zip"
+    "compress/gzip"
+    "context"
+    "io"
+    "os"
+    "path"
+    "reflect"
+    "sort"
+    "testing"
+    "time"
+)
+
+// archiveFiles is the tree TestPackArchives packs, with a file big enough
+// that reading it takes more than one read.
+var archiveFiles = map[string]string{
+    "data/maps/a.pof": "a",
+    "data/maps/sub/b.dds": string(make([]byte, 100000)),
+    "data/maps/sub/c.dds": "c",
+    "data/tables/ships.tbl": "ships",
+    "data/tables/weapons.tbl": "weapons",
+    "readme.txt": "not packed",
+}
+
+// archiveMembers is archiveFiles' paths, in the opposite order to the
+// TOC's, so reading them for it goes backwards through the archive.
+func archiveMembers() []string {
+    names := []string{}
+    for name := range archiveFiles {
+        names = append(names, name)
+    }
+    sort.Sort(sort.Reverse(sort.StringSlice(names)))
+    return names
+}
+
+func writeTar(t *testing.T, p string, gzipped bool, mtime time.Time) {
+    f, err := os.Create(p)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+    var w io.Writer = f
+    if gzipped {
+        gz := gzip.NewWriter(f)
+        defer gz.Close()
+        w = gz
+    }
+    tw := tar.NewWriter(w)
+    defer tw.Close()
+    // one directory listed, the rest left to be worked out, and some
+    // names given with ./ in front
+    if err := tw.WriteHeader(&tar.Header{Name: "data/maps/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: mtime}); err != nil {
+        t.Fatal(err)
+    }
+    for i, name := range archiveMembers() {
+        if i % 2 == 0 {
+            name = "./" + name
+        }
+        content := archiveFiles[MemberPath(name)]
+        if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content)), ModTime: mtime}); err != nil {
+            t.Fatal(err)
+        }
+        if _, err := io.WriteString(tw, content); err != nil {
+            t.Fatal(err)
+        }
+    }
+}
+
+func writeZip(t *testing.T, p string, mtime time.Time) {
+    f, err := os.Create(p)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+    zw := zip.NewWriter(f)
+    defer zw.Close()
+    for _, name := range archiveMembers() {
+        w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: mtime})
+        if err != nil {
+            t.Fatal(err)
+        }
+        if _, err := io.WriteString(w, archiveFiles[name]); err != nil {
+            t.Fatal(err)
+        }
+    }
+}
+
+func TestPackArchives(t *testing.T) {
+    mtime := time.Unix(1700000000, 0)
+    in := path.Join(t.TempDir(), "in")
+    writeFiles(t, in, archiveFiles)
+    for name := range archiveFiles {
+        if err := os.Chtimes(path.Join(in, name), mtime, mtime); err != nil {
+            t.Fatal(err)
+        }
+    }
+    out := t.TempDir()
+    if err := Pack(context.Background(), []string{in}, Options{OutputDir: out}); err != nil {
+        t.Fatal(err)
+    }
+    want := packedFiles(t, out)
+    if len(want) != 2 {
+        t.Fatalf("packing the directory gave %d VPs, want 2", len(want))
+    }
+
+    archives := t.TempDir()
+    writeTar(t, path.Join(archives, "in.tar"), false, mtime)
+    writeTar(t, path.Join(archives, "in.tar.gz"), true, mtime)
+    writeZip(t, path.Join(archives, "in.zip"), mtime)
+    for _, name := range []string{"in.tar", "in.tar.gz", "in.zip"} {
+        out := t.TempDir()
+        if err := Pack(context.Background(), []string{path.Join(archives, name)}, Options{OutputDir: out}); err != nil {
+            t.Fatalf("%v: %v", name, err)
+        }
+        if got := packedFiles(t, out); !reflect.DeepEqual(got, want) {
+            t.Errorf("packing %v gave different VPs from packing the directory", name)
+        }
+    }
+}
@@ -0,0 +1,63 @@
+package aztech
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "strings"
+
+    "github.com/tcrayford/aztech/vp"
+)
+
+// ExpectedFile is one entry in an assert manifest: a path that must be in
+// the VP and, optionally, the size and SHA-256 its contents must have.
+type ExpectedFile struct {
+    Path string `json:"path"`
+    Size *int64 `json:"size,omitempty"`
+    SHA256 string `json:"sha256,omitempty"`
+}
+
+// AssertVP compares the VP at vpPath against expected, and returns every
+// discrepancy found rather than stopping at the first. Paths are matched
+// case-insensitively, as the engine looks them up. The error is only for
+// failing to read the VP at all.
+func AssertVP(vpPath string, expected []ExpectedFile) ([]string, error) {
+    f, err := OpenVP(vpPath)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    entries, err := vp.ReadTOC(f, f.Size)
+    if err != nil {
+        return nil, err
+    }
+    files := map[string]vp.TOCEntry{}
+    for _, entry := range entries {
+        if !entry.IsDir {
+            files[strings.ToLower(entry.Path)] = entry
+        }
+    }
+
+    problems := []string{}
+    for _, want := range expected {
+        entry, ok := files[strings.ToLower(MemberPath(want.Path))]
+        if !ok {
+            problems = append(problems, fmt.Sprintf("%v is missing", want.Path))
+            continue
+        }
+        if want.Size != nil && int64(entry.Size) != *want.Size {
+            problems = append(problems, fmt.Sprintf("%v is %d bytes, expected %d", want.Path, entry.Size, *want.Size))
+        }
+        if want.SHA256 != "" {
+            h := sha256.New()
+            if _, err := io.Copy(h, vp.OpenEntry(f, entry)); err != nil {
+                return nil, err
+            }
+            if got := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(got, want.SHA256) {
+                problems = append(problems, fmt.Sprintf("%v has sha256 %v, expected %v", want.Path, got, want.SHA256))
+            }
+        }
+    }
+    return problems, nil
+}
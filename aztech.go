@@ -0,0 +1,1326 @@
+// Package aztech packs directories into VP archives, the format FreeSpace 2
+// loads its data from, and works with ones already packed: listing,
+// extracting, comparing, updating and checking them. Pack, PackFS and
+// PackStream are the ways in for packing, configured with Options; the
+// format itself is package vp. The aztech command, in cmd/aztech, is a
+// thin layer of flags over this package.
+package aztech
+
+import (
+    "context"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "io/fs"
+    "io/ioutil"
+    "math"
+    "os"
+    "path"
+    "path/filepath"
+    "sort"
+    "strings"
+    "syscall"
+    "time"
+    "unicode"
+
+    "github.com/tcrayford/aztech/vp"
+)
+
+type InputFileOrDir struct {
+    originalPath string
+    size int32
+    modTime time.Time
+    isDir bool
+    children []InputFileOrDir
+}
+
+// walkOptions controls what walkDir picks up from the input tree.
+type walkOptions struct {
+    // exclude, if set, is a directory (joined onto the input directory)
+    // that is left out of the walk entirely
+    exclude string
+    // specialFiles says what to do with devices, sockets and FIFOs:
+    // "skip" warns and leaves them out (unless under --strict), "error"
+    // fails the walk
+    specialFiles string
+    // maxDepth, if above 0, is how many directories deep below the input
+    // the walk may go before failing
+    maxDepth int
+    // rawOrder keeps entries in the order the filesystem lists them,
+    // instead of sorting them by name
+    rawOrder bool
+    // stubDepth, if above 0, is how deep directories can be before the
+    // walk lists them without going into them, leaving them to be walked
+    // with walkDirDepth when they're wanted
+    stubDepth int
+    // keepGoing skips files named in a list that don't exist, with a
+    // warning, instead of failing
+    keepGoing bool
+    // filter picks out what's walked by pattern
+    filter pathFilter
+    // links, if set, has symlinks followed, and keeps track of where
+    // they've led; otherwise they're skipped like special files
+    links *linkTracker
+    // skips, if not nil, is where what's left out of the walk is noted
+    skips skipLog
+    // nestedVPs complains about VPs in the walk, which are there by
+    // mistake more often than not, like when an earlier run's output
+    // was left in the input
+    nestedVPs bool
+    // statAll lstats every entry as soon as its directory is listed, as
+    // ioutil.ReadDir did, rather than only the files that are kept; it's
+    // only there to measure the difference against
+    statAll bool
+}
+
+// specialFileModes are the file types that can't be packed: reading
+// them either blocks or yields something other than file contents.
+const specialFileModes = os.ModeDevice | os.ModeCharDevice | os.ModeNamedPipe | os.ModeSocket | os.ModeIrregular
+
+func walkDir(inputDir string, opts walkOptions) (InputFileOrDir, error) {
+    return walkDirDepth(inputDir, opts, 0)
+}
+
+// walkDirDepth is walkDir for a directory depth levels below the input.
+//
+// Entries are only stat'd once they're known to be files that aren't
+// excluded, which saves a lot of syscalls on big trees.
+//
+// The directories being walked are kept on a stack of walkFrames rather
+// than walked by recursion, so how deep a tree can go is only bounded by
+// memory, and by --max-depth.
+func walkDirDepth(inputDir string, opts walkOptions, depth int) (InputFileOrDir, error) {
+    top, err := openWalkFrame(inputDir, opts, depth)
+    if err != nil {
+        return InputFileOrDir{"err", 0, time.Unix(0,0), false, []InputFileOrDir{}}, err
+    }
+    stack := []*walkFrame{top}
+    for {
+        frame := stack[len(stack) - 1]
+        if frame.next == len(frame.entries) {
+            dir := InputFileOrDir {
+                originalPath: frame.dir,
+                size: 0,
+                modTime: time.Unix(0, 0),
+                isDir: true,
+                children: frame.children,
+            }
+            stack = stack[:len(stack) - 1]
+            if len(stack) == 0 {
+                return dir, nil
+            }
+            parent := stack[len(stack) - 1]
+            parent.children = append(parent.children, dir)
+            continue
+        }
+        f := frame.entries[frame.next]
+        frame.next++
+        if opts.exclude != "" && path.Join(frame.dir, f.Name()) == opts.exclude {
+            continue
+        }
+        isDir := f.IsDir()
+        // what a followed symlink points at
+        var target os.FileInfo
+        if f.Type() & os.ModeSymlink != 0 && opts.links != nil {
+            var skip bool
+            target, skip, err = opts.links.follow(path.Join(frame.dir, f.Name()))
+            if err != nil {
+                return InputFileOrDir{"err", 0, time.Unix(0,0), false, []InputFileOrDir{}}, err
+            }
+            if skip {
+                continue
+            }
+            isDir = target.IsDir()
+        }
+        if opts.filter.skips(relPath(path.Join(frame.dir, f.Name()), frame.depth + 1), isDir) {
+            opts.skips.note(path.Join(frame.dir, f.Name()), skipExcluded)
+            continue
+        }
+        if isDir {
+            if opts.maxDepth > 0 && frame.depth + 1 > opts.maxDepth {
+                return InputFileOrDir{"err", 0, time.Unix(0,0), false, []InputFileOrDir{}},
+                    fmt.Errorf("%v is %d directories deep, more than --max-depth %d", path.Join(frame.dir, f.Name()), frame.depth + 1, opts.maxDepth)
+            }
+            if opts.stubDepth > 0 && frame.depth + 1 >= opts.stubDepth {
+                frame.children = append(frame.children, InputFileOrDir {
+                    originalPath: path.Join(frame.dir, f.Name()),
+                    size: 0,
+                    modTime: time.Unix(0, 0),
+                    isDir: true,
+                    children: []InputFileOrDir{},
+                })
+                continue
+            }
+            child, err := openWalkFrame(path.Join(frame.dir, f.Name()), opts, frame.depth + 1)
+            if err != nil {
+                return InputFileOrDir{"err", 0, time.Unix(0,0), false, []InputFileOrDir{}}, err
+            }
+            stack = append(stack, child)
+        } else {
+            info := target
+            if info == nil {
+                info, err = f.Info()
+            }
+            if err != nil {
+                return InputFileOrDir{"err", 0, time.Unix(0,0), false, []InputFileOrDir{}}, err
+            }
+            child, err := convertFileInfo(frame.dir, info)
+            if err != nil {
+                if opts.specialFiles == "error" {
+                    return InputFileOrDir{"err", 0, time.Unix(0,0), false, []InputFileOrDir{}}, err
+                }
+                if err := complain(path.Join(frame.dir, f.Name()), "skipping it", "%v", err); err != nil {
+                    return InputFileOrDir{"err", 0, time.Unix(0,0), false, []InputFileOrDir{}}, err
+                }
+                opts.skips.note(path.Join(frame.dir, f.Name()), skipSpecialFile)
+                continue
+            }
+            if opts.nestedVPs && isVPName(f.Name()) {
+                if err := complain(child.originalPath, "", "%v is a VP, which would be packed inside another (--allow-nested-vp if that's meant)", child.originalPath); err != nil {
+                    return InputFileOrDir{"err", 0, time.Unix(0,0), false, []InputFileOrDir{}}, err
+                }
+            }
+            frame.children = append(frame.children, child)
+        }
+    }
+}
+
+// walkFrame is a directory walkDirDepth is part way through: its entries,
+// how many of them it's been through, and what it's kept of those.
+type walkFrame struct {
+    dir string
+    depth int
+    entries []os.DirEntry
+    next int
+    children []InputFileOrDir
+}
+
+// openWalkFrame lists dir, depth levels below the input, to be walked.
+func openWalkFrame(dir string, opts walkOptions, depth int) (*walkFrame, error) {
+    var dirEntries []os.DirEntry
+    var err error
+    if opts.rawOrder {
+        dirEntries, err = readDirUnsorted(dir)
+    } else {
+        dirEntries, err = os.ReadDir(dir)
+    }
+    if err != nil {
+        return nil, err
+    }
+    if opts.statAll {
+        for i, entry := range dirEntries {
+            info, err := os.Lstat(path.Join(dir, entry.Name()))
+            if err != nil {
+                return nil, err
+            }
+            dirEntries[i] = fs.FileInfoToDirEntry(info)
+        }
+    }
+    return &walkFrame{dir: dir, depth: depth, entries: dirEntries, children: make([]InputFileOrDir, 0)}, nil
+}
+
+// readDirUnsorted is os.ReadDir without the sort, leaving the entries in
+// whatever order the filesystem has them.
+func readDirUnsorted(dir string) ([]os.DirEntry, error) {
+    f, err := os.Open(dir)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    return f.ReadDir(-1)
+}
+
+// convertFileInfo turns a file found under root into a tree node. It
+// errors on special files (see specialFileModes), which can't be packed.
+func convertFileInfo(root string, f os.FileInfo) (InputFileOrDir, error) {
+    if f.Mode() & os.ModeSymlink != 0 {
+        return InputFileOrDir{"err", 0, time.Unix(0,0), false, []InputFileOrDir{}},
+            fmt.Errorf("%v is a symlink, not a regular file (--follow-symlinks packs what it points at)", path.Join(root, f.Name()))
+    }
+    if f.Mode() & specialFileModes != 0 {
+        return InputFileOrDir{"err", 0, time.Unix(0,0), false, []InputFileOrDir{}},
+            fmt.Errorf("%v is a special file (%v), not a regular file", path.Join(root, f.Name()), f.Mode().Type())
+    }
+    return InputFileOrDir{
+        originalPath: path.Join(root, f.Name()),
+        size: int32(f.Size()),
+        modTime: f.ModTime(),
+        isDir: false,
+        children: []InputFileOrDir{},
+    }, nil
+}
+
+// inputName is the name the VP for the whole of inputDir gets, without
+// the extension: the input directory's own name, or the archive's. "."
+// and the like are named for the directory they stand for; the root of
+// the filesystem has no name, so gets "".
+func inputName(inputDir string) string {
+    name := path.Base(path.Clean(inputDir))
+    if abs, err := filepath.Abs(inputDir); err == nil {
+        name = path.Base(filepath.ToSlash(abs))
+    }
+    if name == "/" || name == "." {
+        return ""
+    }
+    lower := strings.ToLower(name)
+    for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+        if strings.HasSuffix(lower, ext) {
+            return name[:len(name) - len(ext)]
+        }
+    }
+    return name
+}
+
+// hasSubdir reports whether dir has a directory called name directly in it.
+func hasSubdir(dir InputFileOrDir, name string) bool {
+    for _, c := range dir.children {
+        if c.isDir && path.Base(c.originalPath) == name {
+            return true
+        }
+    }
+    return false
+}
+
+// outputInsideInput reports whether outputDir is inputDir itself or lies
+// somewhere beneath it. When it does, the returned path is outputDir
+// expressed the way walkDir will see it (joined onto inputDir).
+func outputInsideInput(inputDir string, outputDir string) (string, bool, error) {
+    absInput, err := filepath.Abs(inputDir)
+    if err != nil {
+        return "", false, err
+    }
+    absOutput, err := filepath.Abs(outputDir)
+    if err != nil {
+        return "", false, err
+    }
+    rel, err := filepath.Rel(absInput, absOutput)
+    if err != nil {
+        return "", false, err
+    }
+    if rel == ".." || strings.HasPrefix(rel, "../") {
+        return "", false, nil
+    }
+    return path.Join(inputDir, filepath.ToSlash(rel)), true, nil
+}
+
+// cleanOutput removes the VPs left in dir from earlier runs, along with
+// their sidecars (files named after a VP plus another extension, like
+// maps.vp.sha256) and the checksum files of split sets, like
+// maps.vpset.sha256. Nothing else is touched, and a dir that doesn't exist
+// yet has nothing to clean.
+func cleanOutput(dir string) error {
+    fileInfos, err := ioutil.ReadDir(dir)
+    if os.IsNotExist(err) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    vps := map[string]bool{}
+    for _, f := range fileInfos {
+        if f.Mode().IsRegular() && isVPName(f.Name()) {
+            vps[f.Name()] = true
+        }
+    }
+    for _, f := range fileInfos {
+        if !f.Mode().IsRegular() {
+            continue
+        }
+        remove := vps[f.Name()] || setChecksumAlgo(f.Name()) != nil
+        if ext := path.Ext(f.Name()); ext != "" && vps[strings.TrimSuffix(f.Name(), ext)] {
+            remove = true
+        }
+        if remove {
+            LogEntry("info", path.Join(dir, f.Name()), -1, fmt.Sprintf("removing %v", path.Join(dir, f.Name())))
+            if err := os.Remove(path.Join(dir, f.Name())); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+func printInputFileOrDir(f InputFileOrDir, level int) {
+    indent := strings.Repeat(" ", level * 2)
+    if f.isDir {
+        fmt.Printf("%sdir:  %v\n", indent, f.originalPath)
+        for _, c := range f.children {
+            printInputFileOrDir(c, level + 1)
+        }
+    } else {
+        fmt.Printf("%sfile: %v\n", indent, f.originalPath)
+    }
+}
+
+// tocOptions controls how produceTOC lays out the entries.
+type tocOptions struct {
+    // group is "dirs-first", "files-first" or "mixed", saying whether
+    // directories sort ahead of files, behind them, or in among them
+    group string
+    // rawOrder leaves entries in the order the walk found them rather
+    // than sorting them by name, though group still applies
+    rawOrder bool
+    // lowerExt lowercases the extension of each file's stored name,
+    // leaving the rest of the name as it is
+    lowerExt bool
+    // rootName, if set, is stored as the name of the root's directory
+    // marker in place of its basename
+    rootName string
+    // prefix, if set, is an extra directory everything is put inside, or
+    // with more than one element, like a/b, directories
+    prefix string
+    // trimPrefix, if set, is the directory, or path of directories, that
+    // everything is in, taken away so its contents go at the top
+    trimPrefix string
+    // storeFullPath stores each file under its whole path in the archive
+    // rather than its basename, leaving out the directory markers
+    storeFullPath bool
+    // stamp, if not nil, is every file's timestamp, in place of its
+    // modification time
+    stamp *time.Time
+}
+
+func ValidGroup(group string) bool {
+    return group == "dirs-first" || group == "files-first" || group == "mixed"
+}
+
+// produceTOC flattens root into TOC entries, with the children of each
+// directory sorted by basename (after grouping, see tocOptions). root
+// itself is left untouched.
+//
+// Names that won't store cleanly (see checkName and checkNameConflicts)
+// are complained about, as are empty files: the engine takes any
+// zero-size entry for a directory.
+//
+// root can be a single file, as from convertFileInfo, for callers other
+// than Pack. That gives a TOC of just its entry, with no directory
+// markers, which printVP writes as a VP holding the one file at its top.
+// There's then no directory for a root name, so one is an error.
+func produceTOC(inputDir string, root InputFileOrDir, opts tocOptions) ([]vp.TOCEntry, error) {
+    produce := func(opts tocOptions) ([]vp.TOCEntry, error) {
+        return produceTOC(inputDir, root, opts)
+    }
+    if opts.storeFullPath {
+        return fullPathTOC(opts, produce)
+    }
+    if opts.prefix != "" {
+        return prefixTOC(opts, produce)
+    }
+    if opts.trimPrefix != "" {
+        return trimPrefixTOC(opts, produce)
+    }
+    if !root.isDir {
+        if opts.rootName != "" {
+            return nil, fmt.Errorf("there's no root directory to store as %q, as %v is a file", opts.rootName, root.originalPath)
+        }
+        return fileTOCEntry(root, opts, []vp.TOCEntry{})
+    }
+    out, err := openTOCDir(root, opts, []vp.TOCEntry{})
+    if err != nil {
+        return nil, err
+    }
+    // only the root is renamed
+    opts.rootName = ""
+    // the directories whose entries are being produced, innermost last,
+    // kept here rather than by recursion so depth is only bounded by
+    // memory
+    type tocFrame struct {
+        dir InputFileOrDir
+        children []InputFileOrDir
+        next int
+    }
+    stack := []*tocFrame{{root, sortChildren(root.children, opts), 0}}
+    for len(stack) > 0 {
+        frame := stack[len(stack) - 1]
+        if frame.next == len(frame.children) {
+            out = append(out, vp.TOCEntry {
+                Size: 0,
+                Name: "..",
+                Timestamp: 0,
+                Path: path.Join(frame.dir.originalPath, ".."),
+                IsDir: true,
+            })
+            stack = stack[:len(stack) - 1]
+            continue
+        }
+        c := frame.children[frame.next]
+        frame.next++
+        if c.isDir {
+            if out, err = openTOCDir(c, opts, out); err != nil {
+                return nil, err
+            }
+            stack = append(stack, &tocFrame{c, sortChildren(c.children, opts), 0})
+        } else if out, err = fileTOCEntry(c, opts, out); err != nil {
+            return nil, err
+        }
+    }
+    return out, nil
+}
+
+// openTOCDir appends the directory marker for dir to out, once its
+// children's names are checked, for produceTOC.
+func openTOCDir(dir InputFileOrDir, opts tocOptions, out []vp.TOCEntry) ([]vp.TOCEntry, error) {
+    if opts.lowerExt {
+        if err := checkLowerExtConflicts(dir); err != nil {
+            return nil, err
+        }
+    }
+    if err := checkNameConflicts(dir); err != nil {
+        return nil, err
+    }
+    name, err := checkName(dir.originalPath)
+    if err != nil {
+        return nil, err
+    }
+    if opts.rootName != "" {
+        if err := checkMarkerName("root name", opts.rootName); err != nil {
+            return nil, err
+        }
+        name = opts.rootName
+    }
+    return append(out, vp.TOCEntry {
+        Size: 0,
+        Name: name,
+        Timestamp: 0,
+        Path: dir.originalPath,
+        IsDir: true,
+    }), nil
+}
+
+// fileTOCEntry appends the entry for file to out, for produceTOC.
+func fileTOCEntry(file InputFileOrDir, opts tocOptions, out []vp.TOCEntry) ([]vp.TOCEntry, error) {
+    if file.size == 0 {
+        if err := complain(file.originalPath, "packing it anyway", "%v is empty, and the engine would take it for a directory", file.originalPath); err != nil {
+            return nil, err
+        }
+    }
+    name, err := checkName(file.originalPath)
+    if err != nil {
+        return nil, err
+    }
+    if opts.lowerExt {
+        name = lowerExt(name)
+    }
+    modTime := file.modTime
+    if opts.stamp != nil {
+        modTime = *opts.stamp
+    }
+    return append(out, vp.TOCEntry {
+        Size: file.size,
+        Name: name,
+        Timestamp: int32(modTime.Unix()),
+        Path: file.originalPath,
+    }), nil
+}
+
+// produceContentsTOC is produceTOC for the contents of root rather than
+// root itself: root's children go at the top of the archive, and there's
+// no marker for root, so no root name to set either.
+func produceContentsTOC(inputDir string, root InputFileOrDir, opts tocOptions) ([]vp.TOCEntry, error) {
+    produce := func(opts tocOptions) ([]vp.TOCEntry, error) {
+        return produceContentsTOC(inputDir, root, opts)
+    }
+    if opts.storeFullPath {
+        return fullPathTOC(opts, produce)
+    }
+    if opts.prefix != "" {
+        return prefixTOC(opts, produce)
+    }
+    if opts.trimPrefix != "" {
+        return trimPrefixTOC(opts, produce)
+    }
+    if opts.rootName != "" {
+        return nil, fmt.Errorf("there's no root directory to store as %q", opts.rootName)
+    }
+    if opts.lowerExt {
+        if err := checkLowerExtConflicts(root); err != nil {
+            return nil, err
+        }
+    }
+    if err := checkNameConflicts(root); err != nil {
+        return nil, err
+    }
+    out := []vp.TOCEntry{}
+    for _, c := range sortChildren(root.children, opts) {
+        recursed, err := produceTOC(inputDir, c, opts)
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, recursed...)
+    }
+    return out, nil
+}
+
+// sortChildren returns a copy of children in the order they go into the
+// TOC; the original may be shared with other consumers of the walk.
+func sortChildren(children []InputFileOrDir, opts tocOptions) []InputFileOrDir {
+    sorted := append([]InputFileOrDir{}, children...)
+    sort.SliceStable(sorted, func(i, j int) bool {
+        a, b := sorted[i], sorted[j]
+        if a.isDir != b.isDir {
+            switch opts.group {
+            case "dirs-first":
+                return a.isDir
+            case "files-first":
+                return b.isDir
+            }
+        }
+        return !opts.rawOrder && path.Base(a.originalPath) < path.Base(b.originalPath)
+    })
+    return sorted
+}
+
+// MaxNameBytes is the longest name allowed, which is what fits the name
+// field unless lowered for consumers with a shorter limit. Longer names
+// are an error.
+var MaxNameBytes = vp.MaxNameLength
+
+// storedName is the name p goes into the index as: its basename.
+func storedName(p string) string {
+    return path.Base(p)
+}
+
+// checkName complains about anything in p's basename that won't survive
+// the trip into the index, and returns the name to store.
+func checkName(p string) (string, error) {
+    name := path.Base(p)
+    if MaxNameBytes < vp.MaxNameLength && len(name) > MaxNameBytes {
+        return "", fmt.Errorf("%w: %q is %d bytes, more than the %d allowed by --max-name-bytes", vp.ErrNameTooLong, name, len(name), MaxNameBytes)
+    }
+    if len(name) > vp.MaxNameLength {
+        return "", fmt.Errorf("%w: %q is %d bytes, more than the %d that fit", vp.ErrNameTooLong, name, len(name), vp.MaxNameLength)
+    }
+    // the engine splits paths at either; a / can only get here from a
+    // caller that didn't split a path into directories first
+    if strings.ContainsAny(name, "/\\") {
+        return "", fmt.Errorf("name %q has a path separator in it, so would be read back as a path", name)
+    }
+    for _, r := range name {
+        if r > unicode.MaxASCII {
+            if err := complain(p, "", "name %q isn't plain ASCII", name); err != nil {
+                return "", err
+            }
+            break
+        }
+    }
+    return storedName(p), nil
+}
+
+// prefixElements splits p, a path of directories in the archive like
+// data/maps, into their names, each checked with checkMarkerName as what.
+func prefixElements(what string, p string) ([]string, error) {
+    elements := strings.Split(p, "/")
+    for _, name := range elements {
+        if name == "" {
+            return nil, fmt.Errorf("%v %q has an empty directory name in it", what, p)
+        }
+        if err := checkMarkerName(what, name); err != nil {
+            return nil, err
+        }
+    }
+    return elements, nil
+}
+
+// prefixTOC handles opts with a prefix for produceTOC and the like: the
+// TOC produce makes without the prefix, wrapped in markers for each of
+// the prefix's directories. trimPrefixTOC undoes it.
+func prefixTOC(opts tocOptions, produce func(tocOptions) ([]vp.TOCEntry, error)) ([]vp.TOCEntry, error) {
+    elements, err := prefixElements("prefix", opts.prefix)
+    if err != nil {
+        return nil, err
+    }
+    prefix := elements[0]
+    if len(elements) > 1 {
+        opts.prefix = strings.Join(elements[1:], "/")
+        inner, err := prefixTOC(opts, produce)
+        if err != nil || len(inner) == 0 {
+            return inner, err
+        }
+        return wrapMarker(prefix, inner), nil
+    }
+    opts.prefix = ""
+    inner, err := produce(opts)
+    if err != nil || len(inner) == 0 {
+        return inner, err
+    }
+    depth := 0
+    for _, entry := range inner {
+        if depth == 0 && entry.Name != ".." && strings.EqualFold(entry.Name, prefix) {
+            return nil, fmt.Errorf("prefix %q collides with the top level entry %v", prefix, entry.Name)
+        }
+        if entry.IsDir && entry.Name == ".." {
+            depth--
+        } else if entry.IsDir {
+            depth++
+        }
+    }
+    return wrapMarker(prefix, inner), nil
+}
+
+// wrapMarker is toc inside a directory called name.
+func wrapMarker(name string, toc []vp.TOCEntry) []vp.TOCEntry {
+    out := []vp.TOCEntry{{
+        Size: 0,
+        Name: name,
+        Timestamp: 0,
+        Path: name,
+        IsDir: true,
+    }}
+    out = append(out, toc...)
+    return append(out, vp.TOCEntry {
+        Size: 0,
+        Name: "..",
+        Timestamp: 0,
+        Path: path.Join(name, ".."),
+        IsDir: true,
+    })
+}
+
+// trimPrefixTOC handles opts with a prefix to trim for produceTOC and the
+// like: the TOC produce makes, without the markers for the trimmed
+// directories, so what's in them goes at the top. Anything that isn't in
+// them is an error.
+func trimPrefixTOC(opts tocOptions, produce func(tocOptions) ([]vp.TOCEntry, error)) ([]vp.TOCEntry, error) {
+    elements, err := prefixElements("prefix to trim", opts.trimPrefix)
+    if err != nil {
+        return nil, err
+    }
+    opts.trimPrefix = ""
+    toc, err := produce(opts)
+    if err != nil {
+        return nil, err
+    }
+    out := []vp.TOCEntry{}
+    // the directories open at this point, and whether all of the prefix
+    // has been, so the entries now are inside it
+    open := []string{}
+    inside := false
+    for _, entry := range toc {
+        if entry.IsDir && entry.Name == ".." {
+            if len(open) > len(elements) {
+                out = append(out, entry)
+            }
+            if len(open) > 0 {
+                open = open[:len(open) - 1]
+            }
+            continue
+        }
+        depth := len(open)
+        if entry.IsDir {
+            open = append(open, entry.Name)
+        }
+        if depth >= len(elements) && inside {
+            out = append(out, entry)
+            continue
+        }
+        if !entry.IsDir || entry.Name != elements[depth] {
+            return nil, fmt.Errorf("%v isn't in %v, the prefix to trim", strings.Join(append(open[:depth:depth], entry.Name), "/"), strings.Join(elements, "/"))
+        }
+        inside = len(open) == len(elements)
+    }
+    return out, nil
+}
+
+// fullPathTOC handles opts with storeFullPath for produceTOC and the
+// like: the usual TOC from produce, flattened into just the files, each
+// named with its path from the top of the archive. Paths too long for the
+// name field are an error, as are two paths differing only in case: with
+// no directories left to merge them, the engine would find just one of
+// the files.
+func fullPathTOC(opts tocOptions, produce func(tocOptions) ([]vp.TOCEntry, error)) ([]vp.TOCEntry, error) {
+    opts.storeFullPath = false
+    toc, err := produce(opts)
+    if err != nil {
+        return nil, err
+    }
+    paths, err := vp.ArchivePaths(toc)
+    if err != nil {
+        return nil, err
+    }
+    out := []vp.TOCEntry{}
+    seen := map[string]string{}
+    for i, entry := range toc {
+        if entry.IsDir {
+            continue
+        }
+        if len(paths[i]) > MaxNameBytes {
+            return nil, fmt.Errorf("%v is %d bytes as a full path, more than the %d that fit; it needs storing without --store-full-path", paths[i], len(paths[i]), MaxNameBytes)
+        }
+        if other, ok := seen[strings.ToLower(paths[i])]; ok {
+            return nil, fmt.Errorf("%v and %v differ only in case, so can't both be stored by full path", other, paths[i])
+        }
+        seen[strings.ToLower(paths[i])] = paths[i]
+        entry.Name = paths[i]
+        out = append(out, entry)
+    }
+    return out, nil
+}
+
+// checkMarkerName fails if name, given as the what option, can't stand
+// in for a directory marker's name.
+func checkMarkerName(what string, name string) error {
+    if len(name) > MaxNameBytes {
+        return fmt.Errorf("%v %q is %d bytes, more than the %d that fit", what, name, len(name), MaxNameBytes)
+    }
+    if name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") {
+        return fmt.Errorf("%v %q isn't a single directory name", what, name)
+    }
+    return nil
+}
+
+// lowerExt lowercases the extension of name, from its last dot on.
+func lowerExt(name string) string {
+    ext := path.Ext(name)
+    return name[:len(name) - len(ext)] + strings.ToLower(ext)
+}
+
+// checkLowerExtConflicts fails if two files in dir would end up with the
+// same stored name once their extensions are lowercased, as with a.TGA
+// and a.tga.
+func checkLowerExtConflicts(dir InputFileOrDir) error {
+    seen := map[string]string{}
+    for _, c := range dir.children {
+        if c.isDir {
+            continue
+        }
+        name := lowerExt(storedName(c.originalPath))
+        if other, ok := seen[name]; ok {
+            return fmt.Errorf("%v and %v would both be stored as %v with their extensions lowercased", other, c.originalPath, name)
+        }
+        seen[name] = c.originalPath
+    }
+    return nil
+}
+
+// checkNameConflicts complains about children of dir that the engine can't
+// tell apart. It looks names up case-insensitively, so two children whose
+// stored names differ only by case are
+// ambiguous: files shadow each other, and directories are merged.
+func checkNameConflicts(dir InputFileOrDir) error {
+    kind := func(f InputFileOrDir) string {
+        if f.isDir {
+            return "directory"
+        }
+        return "file"
+    }
+    seen := map[string]InputFileOrDir{}
+    for _, c := range dir.children {
+        key := strings.ToLower(storedName(c.originalPath))
+        other, ok := seen[key]
+        if !ok {
+            seen[key] = c
+            continue
+        }
+        if other.isDir && c.isDir {
+            // the engine doesn't tell them apart, so merges them
+            if err := complain(dir.originalPath, "", "directories %v and %v differ only in case, so their contents would be merged", other.originalPath, c.originalPath); err != nil {
+                return err
+            }
+            continue
+        }
+        if err := complain(dir.originalPath, "", "%v %v and %v %v are stored under the same name", kind(other), other.originalPath, kind(c), c.originalPath); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Transform rewrites a file's contents on their way into a VP, given the
+// file's path and its original contents. It returns the new contents and
+// how many bytes long they are, which must be above 0 unless the file was
+// empty already: an empty file would be taken for a directory.
+type Transform func(path string, r io.Reader) (io.Reader, int64, error)
+
+// chainTransforms applies first and then next, which gets what first gives
+// and says how long the result is; next can be nil.
+func chainTransforms(first, next Transform) Transform {
+    if next == nil {
+        return first
+    }
+    return func(p string, r io.Reader) (io.Reader, int64, error) {
+        r, _, err := first(p, r)
+        if err != nil {
+            return nil, 0, err
+        }
+        return next(p, r)
+    }
+}
+
+// printOptions says how printVP should write a VP.
+type printOptions struct {
+    // twoPass, with an out that can seek, stops the sizes being summed up
+    // front: the header goes out with a placeholder index offset, which is
+    // patched once the data has been written and counted. Writers that
+    // can't seek get the sizes summed as usual.
+    twoPass bool
+    // skips, if not nil, is where files left out under skipMissing are
+    // noted
+    skips skipLog
+    // transform, if not nil, is applied to each file as it's written.
+    // It only sees the files that made it into the TOC, so runs after
+    // everything that filters files out, and after splitting, which goes
+    // by the sizes from the walk; those are only advisory once contents
+    // are transformed.
+    transform Transform
+    // progress, if not nil, is told the file data written so far
+    progress func(written, total int64)
+    // namePad fills each name field after the NUL ending the name; it's
+    // only not NUL to match older packers
+    namePad byte
+    // skipMissing leaves out files that have gone since the walk, with a
+    // warning, rather than failing. When the header is patched (see
+    // twoPass) that holds right up to each file being copied; otherwise
+    // the header has to be right before any data is written, so files
+    // are only checked for before that, and one going after still fails.
+    skipMissing bool
+    // smallFirst lays the data out smallest file first instead of in
+    // index order, so a reader that starts at the front gets the small
+    // files early. The index is in TOC order either way; each entry says
+    // where its data is.
+    smallFirst bool
+    // retries is how many times a file whose copy fails with a transient
+    // error (see transientError) is written again from its start before
+    // giving up. Only the file in progress is retried, never the whole
+    // VP, and only with an out that can seek back to where it started.
+    retries int
+}
+
+// printVP writes toc out as a VP, reading file contents from src. It stops
+// as soon as it can once ctx is cancelled.
+//
+// The index records where each entry's data really landed, and if a file
+// turns out not to be the size the TOC (or transform) says, printVP fails
+// rather than write an index that disagrees with the header.
+func printVP(ctx context.Context, in InputFileOrDir, toc []vp.TOCEntry, src fileSource, out io.Writer, opts printOptions) error {
+    seeker, canSeek := out.(io.WriteSeeker)
+    patchHeader := canSeek && opts.twoPass
+    missing := make([]bool, len(toc))
+    skip := func(i int, err error) {
+        warnf(toc[i].Path, "%v, leaving it out", err)
+        opts.skips.note(toc[i].Path, skipUnreadable)
+        missing[i] = true
+    }
+    if opts.skipMissing && !patchHeader {
+        for i, entry := range toc {
+            if entry.IsDir {
+                continue
+            }
+            f, err := src.Open(entry.Path)
+            if os.IsNotExist(err) {
+                skip(i, err)
+                continue
+            }
+            if err != nil {
+                return err
+            }
+            f.Close()
+        }
+    }
+    sizes := make([]int32, len(toc))
+    var progressTotal int64 = 0
+    for i, entry := range toc {
+        if !missing[i] {
+            sizes[i] = entry.Size
+            progressTotal += int64(entry.Size)
+        }
+    }
+    // without patching, the header needs the transformed sizes before any
+    // data is written, so each file is transformed once just to size it
+    sized := !patchHeader && opts.transform != nil
+    if sized {
+        for i, entry := range toc {
+            if entry.IsDir || missing[i] {
+                continue
+            }
+            _, c, size, err := openFile(src, entry, opts.transform)
+            if err != nil {
+                return err
+            }
+            c.Close()
+            sizes[i] = size
+        }
+    }
+    var totalSize int32 = 0
+    if !patchHeader {
+        for _, size := range sizes {
+            totalSize += size
+            if totalSize < 0 {
+                return fmt.Errorf("%w: overflowed totalSize, %v producing %v", vp.ErrSizeOverflow, totalSize, in.originalPath)
+            }
+        }
+        progressTotal = int64(totalSize)
+    }
+
+    count := func() int32 {
+        n := int32(0)
+        for _, m := range missing {
+            if !m {
+                n++
+            }
+        }
+        return n
+    }
+
+    cw := &vp.CountingWriter{W: out}
+    cw.Write(vp.EncodeHeader(totalSize + vp.HeaderSize, count()))
+    order := dataOrder(sizes, opts.smallFirst)
+    offsets := make([]int32, len(toc))
+    var written int64 = 0
+    copyEntry := func(entry vp.TOCEntry) (int32, error) {
+        r, c, size, err := openFile(src, entry, opts.transform)
+        if err != nil {
+            return 0, err
+        }
+        defer c.Close()
+        written, err = copyContext(ctx, cw, r, written, progressTotal, opts.progress)
+        return size, err
+    }
+    for _, i := range order {
+        entry := toc[i]
+        offsets[i] = int32(cw.N)
+        if entry.IsDir || missing[i] {
+            continue
+        }
+        start, startWritten := cw.N, written
+        size, err := copyEntry(entry)
+        for attempt := 1; err != nil && canSeek && attempt <= opts.retries && transientError(err); attempt++ {
+            warnf(entry.Path, "%v, writing %v again from the start (retry %d of %d)", err, entry.Path, attempt, opts.retries)
+            if _, err = seeker.Seek(start, io.SeekStart); err != nil {
+                break
+            }
+            cw.N, cw.Err, written = start, nil, startWritten
+            size, err = copyEntry(entry)
+        }
+        if os.IsNotExist(err) && opts.skipMissing && patchHeader {
+            skip(i, err)
+            continue
+        }
+        if err != nil {
+            return fmt.Errorf("writing %v: %w", entry.Path, err)
+        }
+        if !sized {
+            sizes[i] = size
+        }
+
+        if copied := cw.N - start; copied != int64(sizes[i]) {
+            if opts.transform != nil {
+                return fmt.Errorf("transformed %v is %d bytes, but the transform said %d", entry.Path, copied, sizes[i])
+            }
+            return fmt.Errorf("%v is %d bytes, but the TOC says %d; did it change during the pack?", entry.Path, copied, sizes[i])
+        }
+    }
+    if patchHeader && cw.Err == nil {
+        indexOffset := cw.N
+        if indexOffset > math.MaxInt32 {
+            return fmt.Errorf("%w: overflowed totalSize, %v producing %v", vp.ErrSizeOverflow, indexOffset - 16, in.originalPath)
+        }
+        if _, err := seeker.Seek(8, io.SeekStart); err != nil {
+            return err
+        }
+        if err := binary.Write(seeker, binary.LittleEndian, []int32{int32(indexOffset), count()}); err != nil {
+            return err
+        }
+        if _, err := seeker.Seek(indexOffset, io.SeekStart); err != nil {
+            return err
+        }
+    }
+    for i, entry := range toc {
+        if missing[i] {
+            continue
+        }
+        cw.Write(vp.EncodeIndexEntry(entry.Name, offsets[i], sizes[i], entry.Timestamp, opts.namePad))
+    }
+    return cw.Err
+}
+
+// dataOrder is the order printVP writes the entries of a TOC in, given
+// their sizes: the TOC's own order, or with smallFirst, smallest first.
+func dataOrder(sizes []int32, smallFirst bool) []int {
+    order := make([]int, len(sizes))
+    for i := range order {
+        order[i] = i
+    }
+    if smallFirst {
+        sort.SliceStable(order, func(a, b int) bool {
+            return sizes[order[a]] < sizes[order[b]]
+        })
+    }
+    return order
+}
+
+// dataOffsets works out the offset printVP will record for each entry of
+// toc, when every file is written at the size the TOC gives. A directory
+// gets the offset of whatever data follows it.
+func dataOffsets(toc []vp.TOCEntry, smallFirst bool) []int32 {
+    sizes := make([]int32, len(toc))
+    for i, entry := range toc {
+        sizes[i] = entry.Size
+    }
+    offsets := make([]int32, len(toc))
+    next := int32(vp.HeaderSize)
+    for _, i := range dataOrder(sizes, smallFirst) {
+        offsets[i] = next
+        next += sizes[i]
+    }
+    return offsets
+}
+
+// transientError says whether err looks like it might go away if the
+// operation is tried again, as network filesystems give: anything that
+// says it's temporary or a timeout, interrupted or would-block calls, and
+// I/O errors.
+func transientError(err error) bool {
+    var temporary interface {
+        Temporary() bool
+    }
+    if errors.As(err, &temporary) && temporary.Temporary() {
+        return true
+    }
+    for _, errno := range []syscall.Errno{syscall.EINTR, syscall.EAGAIN, syscall.EIO, syscall.ETIMEDOUT} {
+        if errors.Is(err, errno) {
+            return true
+        }
+    }
+    return false
+}
+
+// vpSize is how many bytes the VP printVP writes for toc comes to: the
+// header, every file's data, and an index entry for everything.
+func vpSize(toc []vp.TOCEntry) int64 {
+    size := int64(vp.HeaderSize) + int64(len(toc)) * vp.IndexEntrySize
+    for _, entry := range toc {
+        size += int64(entry.Size)
+    }
+    return size
+}
+
+// openFile opens entry's contents from src, through transform if it's not
+// nil, and says how many bytes they come to. The closer is for the file
+// underneath.
+func openFile(src fileSource, entry vp.TOCEntry, transform Transform) (io.Reader, io.Closer, int32, error) {
+    f, err := src.Open(entry.Path)
+    if err != nil {
+        return nil, nil, 0, err
+    }
+    if transform == nil {
+        return f, f, entry.Size, nil
+    }
+    r, size, err := transform(entry.Path, f)
+    if err != nil {
+        f.Close()
+        return nil, nil, 0, fmt.Errorf("transforming %v: %w", entry.Path, err)
+    }
+    if size < 0 || (size == 0 && entry.Size > 0) || size > math.MaxInt32 {
+        f.Close()
+        return nil, nil, 0, fmt.Errorf("transforming %v gave %d bytes, which can't be stored", entry.Path, size)
+    }
+    return r, f, int32(size), nil
+}
+
+// splitOptions says where splitTOCs should break a TOC up. Zero values
+// mean no limit.
+type splitOptions struct {
+    // maxSize caps the bytes of file data in a chunk
+    maxSize int32
+    // maxEntries caps the index entries in a chunk
+    maxEntries int
+    // targetSize is the size, header and index included, that chunks
+    // are filled up to before starting the next, for uniform parts
+    targetSize int64
+}
+
+// dominantFraction is how much of a split limit one file has to take up
+// before --explain-split and --estimate call it out. A file that big is
+// usually a packaging mistake, like a video or texture that was meant to
+// be compressed or left out.
+const dominantFraction = 0.5
+
+// dominantFiles describes each file in chunk that takes up more than
+// dominantFraction of the size limit in opts (the smaller of maxSize and
+// targetSize, where set), or goes over it on its own.
+func dominantFiles(chunk []vp.TOCEntry, opts splitOptions) []string {
+    limit, what := int64(opts.maxSize), "--max-vp-size"
+    if opts.targetSize > 0 && (limit <= 0 || opts.targetSize < limit) {
+        limit, what = opts.targetSize, "--target-size"
+    }
+    if limit <= 0 {
+        return nil
+    }
+    out := []string{}
+    for _, entry := range chunk {
+        size := int64(entry.Size)
+        switch {
+        case entry.IsDir:
+        case size > limit:
+            out = append(out, fmt.Sprintf("%v is %d bytes, over the %v of %d on its own, so gets a VP to itself", entry.Path, size, what, limit))
+        case float64(size) > float64(limit) * dominantFraction:
+            out = append(out, fmt.Sprintf("%v is %d bytes, %d%% of the %v of %d", entry.Path, size, size * 100 / limit, what, limit))
+        }
+    }
+    return out
+}
+
+// function splitTOCs splits
+// TOC entries to ensure nothing overflows the limits in opts. Every chunk
+// closes the directories it has open with ".." markers, and the next chunk
+// re-opens them, so each one stands alone as a VP; that scaffolding counts
+// towards the limits. A single file too big for any limit on its own gets
+// a chunk to itself.
+func splitTOCs(toc []vp.TOCEntry, opts splitOptions) ([][]vp.TOCEntry, error) {
+    out := [][]vp.TOCEntry{}
+    var totalSize int32 = 0
+    current := []vp.TOCEntry{}
+    // the directories open at this point in the TOC, outermost first
+    openDirs := []vp.TOCEntry{}
+    hasFiles := false
+    for _, entry := range toc {
+        if entry.IsDir && entry.Name == ".." {
+            // its entry was already counted when the directory was opened
+            current = append(current, entry)
+            if len(openDirs) > 0 {
+                openDirs = openDirs[:len(openDirs) - 1]
+            }
+            continue
+        }
+        // entries current would need with this one in it: the entry, its
+        // own ".." if it's a directory, and a ".." for everything open
+        needed := func() int {
+            n := len(current) + 1 + len(openDirs)
+            if entry.IsDir {
+                n++
+            }
+            return n
+        }
+        tooBig := func() bool {
+            size := totalSize + entry.Size
+            if size < 0 || (opts.maxSize > 0 && size > opts.maxSize) {
+                return true
+            }
+            if opts.maxEntries > 0 && needed() > opts.maxEntries {
+                return true
+            }
+            return opts.targetSize > 0 && vp.HeaderSize + int64(size) + int64(needed()) * vp.IndexEntrySize > opts.targetSize
+        }
+        if hasFiles && tooBig() {
+            out = append(out, closeDirs(current, openDirs))
+            totalSize = 0
+            current = append([]vp.TOCEntry{}, openDirs...)
+            hasFiles = false
+        }
+        if opts.maxEntries > 0 && needed() > opts.maxEntries {
+            return nil, fmt.Errorf("--max-entries %d is too small: %v needs %d entries once its parent directories are included", opts.maxEntries, entry.Path, needed())
+        }
+        totalSize += entry.Size
+        current = append(current, entry)
+        if entry.IsDir {
+            openDirs = append(openDirs, entry)
+        } else {
+            hasFiles = true
+        }
+    }
+    out = append(out, current)
+    return out, nil
+}
+
+// vpPart is one VP's worth of a split TOC, with the file it's to be
+// written to and the source paths of the files that went into it.
+type vpPart struct {
+    filename string
+    toc []vp.TOCEntry
+    sources []string
+}
+
+// nameParts names the chunks splitTOCs made of the TOC for base: base.vp
+// if there's only one, otherwise base-01.vp, base-02.vp and so on.
+func nameParts(base string, split [][]vp.TOCEntry) []vpPart {
+    parts := make([]vpPart, len(split))
+    for i, chunk := range split {
+        filename := fmt.Sprintf("%s.vp", base)
+        if len(split) > 1 {
+            filename = fmt.Sprintf("%s-%02d.vp", base, i + 1)
+        }
+        sources := []string{}
+        for _, entry := range chunk {
+            if !entry.IsDir {
+                sources = append(sources, entry.Path)
+            }
+        }
+        parts[i] = vpPart{filename, chunk, sources}
+    }
+    return parts
+}
+
+// checkChunkPaths makes sure no two files in chunk, one VP's worth of TOC,
+// would end up at the same path inside it once the directory markers are
+// followed, which would leave one shadowing the other.
+func checkChunkPaths(chunk []vp.TOCEntry) error {
+    paths, err := vp.ArchivePaths(chunk)
+    if err != nil {
+        return err
+    }
+    seen := map[string]string{}
+    for i, p := range paths {
+        if chunk[i].IsDir {
+            continue
+        }
+        if other, ok := seen[p]; ok {
+            return fmt.Errorf("%v and %v would both be stored as %v", other, chunk[i].Path, p)
+        }
+        seen[p] = chunk[i].Path
+    }
+    return nil
+}
+
+// checkSplitPaths makes sure no file path turns up in more than one of
+// the chunks split is made of. The engine loads the parts together, so a
+// path in two of them would have one shadow the other depending on load
+// order. Paths are compared ignoring case, as the engine looks them up;
+// the directory markers every part repeats don't count.
+func checkSplitPaths(split [][]vp.TOCEntry) error {
+    type location struct {
+        originalPath string
+        part int
+    }
+    seen := map[string]location{}
+    problems := []string{}
+    for part, chunk := range split {
+        paths, err := vp.ArchivePaths(chunk)
+        if err != nil {
+            return err
+        }
+        for i, p := range paths {
+            if chunk[i].IsDir {
+                continue
+            }
+            key := strings.ToLower(p)
+            other, ok := seen[key]
+            if !ok {
+                seen[key] = location{chunk[i].Path, part}
+                continue
+            }
+            if other.part != part {
+                problems = append(problems, fmt.Sprintf("%v (part %d) and %v (part %d) are both at %v", other.originalPath, other.part + 1, chunk[i].Path, part + 1, p))
+            }
+        }
+    }
+    if len(problems) > 0 {
+        return fmt.Errorf("files would shadow each other across split parts: %v", strings.Join(problems, "; "))
+    }
+    return nil
+}
+
+// closeDirs appends a ".." marker to chunk for each of openDirs, innermost
+// first.
+func closeDirs(chunk []vp.TOCEntry, openDirs []vp.TOCEntry) []vp.TOCEntry {
+    for i := len(openDirs) - 1; i >= 0; i-- {
+        chunk = append(chunk, vp.TOCEntry {
+            Size: 0,
+            Name: "..",
+            Timestamp: 0,
+            Path: path.Join(openDirs[i].Path, ".."),
+            IsDir: true,
+        })
+    }
+    return chunk
+}
@@ -0,0 +1,497 @@
+package aztech
+
+import (
+    "bytes"
+    "context"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "os"
+    "reflect"
+    "runtime/debug"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/tcrayford/aztech/vp"
+)
+
+// flatTOC is the TOC of a data directory holding files of the given sizes,
+// named f0, f1 and so on.
+func flatTOC(sizes ...int32) []vp.TOCEntry {
+    toc := []vp.TOCEntry{{Name: "data", Path: "in/data", IsDir: true}}
+    for i, size := range sizes {
+        name := fmt.Sprintf("f%d", i)
+        toc = append(toc, vp.TOCEntry{Name: name, Size: size, Path: "in/data/" + name})
+    }
+    return append(toc, vp.TOCEntry{Name: "..", IsDir: true})
+}
+
+func TestSplitTOCsByEntryCount(t *testing.T) {
+    // tiny files, so only the entry count can force a split
+    toc := flatTOC(1, 1, 1, 1)
+    split, err := splitTOCs(toc, splitOptions{maxSize: 1000000000, maxEntries: 5})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(split) != 2 {
+        t.Fatalf("got %d chunks, want 2", len(split))
+    }
+    files := []string{}
+    for i, chunk := range split {
+        // data and its ".." count towards the limit in every chunk
+        if len(chunk) > 5 {
+            t.Errorf("chunk %d has %d entries, over the limit of 5", i, len(chunk))
+        }
+        paths, err := vp.ArchivePaths(chunk)
+        if err != nil {
+            t.Errorf("chunk %d: %v", i, err)
+        }
+        for j, entry := range chunk {
+            if !entry.IsDir {
+                files = append(files, paths[j])
+            }
+        }
+    }
+    if want := []string{"data/f0", "data/f1", "data/f2", "data/f3"}; !reflect.DeepEqual(files, want) {
+        t.Errorf("chunks hold %q, want %q", files, want)
+    }
+
+    // without the limit, it all fits in one
+    split, err = splitTOCs(toc, splitOptions{maxSize: 1000000000})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(split) != 1 {
+        t.Errorf("got %d chunks without an entry limit, want 1", len(split))
+    }
+}
+
+func TestSplitTOCsEntryLimitTooSmall(t *testing.T) {
+    // data, a file and data's ".." can't fit in 2
+    _, err := splitTOCs(flatTOC(1), splitOptions{maxEntries: 2})
+    if err == nil {
+        t.Error("no error for an entry limit too small for any file")
+    }
+}
+
+// unsortedTree is an input tree whose children are out of order, so
+// anything sorting them in place would show.
+func unsortedTree() InputFileOrDir {
+    t := time.Unix(1000, 0)
+    file := func(p string, size int32) InputFileOrDir {
+        return InputFileOrDir{p, size, t, false, []InputFileOrDir{}}
+    }
+    maps := InputFileOrDir{"in/data/maps", 0, t, true, []InputFileOrDir{
+        file("in/data/maps/b.pof", 2),
+        file("in/data/maps/a.pof", 1),
+    }}
+    return InputFileOrDir{"in/data", 0, t, true, []InputFileOrDir{
+        file("in/data/zeta.tbl", 3),
+        maps,
+        file("in/data/alpha.tbl", 4),
+    }}
+}
+
+func TestProduceTOCLeavesTreeAlone(t *testing.T) {
+    tree := unsortedTree()
+    first, err := produceTOC("in", tree, tocOptions{group: "dirs-first"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !reflect.DeepEqual(tree, unsortedTree()) {
+        t.Fatalf("produceTOC reordered the tree: %+v", tree)
+    }
+    second, err := produceTOC("in", tree, tocOptions{group: "dirs-first"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !reflect.DeepEqual(tree, unsortedTree()) {
+        t.Fatalf("a second produceTOC reordered the tree: %+v", tree)
+    }
+    if !reflect.DeepEqual(first, second) {
+        t.Errorf("the same tree gave two TOCs:\n%+v\n%+v", first, second)
+    }
+    paths, err := vp.ArchivePaths(first)
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []string{"data", "data/maps", "data/maps/a.pof", "data/maps/b.pof", "data", "data/alpha.tbl", "data/zeta.tbl", "."}
+    if !reflect.DeepEqual(paths, want) {
+        t.Errorf("got %q, want %q", paths, want)
+    }
+}
+
+// sizedTOC is the TOC of data/a.tbl, said to be size bytes long.
+func sizedTOC(size int32) []vp.TOCEntry {
+    return []vp.TOCEntry{
+        {Name: "data", Path: "in/data", IsDir: true},
+        {Name: "a.tbl", Size: size, Path: "in/data/a.tbl"},
+        {Name: "..", Path: "in", IsDir: true},
+    }
+}
+
+func TestPrintVPCatchesWrongSize(t *testing.T) {
+    src := memSource{nil, map[string][]byte{"in/data/a.tbl": []byte("hi\n")}}
+    in := InputFileOrDir{"in/data", 0, time.Unix(0, 0), true, []InputFileOrDir{}}
+    temp := func() io.Writer {
+        f, err := os.CreateTemp(t.TempDir(), "*.vp")
+        if err != nil {
+            t.Fatal(err)
+        }
+        t.Cleanup(func() { f.Close() })
+        return f
+    }
+    for _, size := range []int32{2, 5} {
+        for _, twoPass := range []bool{false, true} {
+            var out io.Writer = &bytes.Buffer{}
+            if twoPass {
+                out = temp()
+            }
+            err := printVP(context.Background(), in, sizedTOC(size), src, out, printOptions{twoPass: twoPass})
+            if err == nil {
+                t.Errorf("size %d, twoPass %v: no error for a 3 byte file", size, twoPass)
+                continue
+            }
+            if want := fmt.Sprintf("is 3 bytes, but the TOC says %d", size); !strings.Contains(err.Error(), want) {
+                t.Errorf("size %d, twoPass %v: error %q doesn't say %q", size, twoPass, err, want)
+            }
+        }
+    }
+
+    // a transform that gets its own size wrong is caught the same way
+    lying := func(p string, r io.Reader) (io.Reader, int64, error) {
+        return r, 4, nil
+    }
+    err := printVP(context.Background(), in, sizedTOC(3), src, &bytes.Buffer{}, printOptions{transform: lying})
+    if err == nil || !strings.Contains(err.Error(), "is 3 bytes, but the transform said 4") {
+        t.Errorf("got %v for a transform giving the wrong size", err)
+    }
+
+    // and the right size goes through
+    if err := printVP(context.Background(), in, sizedTOC(3), src, &bytes.Buffer{}, printOptions{}); err != nil {
+        t.Errorf("the right size failed: %v", err)
+    }
+}
+
+// stderrOf runs f and returns what it wrote to stderr, where warnings go.
+func stderrOf(t *testing.T, f func()) string {
+    r, w, err := os.Pipe()
+    if err != nil {
+        t.Fatal(err)
+    }
+    saved := os.Stderr
+    os.Stderr = w
+    defer func() { os.Stderr = saved }()
+    out := make(chan []byte)
+    go func() {
+        b, _ := io.ReadAll(r)
+        out <- b
+    }()
+    f()
+    w.Close()
+    return string(<-out)
+}
+
+func TestProduceTOCCaseCollidingDirectories(t *testing.T) {
+    stamp := time.Unix(1000, 0)
+    file := func(p string) InputFileOrDir {
+        return InputFileOrDir{p, 1, stamp, false, []InputFileOrDir{}}
+    }
+    tree := InputFileOrDir{"in/data", 0, stamp, true, []InputFileOrDir{
+        {"in/data/Maps", 0, stamp, true, []InputFileOrDir{file("in/data/Maps/a.pof")}},
+        {"in/data/maps", 0, stamp, true, []InputFileOrDir{file("in/data/maps/b.pof")}},
+    }}
+
+    var err error
+    warnings := stderrOf(t, func() {
+        _, err = produceTOC("in", tree, tocOptions{})
+    })
+    if err != nil {
+        t.Fatalf("failed outside --strict: %v", err)
+    }
+    for _, want := range []string{"in/data/Maps", "in/data/maps", "differ only in case"} {
+        if !strings.Contains(warnings, want) {
+            t.Errorf("warning %q doesn't mention %q", warnings, want)
+        }
+    }
+
+    StrictMode = true
+    defer func() { StrictMode = false }()
+    _, err = produceTOC("in", tree, tocOptions{})
+    if err == nil {
+        t.Fatal("no error under --strict")
+    }
+    if want := "directories in/data/Maps and in/data/maps differ only in case"; !strings.Contains(err.Error(), want) {
+        t.Errorf("error %q doesn't say %q", err, want)
+    }
+}
+
+// BenchmarkWalkMostlyExcluded walks a directory of 100k files where all
+// but one in a hundred are excluded, listing it with os.ReadDir and only
+// stat'ing what's kept, against lstat'ing every entry up front.
+func BenchmarkWalkMostlyExcluded(b *testing.B) {
+    in := b.TempDir()
+    for i := 0; i < 100000; i++ {
+        ext := ".tmp"
+        if i % 100 == 0 {
+            ext = ".tbl"
+        }
+        f, err := os.Create(fmt.Sprintf("%v/f%06d%v", in, i, ext))
+        if err != nil {
+            b.Fatal(err)
+        }
+        f.Close()
+    }
+    filter := pathFilter{exclude: []string{"*.tmp"}}
+    for _, statAll := range []bool{false, true} {
+        name := "ReadDir"
+        if statAll {
+            name = "Lstat"
+        }
+        b.Run(name, func(b *testing.B) {
+            for i := 0; i < b.N; i++ {
+                tree, err := walkDir(in, walkOptions{filter: filter, statAll: statAll})
+                if err != nil {
+                    b.Fatal(err)
+                }
+                if len(tree.children) != 1000 {
+                    b.Fatalf("kept %d files, want 1000", len(tree.children))
+                }
+            }
+        })
+    }
+}
+
+func TestPrintVPSmallFirstRoundTrip(t *testing.T) {
+    contents := map[string][]byte{
+        "in/data/a.tbl": []byte("largest of all"),
+        "in/data/b.tbl": []byte("x"),
+        "in/data/c.tbl": []byte("middle"),
+    }
+    toc := []vp.TOCEntry{{Name: "data", Path: "in/data", IsDir: true}}
+    for _, name := range []string{"a.tbl", "b.tbl", "c.tbl"} {
+        toc = append(toc, vp.TOCEntry{Name: name, Size: int32(len(contents["in/data/" + name])), Path: "in/data/" + name})
+    }
+    toc = append(toc, vp.TOCEntry{Name: "..", Path: "in", IsDir: true})
+    in := InputFileOrDir{"in/data", 0, time.Unix(0, 0), true, []InputFileOrDir{}}
+
+    var b bytes.Buffer
+    if err := printVP(context.Background(), in, toc, memSource{nil, contents}, &b, printOptions{smallFirst: true}); err != nil {
+        t.Fatal(err)
+    }
+    r := bytes.NewReader(b.Bytes())
+    got, err := vp.ReadTOC(r, int64(b.Len()))
+    if err != nil {
+        t.Fatal(err)
+    }
+    // the index is still in name order
+    paths := []string{}
+    offsets := map[string]int32{}
+    for _, entry := range got {
+        paths = append(paths, entry.Path)
+        if entry.IsDir {
+            continue
+        }
+        offsets[entry.Name] = entry.Offset
+        data, err := io.ReadAll(vp.OpenEntry(r, entry))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if want := contents["in/" + entry.Path]; !bytes.Equal(data, want) {
+            t.Errorf("%v holds %q, want %q", entry.Path, data, want)
+        }
+    }
+    if want := []string{"data", "data/a.tbl", "data/b.tbl", "data/c.tbl", "."}; !reflect.DeepEqual(paths, want) {
+        t.Errorf("index is %q, want %q", paths, want)
+    }
+    // while the data goes smallest first
+    if !(offsets["b.tbl"] < offsets["c.tbl"] && offsets["c.tbl"] < offsets["a.tbl"]) {
+        t.Errorf("data isn't smallest first: offsets %v", offsets)
+    }
+    if offsets["b.tbl"] != vp.HeaderSize {
+        t.Errorf("the smallest file is at %d, want %d, straight after the header", offsets["b.tbl"], vp.HeaderSize)
+    }
+}
+
+func TestInputName(t *testing.T) {
+    for input, want := range map[string]string{
+        "/": "",
+        "mod": "mod",
+        "mod/": "mod",
+        "/x/mod.zip": "mod",
+        "/x/Mod.TAR.GZ": "Mod",
+    } {
+        if got := inputName(input); got != want {
+            t.Errorf("inputName(%q) is %q, want %q", input, got, want)
+        }
+    }
+}
+
+func TestPrintVPHeader(t *testing.T) {
+    src := memSource{nil, map[string][]byte{"in/data/a.tbl": []byte("hi\n")}}
+    in := InputFileOrDir{"in/data", 0, time.Unix(0, 0), true, []InputFileOrDir{}}
+    var b bytes.Buffer
+    if err := printVP(context.Background(), in, sizedTOC(3), src, &b, printOptions{}); err != nil {
+        t.Fatal(err)
+    }
+    header := b.Bytes()[:vp.HeaderSize]
+    if string(header[:4]) != vp.Magic {
+        t.Errorf("starts with %q, want %q", header[:4], vp.Magic)
+    }
+    if version := binary.LittleEndian.Uint32(header[4:8]); version != vp.Version2 {
+        t.Errorf("version is %d, want %d", version, vp.Version2)
+    }
+    // and it's what the reader checks for
+    if _, err := vp.ReadTOC(bytes.NewReader(b.Bytes()), int64(b.Len())); err != nil {
+        t.Error(err)
+    }
+}
+
+func TestProduceTOCPathSeparatorsInNames(t *testing.T) {
+    stamp := time.Unix(1000, 0)
+    file := func(p string) InputFileOrDir {
+        return InputFileOrDir{p, 1, stamp, false, []InputFileOrDir{}}
+    }
+    data := func(children ...InputFileOrDir) InputFileOrDir {
+        return InputFileOrDir{"in/data", 0, stamp, true, children}
+    }
+    tests := []struct {
+        name string
+        tree InputFileOrDir
+        opts tocOptions
+        want string
+    }{
+        {"backslash in a file", data(file(`in/data/maps\a.pof`)), tocOptions{}, `name "maps\\a.pof" has a path separator in it`},
+        {"backslash in a directory", data(InputFileOrDir{`in/data/maps\sub`, 0, stamp, true, []InputFileOrDir{file(`in/data/maps\sub/a.pof`)}}), tocOptions{}, `name "maps\\sub" has a path separator in it`},
+        {"slash in the root name", data(file("in/data/a.tbl")), tocOptions{rootName: "data/maps"}, `root name "data/maps" isn't a single directory name`},
+        {"backslash in the root name", data(file("in/data/a.tbl")), tocOptions{rootName: `data\maps`}, `root name "data\\maps" isn't a single directory name`},
+    }
+    for _, test := range tests {
+        _, err := produceTOC("in", test.tree, test.opts)
+        if err == nil {
+            t.Errorf("%v: no error", test.name)
+            continue
+        }
+        if !strings.Contains(err.Error(), test.want) {
+            t.Errorf("%v: error %q doesn't say %q", test.name, err, test.want)
+        }
+    }
+
+    // a / can't get into a name from a path, as that's where it's split
+    if name, err := checkName("in/data/maps/a.pof"); err != nil || name != "a.pof" {
+        t.Errorf("checkName gave %q, %v, want a.pof", name, err)
+    }
+}
+
+func TestSingleFileRoot(t *testing.T) {
+    root := InputFileOrDir{"in/a.tbl", 3, time.Unix(10000, 0), false, []InputFileOrDir{}}
+    toc, err := produceTOC("in", root, tocOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []vp.TOCEntry{{Name: "a.tbl", Size: 3, Timestamp: 10000, Path: "in/a.tbl"}}
+    if !reflect.DeepEqual(toc, want) {
+        t.Fatalf("got %+v, want %+v", toc, want)
+    }
+
+    var b bytes.Buffer
+    src := memSource{nil, map[string][]byte{"in/a.tbl": []byte("hi\n")}}
+    if err := printVP(context.Background(), root, toc, src, &b, printOptions{}); err != nil {
+        t.Fatal(err)
+    }
+    r := bytes.NewReader(b.Bytes())
+    got, err := vp.ReadTOC(r, int64(b.Len()))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(got) != 1 || got[0].Path != "a.tbl" || got[0].Timestamp != 10000 {
+        t.Fatalf("read back %+v, want just a.tbl", got)
+    }
+    data, err := io.ReadAll(vp.OpenEntry(r, got[0]))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if string(data) != "hi\n" {
+        t.Errorf("a.tbl holds %q, want %q", data, "hi\n")
+    }
+
+    // there's no directory to give a root name to
+    if _, err := produceTOC("in", root, tocOptions{rootName: "data"}); err == nil {
+        t.Error("no error for a root name with a single file")
+    }
+}
+
+// chainTree is depth directories, each inside the last and called d,
+// under in, with a.tbl at the bottom. Paths are left short, as produceTOC
+// only goes by their last element, so it takes memory in line with depth.
+func chainTree(depth int) InputFileOrDir {
+    node := InputFileOrDir{"a.tbl", 1, time.Unix(10000, 0), false, []InputFileOrDir{}}
+    for i := 0; i < depth; i++ {
+        node = InputFileOrDir{"d", 0, time.Unix(10000, 0), true, []InputFileOrDir{node}}
+    }
+    return InputFileOrDir{"in", 0, time.Unix(10000, 0), true, []InputFileOrDir{node}}
+}
+
+func TestProduceTOCDeepTree(t *testing.T) {
+    const depth = 200000
+    // far too little stack for a frame per level
+    defer debug.SetMaxStack(debug.SetMaxStack(1 << 20))
+    toc, err := produceTOC("in", chainTree(depth), tocOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    // in and every d, the file, then a marker back out of each
+    if len(toc) != 2 * (depth + 1) + 1 {
+        t.Fatalf("got %d entries, want %d", len(toc), 2 * (depth + 1) + 1)
+    }
+    for i, entry := range toc {
+        want := ".."
+        switch {
+        case i == 0:
+            want = "in"
+        case i <= depth:
+            want = "d"
+        case i == depth + 1:
+            want = "a.tbl"
+        }
+        if entry.Name != want || entry.IsDir != (want != "a.tbl") {
+            t.Fatalf("entry %d is %+v, want %v", i, entry, want)
+        }
+    }
+}
+
+func TestWalkDirDeepTree(t *testing.T) {
+    in := t.TempDir()
+    // as deep as a path can go
+    depth := (4000 - len(in)) / 2
+    bottom := in + strings.Repeat("/d", depth)
+    if err := os.MkdirAll(bottom, 0755); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(bottom + "/a.tbl", []byte("x"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    defer debug.SetMaxStack(debug.SetMaxStack(256 << 10))
+    tree, err := walkDir(in, walkOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    node := tree
+    for i := 0; i < depth; i++ {
+        if len(node.children) != 1 || !node.children[0].isDir {
+            t.Fatalf("level %d of the walk holds %+v, want the next d", i, node.children)
+        }
+        node = node.children[0]
+    }
+    if len(node.children) != 1 || node.children[0].originalPath != bottom + "/a.tbl" {
+        t.Fatalf("the bottom of the walk holds %+v, want a.tbl", node.children)
+    }
+    toc, err := produceTOC(in, tree, tocOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(toc) != 2 * (depth + 1) + 1 {
+        t.Errorf("got %d entries, want %d", len(toc), 2 * (depth + 1) + 1)
+    }
+}
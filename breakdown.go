@@ -0,0 +1,67 @@
+package aztech
+
+import (
+    "encoding/json"
+    "fmt"
+    "path"
+    "sort"
+    "strings"
+
+    "github.com/tcrayford/aztech/vp"
+)
+
+// extTotal is how much of the file data written has one extension.
+type extTotal struct {
+    Ext string `json:"ext"`
+    Size int64 `json:"size"`
+    Files int `json:"files"`
+}
+
+// addBreakdown adds the files in toc to totals, by their extension, lower
+// cased, with "" for files that have none.
+func addBreakdown(totals map[string]*extTotal, toc []vp.TOCEntry) {
+    for _, entry := range toc {
+        if entry.IsDir {
+            continue
+        }
+        ext := strings.ToLower(path.Ext(entry.Name))
+        if totals[ext] == nil {
+            totals[ext] = &extTotal{Ext: ext}
+        }
+        totals[ext].Size += int64(entry.Size)
+        totals[ext].Files++
+    }
+}
+
+// printBreakdown prints totals largest first, a line of extension, bytes
+// and files each, or as a line of JSON.
+func printBreakdown(totals map[string]*extTotal, asJSON bool) error {
+    sorted := []extTotal{}
+    for _, t := range totals {
+        sorted = append(sorted, *t)
+    }
+    sort.Slice(sorted, func(i, j int) bool {
+        if sorted[i].Size != sorted[j].Size {
+            return sorted[i].Size > sorted[j].Size
+        }
+        return sorted[i].Ext < sorted[j].Ext
+    })
+    if asJSON {
+        out, err := json.Marshal(struct {
+            Extensions []extTotal `json:"extensions"`
+        }{sorted})
+        if err != nil {
+            return err
+        }
+        fmt.Println(string(out))
+        return nil
+    }
+    for _, t := range sorted {
+        ext := t.Ext
+        if ext == "" {
+            ext = "(none)"
+        }
+        fmt.Printf("%s\t%d\t%d\n", ext, t.Size, t.Files)
+    }
+    return nil
+}
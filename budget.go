@@ -0,0 +1,72 @@
+package aztech
+
+import (
+    "fmt"
+    "sort"
+
+    "github.com/tcrayford/aztech/vp"
+)
+
+// fitBudget picks the files in toc to keep so that the VP they make comes
+// to no more than budget bytes, header and index included. It goes through
+// the files by priority, keeping each that still fits: those matching an
+// earlier pattern in priority (see pathFilter) first, then the rest, with
+// ties in order, which is "largest" or "smallest" first, or "index" for as
+// they come in toc. Directories are all kept, even if that empties them,
+// as they are by dropBySize. It returns the TOC of what's kept, in toc's
+// order, and the files left out.
+func fitBudget(toc []vp.TOCEntry, budget int64, order string, priority []string) ([]vp.TOCEntry, []vp.TOCEntry, error) {
+    paths, err := vp.ArchivePaths(toc)
+    if err != nil {
+        return nil, nil, err
+    }
+    used := int64(vp.HeaderSize)
+    files := []int{}
+    for i, entry := range toc {
+        if entry.IsDir {
+            used += vp.IndexEntrySize
+        } else {
+            files = append(files, i)
+        }
+    }
+    if used > budget {
+        return nil, nil, fmt.Errorf("a budget of %d bytes has no room for any files, as the header and directories take %d", budget, used)
+    }
+    rank := func(i int) int {
+        for r, pattern := range priority {
+            if globMatch(pattern, paths[i]) {
+                return r
+            }
+        }
+        return len(priority)
+    }
+    sort.SliceStable(files, func(a, b int) bool {
+        ra, rb := rank(files[a]), rank(files[b])
+        if ra != rb {
+            return ra < rb
+        }
+        switch order {
+        case "largest":
+            return toc[files[a]].Size > toc[files[b]].Size
+        case "smallest":
+            return toc[files[a]].Size < toc[files[b]].Size
+        }
+        return false
+    })
+    keep := map[int]bool{}
+    for _, i := range files {
+        if cost := int64(toc[i].Size) + vp.IndexEntrySize; used + cost <= budget {
+            used += cost
+            keep[i] = true
+        }
+    }
+    kept, dropped := []vp.TOCEntry{}, []vp.TOCEntry{}
+    for i, entry := range toc {
+        if entry.IsDir || keep[i] {
+            kept = append(kept, entry)
+        } else {
+            dropped = append(dropped, entry)
+        }
+    }
+    return kept, dropped, nil
+}
@@ -0,0 +1,66 @@
+package aztech
+
+import (
+    "reflect"
+    "strings"
+    "testing"
+
+    "github.com/tcrayford/aztech/vp"
+)
+
+func TestFitBudget(t *testing.T) {
+    toc := flatTOC(100, 10, 50, 30)
+    // the header and the two directory entries
+    overhead := int64(vp.HeaderSize + 2 * vp.IndexEntrySize)
+    cost := func(sizes ...int64) int64 {
+        total := overhead
+        for _, size := range sizes {
+            total += size + vp.IndexEntrySize
+        }
+        return total
+    }
+    for _, c := range []struct {
+        budget int64
+        order string
+        priority []string
+        kept []string
+    }{
+        // largest first takes f0, then only f1 still fits
+        {cost(100, 10), "largest", nil, []string{"f0", "f1"}},
+        {cost(100, 10), "smallest", nil, []string{"f1", "f3"}},
+        {cost(100, 10), "index", nil, []string{"f0", "f1"}},
+        {cost(100, 10) - 1, "largest", nil, []string{"f0"}},
+        {cost(100, 10, 50, 30), "largest", nil, []string{"f0", "f1", "f2", "f3"}},
+        {overhead, "largest", nil, []string{}},
+        // what's prioritised goes in before the rest, whatever the order
+        {cost(100, 10), "smallest", []string{"data/f2"}, []string{"f1", "f2"}},
+        {cost(100, 10), "largest", []string{"data/f3", "data/f1"}, []string{"f1", "f3"}},
+        {cost(100, 10), "index", []string{"**/f[23]"}, []string{"f2", "f3"}},
+    } {
+        kept, dropped, err := fitBudget(toc, c.budget, c.order, c.priority)
+        if err != nil {
+            t.Errorf("%d, %v, %q: %v", c.budget, c.order, c.priority, err)
+            continue
+        }
+        // the directories are all kept, and everything's in toc's order
+        want, wantDropped := []vp.TOCEntry{}, []vp.TOCEntry{}
+        for _, entry := range toc {
+            keep := entry.IsDir
+            for _, name := range c.kept {
+                keep = keep || entry.Name == name
+            }
+            if keep {
+                want = append(want, entry)
+            } else {
+                wantDropped = append(wantDropped, entry)
+            }
+        }
+        if !reflect.DeepEqual(kept, want) || !reflect.DeepEqual(dropped, wantDropped) {
+            t.Errorf("%d, %v, %q: kept %v, dropped %v, want %v", c.budget, c.order, c.priority, kept, dropped, c.kept)
+        }
+    }
+
+    if _, _, err := fitBudget(toc, overhead - 1, "largest", nil); err == nil || !strings.Contains(err.Error(), "no room for any files") {
+        t.Errorf("a budget too small for the directories gave %v", err)
+    }
+}
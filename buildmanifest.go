@@ -0,0 +1,91 @@
+package aztech
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+
+    "github.com/tcrayford/aztech/vp"
+)
+
+// buildManifestVersion is the version of --manifest-out's schema. Fields
+// can be added without changing it; it goes up if any are renamed,
+// removed or change meaning, so tools reading the manifest can tell.
+const buildManifestVersion = 1
+
+// mappedEntry is a file in a VP, for --manifest-out: where in the VP it is,
+// and what it was packed from.
+type mappedEntry struct {
+    // Path is the file's path in the archive, like data/maps/foo.dds.
+    Path string `json:"path"`
+    // Source is the file it was read from, as the walk found it: a path on
+    // disk, or in the input archive or source manifest. It's empty for
+    // files aztech made itself, like an embedded manifest.
+    Source string `json:"source,omitempty"`
+    // Offset and Size are where its data is in the VP, as the index has it.
+    Offset int32 `json:"offset"`
+    Size int32 `json:"size"`
+    Timestamp int32 `json:"timestamp"`
+}
+
+// mapEntries lists the files in the VP just written at vpPath with what
+// each was packed from, going by subtoc, the TOC it was written from. The
+// offsets and sizes are read back from the VP's index, so are where each
+// file's data really landed, after any transform and whatever layout; a
+// file that was left out as it went missing isn't listed.
+func mapEntries(vpPath string, subtoc []vp.TOCEntry, src fileSource) ([]mappedEntry, error) {
+    index, err := ReadTOCFile(vpPath)
+    if err != nil {
+        return nil, err
+    }
+    paths, err := vp.ArchivePaths(subtoc)
+    if err != nil {
+        return nil, err
+    }
+    sources := map[string]string{}
+    generated, _ := src.(memSource)
+    for i, entry := range subtoc {
+        if entry.IsDir || generated.files[entry.Path] != nil {
+            continue
+        }
+        sources[paths[i]] = entry.Path
+    }
+    out := []mappedEntry{}
+    for _, entry := range index {
+        if entry.IsDir {
+            continue
+        }
+        out = append(out, mappedEntry{entry.Path, sources[entry.Path], entry.Offset, entry.Size, entry.Timestamp})
+    }
+    return out, nil
+}
+
+// writeBuildManifest writes the --manifest-out record of a run to p: the
+// schema's version, every VP written, sorted by path, with the input it
+// came from and the files in it in index order, and everything skipped
+// with why, as skipLog.entries orders them.
+func writeBuildManifest(p string, vps []writtenVP, skipped skipLog) error {
+    type vpJSON struct {
+        Path string `json:"path"`
+        Input string `json:"input"`
+        Size int64 `json:"size"`
+        Entries []mappedEntry `json:"entries"`
+    }
+    written := []vpJSON{}
+    for _, w := range sortedVPs(vps) {
+        written = append(written, vpJSON{w.path, w.input, w.size, w.mapped})
+    }
+    out, err := json.MarshalIndent(struct {
+        Version int `json:"version"`
+        Aztech string `json:"aztech"`
+        VPs []vpJSON `json:"vps"`
+        Skipped []skippedEntry `json:"skipped"`
+    }{buildManifestVersion, Version(), written, skipped.entries()}, "", "  ")
+    if err != nil {
+        return err
+    }
+    if err := ioutil.WriteFile(p, append(out, '\n'), 0644); err != nil {
+        return fmt.Errorf("writing the build manifest: %w", err)
+    }
+    return nil
+}
@@ -0,0 +1,59 @@
+package aztech
+
+import (
+    "fmt"
+
+    "github.com/tcrayford/aztech/vp"
+)
+
+// UnsortedEntries lists each entry in entries, a VP's index as vp.ReadTOC
+// gives it, that comes before another in its directory by name, going by
+// the bytes of the names as produceTOC sorts them. With group other than
+// "mixed", directories and files are each sorted on their own, and one
+// in the wrong group is reported too.
+func UnsortedEntries(entries []vp.TOCEntry, group string) []string {
+    // the last entry, directory and file seen in each directory open at
+    // this point in the index, the top of the archive first
+    type openDir struct {
+        path string
+        last *vp.TOCEntry
+        lastDir *vp.TOCEntry
+        lastFile *vp.TOCEntry
+    }
+    open := []openDir{{path: "the top of the archive"}}
+    problems := []string{}
+    for i := range entries {
+        entry := &entries[i]
+        if entry.IsDir && entry.Name == ".." {
+            if len(open) > 1 {
+                open = open[:len(open) - 1]
+            }
+            continue
+        }
+        dir := &open[len(open) - 1]
+        prev := dir.last
+        if group != "mixed" {
+            prev = dir.lastFile
+            if entry.IsDir {
+                prev = dir.lastDir
+            }
+        }
+        if prev != nil && prev.Name > entry.Name {
+            problems = append(problems, fmt.Sprintf("in %v, %q comes after %q", dir.path, entry.Name, prev.Name))
+        }
+        if group == "dirs-first" && entry.IsDir && dir.lastFile != nil {
+            problems = append(problems, fmt.Sprintf("in %v, the directory %q comes after the file %q", dir.path, entry.Name, dir.lastFile.Name))
+        }
+        if group == "files-first" && !entry.IsDir && dir.lastDir != nil {
+            problems = append(problems, fmt.Sprintf("in %v, the file %q comes after the directory %q", dir.path, entry.Name, dir.lastDir.Name))
+        }
+        dir.last = entry
+        if entry.IsDir {
+            dir.lastDir = entry
+            open = append(open, openDir{path: entry.Path})
+        } else {
+            dir.lastFile = entry
+        }
+    }
+    return problems
+}
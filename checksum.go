@@ -0,0 +1,164 @@
+package aztech
+
+import (
+    "bytes"
+    "crypto/sha1"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "hash"
+    "io"
+    "io/ioutil"
+    "os"
+    "path"
+    "strings"
+)
+
+// checksumAlgo is a digest a checksum sidecar can hold. The sidecar for
+// foo.vp is foo.vp followed by ext, and holds a line in the format
+// sha256sum and friends print and check with -c.
+type checksumAlgo struct {
+    name string
+    ext string
+    new func() hash.Hash
+}
+
+var checksumAlgos = []checksumAlgo{
+    {"sha256", ".sha256", sha256.New},
+    {"sha1", ".sha1", sha1.New},
+    {"blake2b", ".b2", newBlake2b},
+}
+
+// checksumAlgoNamed finds the algorithm called name, or nil.
+func checksumAlgoNamed(name string) *checksumAlgo {
+    for i := range checksumAlgos {
+        if checksumAlgos[i].name == name {
+            return &checksumAlgos[i]
+        }
+    }
+    return nil
+}
+
+// setChecksumExt comes before the algorithm's extension in the name of the
+// checksum file for a whole split set, like maps.vpset.sha256 for
+// maps-01.vp, maps-02.vp and so on, which has a sidecar's line for each
+// part, in order.
+const setChecksumExt = ".vpset"
+
+// checksumLine is the line in a sidecar for the VP at vpPath.
+func checksumLine(vpPath string, sum []byte) string {
+    return fmt.Sprintf("%x  %s\n", sum, path.Base(vpPath))
+}
+
+// writeChecksum writes the sidecar for the VP at vpPath, given its digest.
+func writeChecksum(vpPath string, algo *checksumAlgo, sum []byte) error {
+    return ioutil.WriteFile(vpPath + algo.ext, []byte(checksumLine(vpPath, sum)), 0644)
+}
+
+// setChecksumAlgo returns the algorithm of the set checksum file at p, or
+// nil if it isn't named like one.
+func setChecksumAlgo(p string) *checksumAlgo {
+    for i := range checksumAlgos {
+        if strings.HasSuffix(p, setChecksumExt + checksumAlgos[i].ext) {
+            return &checksumAlgos[i]
+        }
+    }
+    return nil
+}
+
+// fileChecksum works out the digest of the file at p.
+func fileChecksum(p string, algo *checksumAlgo) ([]byte, error) {
+    f, err := os.Open(p)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    h := algo.new()
+    if _, err := io.Copy(h, f); err != nil {
+        return nil, err
+    }
+    return h.Sum(nil), nil
+}
+
+// verify checks p, which is either a VP, as verifyVP does, or a set
+// checksum file, as verifySet does, going by its extension.
+func Verify(p string) ([]string, error) {
+    if setChecksumAlgo(p) != nil {
+        return verifySet(p)
+    }
+    return verifyVP(p)
+}
+
+// verifyVP checks the VP at vpPath against every checksum sidecar it has,
+// and returns what doesn't match. Having no sidecar at all is an error.
+func verifyVP(vpPath string) ([]string, error) {
+    problems := []string{}
+    found := 0
+    for i := range checksumAlgos {
+        algo := &checksumAlgos[i]
+        content, err := ioutil.ReadFile(vpPath + algo.ext)
+        if os.IsNotExist(err) {
+            continue
+        }
+        if err != nil {
+            return nil, err
+        }
+        found++
+        fields := strings.Fields(string(content))
+        if len(fields) == 0 {
+            problems = append(problems, fmt.Sprintf("%v%v is empty", vpPath, algo.ext))
+            continue
+        }
+        want, err := hex.DecodeString(fields[0])
+        if err != nil || len(want) != algo.new().Size() {
+            problems = append(problems, fmt.Sprintf("%v%v doesn't hold a %v digest", vpPath, algo.ext, algo.name))
+            continue
+        }
+        got, err := fileChecksum(vpPath, algo)
+        if err != nil {
+            return nil, err
+        }
+        if !bytes.Equal(got, want) {
+            problems = append(problems, fmt.Sprintf("%v has %v %x, but %v%v says %x", vpPath, algo.name, got, vpPath, algo.ext, want))
+        }
+    }
+    if found == 0 {
+        return nil, fmt.Errorf("%v has no checksum sidecar", vpPath)
+    }
+    return problems, nil
+}
+
+// verifySet checks each part listed in the set checksum file at setPath,
+// which are next to it, and returns what doesn't match or is missing.
+func verifySet(setPath string) ([]string, error) {
+    algo := setChecksumAlgo(setPath)
+    content, err := ioutil.ReadFile(setPath)
+    if err != nil {
+        return nil, err
+    }
+    problems := []string{}
+    for n, line := range strings.Split(string(content), "\n") {
+        if strings.TrimSpace(line) == "" {
+            continue
+        }
+        fields := strings.Fields(line)
+        want, err := hex.DecodeString(fields[0])
+        if len(fields) != 2 || err != nil || len(want) != algo.new().Size() {
+            problems = append(problems, fmt.Sprintf("line %d of %v isn't a %v digest and file name", n + 1, setPath, algo.name))
+            continue
+        }
+        vpPath := path.Join(path.Dir(setPath), fields[1])
+        got, err := fileChecksum(vpPath, algo)
+        if os.IsNotExist(err) {
+            problems = append(problems, fmt.Sprintf("%v is in %v, but missing", vpPath, setPath))
+            continue
+        }
+        if err != nil {
+            return nil, err
+        }
+        if !bytes.Equal(got, want) {
+            problems = append(problems, fmt.Sprintf("%v has %v %x, but %v says %x", vpPath, algo.name, got, setPath, want))
+        }
+    }
+    return problems, nil
+}
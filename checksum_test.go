@@ -0,0 +1,161 @@
+package aztech
+
+import (
+    "context"
+    "crypto/sha1"
+    "crypto/sha256"
+    "fmt"
+    "hash"
+    "os"
+    "path"
+    "strings"
+    "testing"
+)
+
+func TestBlake2b(t *testing.T) {
+    // as b2sum gives them
+    for in, want := range map[string]string{
+        "": "786a02f742015903c6c6fd852552d272912f4740e15847618a86e217f71f5419d25e1031afee585313896444934eb04b903a685b1448b755d56f701afe9be2ce",
+        "abc": "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923",
+    } {
+        h := newBlake2b()
+        h.Write([]byte(in))
+        if got := fmt.Sprintf("%x", h.Sum(nil)); got != want {
+            t.Errorf("blake2b of %q is %v, want %v", in, got, want)
+        }
+    }
+}
+
+func TestChecksumSidecars(t *testing.T) {
+    in := path.Join(t.TempDir(), "in")
+    writeFiles(t, in, map[string]string{"data/maps/a.pof": "aaa", "data/maps/sub/b.pof": strings.Repeat("b", 100000)})
+    for _, c := range []struct {
+        algo string
+        ext string
+        new func() hash.Hash
+    }{
+        {"sha256", ".sha256", sha256.New},
+        {"sha1", ".sha1", sha1.New},
+        {"blake2b", ".b2", newBlake2b},
+    } {
+        // the digest's worked out as the VP's written, or read back from
+        // it when it's changed afterwards
+        for _, opts := range []Options{{}, {TwoPass: true}, {EmbedHash: true}} {
+            out := t.TempDir()
+            opts.OutputDir = out
+            opts.Checksum = c.algo
+            if err := Pack(context.Background(), []string{in}, opts); err != nil {
+                t.Fatal(err)
+            }
+            vpPath := path.Join(out, "maps.vp")
+            b, err := os.ReadFile(vpPath)
+            if err != nil {
+                t.Fatal(err)
+            }
+            h := c.new()
+            h.Write(b)
+            want := fmt.Sprintf("%x  maps.vp\n", h.Sum(nil))
+            if got, err := os.ReadFile(vpPath + c.ext); err != nil || string(got) != want {
+                t.Errorf("%v, %+v: sidecar holds %q (%v), want %q", c.algo, opts, got, err, want)
+            }
+            if problems, err := Verify(vpPath); err != nil || len(problems) > 0 {
+                t.Errorf("%v, %+v: verifying gave %q, %v", c.algo, opts, problems, err)
+            }
+
+            // verify finds the sidecar by its extension, whichever it is
+            b[len(b) - 1] ^= 1
+            if err := os.WriteFile(vpPath, b, 0644); err != nil {
+                t.Fatal(err)
+            }
+            problems, err := Verify(vpPath)
+            if err != nil || len(problems) != 1 || !strings.Contains(problems[0], c.algo) {
+                t.Errorf("%v, %+v: verifying a changed VP gave %q, %v", c.algo, opts, problems, err)
+            }
+        }
+    }
+}
+
+func TestVerifyEverySidecar(t *testing.T) {
+    dir := t.TempDir()
+    vpPath := path.Join(dir, "maps.vp")
+    if err := os.WriteFile(vpPath, []byte("not really a VP"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := Verify(vpPath); err == nil || !strings.Contains(err.Error(), "no checksum sidecar") {
+        t.Errorf("verifying without a sidecar gave %v", err)
+    }
+
+    // a right sha256, a wrong blake2b and a sha1 that isn't a digest
+    sum, err := fileChecksum(vpPath, checksumAlgoNamed("sha256"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := writeChecksum(vpPath, checksumAlgoNamed("sha256"), sum); err != nil {
+        t.Fatal(err)
+    }
+    if err := writeChecksum(vpPath, checksumAlgoNamed("blake2b"), make([]byte, 64)); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(vpPath + ".sha1", []byte("abc  maps.vp\n"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    problems, err := Verify(vpPath)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(problems) != 2 || !strings.Contains(problems[0], ".sha1 doesn't hold a sha1 digest") || !strings.Contains(problems[1], "has blake2b") {
+        t.Errorf("verifying gave %q, want the sha1 and blake2b sidecars' problems", problems)
+    }
+}
+
+func TestSetChecksum(t *testing.T) {
+    in := path.Join(t.TempDir(), "in")
+    writeFiles(t, in, map[string]string{"data/maps/a.pof": "aaaa", "data/maps/b.pof": "bbbb", "data/maps/c.pof": "cccc", "data/tables/d.tbl": "d"})
+    out := t.TempDir()
+    if err := Pack(context.Background(), []string{in}, Options{OutputDir: out, TargetSize: 4, Checksum: "blake2b"}); err != nil {
+        t.Fatal(err)
+    }
+    // a line for each part, as in the part's own sidecar, in order, and
+    // no set file for a directory in one VP
+    setPath := path.Join(out, "maps.vpset.b2")
+    want := ""
+    for _, part := range []string{"maps-01.vp", "maps-02.vp", "maps-03.vp"} {
+        sidecar, err := os.ReadFile(path.Join(out, part + ".b2"))
+        if err != nil {
+            t.Fatal(err)
+        }
+        want += string(sidecar)
+    }
+    if got, err := os.ReadFile(setPath); err != nil || string(got) != want {
+        t.Errorf("set checksum file holds %q (%v), want %q", got, err, want)
+    }
+    if _, err := os.Stat(path.Join(out, "tables.vpset.b2")); !os.IsNotExist(err) {
+        t.Errorf("tables, in one VP, got a set checksum file (%v)", err)
+    }
+    if problems, err := Verify(setPath); err != nil || len(problems) > 0 {
+        t.Errorf("verifying the set gave %q, %v", problems, err)
+    }
+
+    if err := os.WriteFile(path.Join(out, "maps-01.vp"), []byte("changed"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.Remove(path.Join(out, "maps-03.vp")); err != nil {
+        t.Fatal(err)
+    }
+    problems, err := Verify(setPath)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(problems) != 2 || !strings.Contains(problems[0], "maps-01.vp has blake2b") || !strings.Contains(problems[1], "maps-03.vp is in") {
+        t.Errorf("verifying a changed set gave %q", problems)
+    }
+
+    // and cleaning removes it with the VPs, leaving only the sidecar of
+    // the part that's gone, which isn't a VP's any more
+    if err := cleanOutput(out); err != nil {
+        t.Fatal(err)
+    }
+    if left := packedFiles(t, out); len(left) != 1 || left["maps-03.vp.b2"] == nil {
+        t.Errorf("cleaning left %d files", len(left))
+    }
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path"
+
+    "github.com/tcrayford/aztech"
+)
+
+// assertMain implements "aztech assert", which checks a VP has everything
+// an expected manifest lists, for gating releases.
+func assertMain(args []string) {
+    flags := flag.NewFlagSet("assert", flag.ExitOnError)
+    flags.Usage = func() {
+        fmt.Fprintf(os.Stderr, "usage: %s assert <vp> <expected.json>\n", path.Base(os.Args[0]))
+        fmt.Fprintf(os.Stderr, "expected.json is a list of {\"path\": ..., \"size\": ..., \"sha256\": ...}, with size and sha256 optional\n")
+        flags.PrintDefaults()
+    }
+    flags.Parse(args)
+    if flags.NArg() != 2 {
+        flags.Usage()
+        os.Exit(2)
+    }
+    vpPath := flags.Arg(0)
+
+    content, err := ioutil.ReadFile(flags.Arg(1))
+    if err != nil {
+        fatalf(flags.Arg(1), "%v", err)
+    }
+    var expected []aztech.ExpectedFile
+    if err := json.Unmarshal(content, &expected); err != nil {
+        fatalf(flags.Arg(1), "parsing %v: %v", flags.Arg(1), err)
+    }
+    problems, err := aztech.AssertVP(vpPath, expected)
+    if err != nil {
+        fatalf(vpPath, "%v", err)
+    }
+    for _, p := range problems {
+        aztech.LogEntry("error", vpPath, -1, p)
+    }
+    if len(problems) > 0 {
+        fatalf(vpPath, "%v doesn't match %v: %d problems", vpPath, flags.Arg(1), len(problems))
+    }
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+    "path"
+
+    "github.com/tcrayford/aztech"
+)
+
+// checkMain implements "aztech check", which checks VPs for things the
+// format allows but the engine, or parts of it, may not cope with.
+func checkMain(args []string) {
+    flags := flag.NewFlagSet("check", flag.ExitOnError)
+    sorted := flags.Bool("sorted", false, "check the entries in each directory are in ascending order by name, as pack writes them")
+    group := flags.String("group", "mixed", "for --sorted, how directories and files were grouped, as with pack's --group: dirs-first, files-first, or mixed for no grouping; each group is checked on its own")
+    flags.Usage = func() {
+        fmt.Fprintf(os.Stderr, "usage: %s check [flags] <vp>...\n", path.Base(os.Args[0]))
+        flags.PrintDefaults()
+    }
+    flags.Parse(args)
+    if flags.NArg() == 0 {
+        flags.Usage()
+        os.Exit(2)
+    }
+    if !*sorted {
+        fatalf("", "nothing to check, pass --sorted")
+    }
+    if !aztech.ValidGroup(*group) {
+        fatalf("", "unknown --group %q, want dirs-first, files-first or mixed", *group)
+    }
+    failed := 0
+    for _, vpPath := range flags.Args() {
+        entries, err := aztech.ReadTOCFile(vpPath)
+        if err != nil {
+            fatalf(vpPath, "%v", err)
+        }
+        problems := aztech.UnsortedEntries(entries, *group)
+        for _, p := range problems {
+            aztech.LogEntry("error", vpPath, -1, fmt.Sprintf("%v: %v", vpPath, p))
+        }
+        if len(problems) > 0 {
+            failed++
+        } else {
+            aztech.LogEntry("info", vpPath, -1, fmt.Sprintf("%v: OK", vpPath))
+        }
+    }
+    if failed > 0 {
+        fatalf("", "%d of %d VPs failed the check", failed, flags.NArg())
+    }
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+    "encoding/json"
+    "flag"
+    "fmt"
+    "os"
+    "path"
+
+    "github.com/tcrayford/aztech"
+)
+
+// compareMain implements "aztech compare", which checks a VP against the
+// input it was packed from and reports every file that's missing from
+// either side or whose contents differ.
+func compareMain(args []string) {
+    flags := flag.NewFlagSet("compare", flag.ExitOnError)
+    asJSON := flags.Bool("json", false, "print the differences as a JSON list of {\"path\", \"kind\", \"detail\"} on stdout")
+    flags.Usage = func() {
+        fmt.Fprintf(os.Stderr, "usage: %s compare [flags] <vp> <input directory>\n", path.Base(os.Args[0]))
+        flags.PrintDefaults()
+    }
+    flags.Parse(args)
+    if flags.NArg() != 2 {
+        flags.Usage()
+        os.Exit(2)
+    }
+    vpPath := flags.Arg(0)
+    srcDir := flags.Arg(1)
+
+    diffs, err := aztech.CompareVP(vpPath, srcDir)
+    if err != nil {
+        fatalf(vpPath, "%v", err)
+    }
+    if *asJSON {
+        out, err := json.MarshalIndent(diffs, "", "  ")
+        if err != nil {
+            fatalf("", "%v", err)
+        }
+        fmt.Println(string(out))
+    } else {
+        for _, d := range diffs {
+            fmt.Println(d)
+        }
+    }
+    if len(diffs) > 0 {
+        if !*asJSON {
+            aztech.LogEntry("error", vpPath, -1, fmt.Sprintf("%v doesn't match %v: %d differences", vpPath, srcDir, len(diffs)))
+        }
+        os.Exit(1)
+    }
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+    "encoding/json"
+    "flag"
+    "fmt"
+    "os"
+    "path"
+    "sort"
+
+    "github.com/tcrayford/aztech"
+    "github.com/tcrayford/aztech/vp"
+)
+
+// duMain implements "aztech du", which adds up the sizes of the files in
+// every VP in some directories, as the index gives them rather than what
+// the VPs take up on disk, to see where the space in a mod goes.
+func duMain(args []string) {
+    flags := flag.NewFlagSet("du", flag.ExitOnError)
+    byDir := flags.Bool("by-dir", false, "also add up each top level directory inside the VPs, like data/maps, across every VP it's in")
+    asJSON := flags.Bool("json", false, "print the figures as a line of JSON")
+    flags.Usage = func() {
+        fmt.Fprintf(os.Stderr, "usage: %s du [flags] <dir>...\n", path.Base(os.Args[0]))
+        flags.PrintDefaults()
+    }
+    flags.Parse(args)
+    if flags.NArg() == 0 {
+        flags.Usage()
+        os.Exit(2)
+    }
+
+    vps := []aztech.DuTotal{}
+    dirs := map[string]*aztech.DuTotal{}
+    total := aztech.DuTotal{Name: "total"}
+    for _, dir := range flags.Args() {
+        vpPaths, err := aztech.VPsIn(dir)
+        if err != nil {
+            fatalf(dir, "%v", err)
+        }
+        for _, vpPath := range vpPaths {
+            entries, err := aztech.ReadTOCFile(vpPath)
+            if err != nil {
+                fatalf(vpPath, "%v", err)
+            }
+            paths, err := vp.ArchivePaths(entries)
+            if err != nil {
+                fatalf(vpPath, "%v", err)
+            }
+            t := aztech.DuTotal{Name: vpPath}
+            for i, entry := range entries {
+                if entry.IsDir {
+                    continue
+                }
+                t.Size += int64(entry.Size)
+                t.Files++
+                group := aztech.DuGroup(paths[i])
+                if dirs[group] == nil {
+                    dirs[group] = &aztech.DuTotal{Name: group}
+                }
+                dirs[group].Size += int64(entry.Size)
+                dirs[group].Files++
+            }
+            vps = append(vps, t)
+            total.Size += t.Size
+            total.Files += t.Files
+        }
+    }
+    groups := []aztech.DuTotal{}
+    if *byDir {
+        for _, d := range dirs {
+            groups = append(groups, *d)
+        }
+        sort.Slice(groups, func(i, j int) bool {
+            return groups[i].Name < groups[j].Name
+        })
+    }
+
+    if *asJSON {
+        out, err := json.Marshal(struct {
+            VPs []aztech.DuTotal `json:"vps"`
+            Dirs []aztech.DuTotal `json:"dirs,omitempty"`
+            Total aztech.DuTotal `json:"total"`
+        }{vps, groups, total})
+        if err != nil {
+            fatalf("", "%v", err)
+        }
+        fmt.Println(string(out))
+        return
+    }
+    for _, t := range append(append(vps, groups...), total) {
+        fmt.Printf("%s\t%d\t%d\n", t.Name, t.Size, t.Files)
+    }
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "os"
+    "os/signal"
+    "path"
+    "strconv"
+    "syscall"
+
+    "github.com/tcrayford/aztech"
+)
+
+// extractMain implements "aztech extract", which unpacks a VP into a
+// directory.
+func extractMain(args []string) {
+    flags := flag.NewFlagSet("extract", flag.ExitOnError)
+    progress := flags.Bool("progress", false, "report progress on stderr")
+    structureOnly := flags.Bool("structure-only", false, "recreate the directories and leave an empty placeholder for each file, without extracting any data")
+    // VPs don't store permissions, so everything gets these, less the umask
+    fileMode, dirMode := modeFlag(0644), modeFlag(0755)
+    flags.Var(&fileMode, "file-mode", "permissions, in octal, to create files with, less the umask")
+    flags.Var(&dirMode, "dir-mode", "permissions, in octal, to create directories with, less the umask")
+    flags.Usage = func() {
+        fmt.Fprintf(os.Stderr, "usage: %s extract [flags] <vp> <output dir>\n", path.Base(os.Args[0]))
+        flags.PrintDefaults()
+    }
+    flags.Parse(args)
+    if flags.NArg() != 2 {
+        flags.Usage()
+        os.Exit(2)
+    }
+    vpPath := flags.Arg(0)
+
+    var hook func(written, total int64)
+    if *progress {
+        hook = aztech.ProgressPrinter(vpPath)
+    }
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+    opts := aztech.ExtractOptions{
+        StructureOnly: *structureOnly,
+        Progress: hook,
+        FileMode: os.FileMode(fileMode),
+        DirMode: os.FileMode(dirMode),
+    }
+    if err := aztech.ExtractVP(ctx, vpPath, flags.Arg(1), opts); err != nil {
+        if ctx.Err() != nil {
+            fatalf(vpPath, "interrupted")
+        }
+        fatalf(vpPath, "%v", err)
+    }
+}
+
+// modeFlag is a flag for permissions, given in octal, like 0644.
+type modeFlag os.FileMode
+
+func (m *modeFlag) String() string {
+    return fmt.Sprintf("%#o", uint32(*m))
+}
+
+func (m *modeFlag) Set(v string) error {
+    n, err := strconv.ParseUint(v, 8, 32)
+    if err != nil || n > 0777 {
+        return fmt.Errorf("%q isn't permissions in octal, like 0644", v)
+    }
+    *m = modeFlag(n)
+    return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path"
+
+    "github.com/tcrayford/aztech"
+)
+
+// indexMain implements "aztech index", which copies just the header and
+// index out of a VP, for tools that build their own indexes of archives
+// without wanting the data.
+func indexMain(args []string) {
+    flags := flag.NewFlagSet("index", flag.ExitOnError)
+    output := flags.String("o", "", "write the index here instead of to stdout")
+    flags.Usage = func() {
+        fmt.Fprintf(os.Stderr, "usage: %s index [flags] <vp>\n", path.Base(os.Args[0]))
+        flags.PrintDefaults()
+    }
+    flags.Parse(args)
+    if flags.NArg() != 1 {
+        flags.Usage()
+        os.Exit(2)
+    }
+    vpPath := flags.Arg(0)
+
+    index, err := aztech.IndexFile(vpPath)
+    if err != nil {
+        fatalf(vpPath, "%v", err)
+    }
+    if *output != "" {
+        err = ioutil.WriteFile(*output, index, 0644)
+    } else {
+        _, err = os.Stdout.Write(index)
+    }
+    if err != nil {
+        fatalf(*output, "%v", err)
+    }
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+    "path"
+
+    "github.com/tcrayford/aztech"
+    "github.com/tcrayford/aztech/vp"
+)
+
+// listMain implements "aztech list", which prints what's in a VP: each
+// directory (with a trailing slash) and file, by its path in the archive,
+// with files' sizes. A hash trailer, if there is one, is checked first.
+func listMain(args []string) {
+    flags := flag.NewFlagSet("list", flag.ExitOnError)
+    onlyFiles := flags.Bool("list-only-files", false, "list only files, leaving out directories")
+    onlyDirs := flags.Bool("list-only-dirs", false, "list only directories, for the archive's skeleton")
+    asJSON := flags.Bool("json", false, "print the index, or the entries picked out by --list-only-files or --list-only-dirs, with offsets, as a line of JSON, in the same form as pack's --toc-json")
+    flags.Usage = func() {
+        fmt.Fprintf(os.Stderr, "usage: %s list [flags] <vp>\n", path.Base(os.Args[0]))
+        flags.PrintDefaults()
+    }
+    flags.Parse(args)
+    if flags.NArg() != 1 {
+        flags.Usage()
+        os.Exit(2)
+    }
+    if *onlyFiles && *onlyDirs {
+        fatalf("", "--list-only-files and --list-only-dirs can't be used together")
+    }
+    vpPath := flags.Arg(0)
+
+    f, err := aztech.OpenVP(vpPath)
+    if err != nil {
+        fatalf(vpPath, "%v", err)
+    }
+    defer f.Close()
+    entries, err := vp.ReadTOC(f, f.Size)
+    if err != nil {
+        fatalf(vpPath, "%v", err)
+    }
+    hash, ok, err := aztech.ReadHashTrailer(f, f.Size)
+    if err != nil {
+        fatalf(vpPath, "%v", err)
+    }
+    if hash != nil && !ok {
+        fatalf(vpPath, "%v doesn't match its embedded sha256 %x", vpPath, hash)
+    }
+    if hash != nil {
+        aztech.LogEntry("info", vpPath, -1, fmt.Sprintf("embedded sha256 %x matches", hash))
+    }
+    if *onlyFiles || *onlyDirs {
+        kept := []vp.TOCEntry{}
+        for _, entry := range entries {
+            if entry.IsDir && (*onlyFiles || entry.Name == "..") || !entry.IsDir && *onlyDirs {
+                continue
+            }
+            kept = append(kept, entry)
+        }
+        entries = kept
+    }
+    if *asJSON {
+        if err := aztech.PrintEntriesJSON(vpPath, entries); err != nil {
+            fatalf(vpPath, "%v", err)
+        }
+        return
+    }
+    for _, entry := range entries {
+        switch {
+        case entry.IsDir && entry.Name == "..":
+        case entry.IsDir:
+            fmt.Printf("%s/\n", entry.Path)
+        default:
+            fmt.Printf("%s\t%d\n", entry.Path, entry.Size)
+        }
+    }
+}
@@ -0,0 +1,377 @@
+// Command aztech packs directories into VP archives and works with ones
+// already packed. Run it without arguments for the subcommands, and with
+// -h after one for its flags.
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "os/signal"
+    "path"
+    "strconv"
+    "strings"
+    "syscall"
+    "time"
+
+    "github.com/tcrayford/aztech"
+    "github.com/tcrayford/aztech/vp"
+)
+
+func main() {
+    args := os.Args[1:]
+    // reprocheck takes the same flags as pack, to pack with twice
+    reprocheck := false
+    if len(args) > 0 {
+        switch args[0] {
+        case "pack":
+            args = args[1:]
+        case "reprocheck":
+            reprocheck = true
+            args = args[1:]
+        case "rebuild":
+            rebuildMain(os.Args[2:])
+            return
+        case "extract":
+            extractMain(os.Args[2:])
+            return
+        case "assert":
+            assertMain(os.Args[2:])
+            return
+        case "list":
+            listMain(os.Args[2:])
+            return
+        case "compare":
+            compareMain(os.Args[2:])
+            return
+        case "update":
+            updateMain(os.Args[2:])
+            return
+        case "manifest":
+            manifestMain(os.Args[2:])
+            return
+        case "verify":
+            verifyMain(os.Args[2:])
+            return
+        case "index":
+            indexMain(os.Args[2:])
+            return
+        case "du":
+            duMain(os.Args[2:])
+            return
+        case "check":
+            checkMain(os.Args[2:])
+            return
+        }
+    }
+
+    flag.BoolVar(&aztech.Verbose, "verbose", false, "also write debug diagnostics")
+    flag.StringVar(&aztech.LogFormat, "log-format", "text", "how to write diagnostics: text, or kv for one key=value record per line")
+    group := flag.String("group", "mixed", "order of entries within a directory: dirs-first, files-first or mixed")
+    input := flag.String("input", "", "input directory (or .tar, .tar.gz or .zip archive), instead of passing it as an argument")
+    output := flag.String("o", "tmp", "directory to write VPs to")
+    onExists := flag.String("on-exists", "fail", "what to do about a VP that's already in the output directory: fail (see --keep-going), overwrite it (once the new one is written in full), rename the new one to the first free name.1.vp, name.2.vp and so on, keeping the old, or skip it, keeping the old and not writing the new")
+    layout := flag.String("layout", "index", "order of the file data in each VP: index, the same order as the index, or small-first to put the smallest files first and the largest last (the index is in the usual order either way)")
+    onMissing := flag.String("on-missing", "fail", "what to do about files that go between the walk and being packed: fail, or skip them with a warning (use with --two-pass-size to cover files going mid-pack too)")
+    filesFrom := flag.String("files-from", "", "pack the files listed in this file (- for stdin), one source path per line, optionally followed by a tab and the path to store it at")
+    keepGoing := flag.Bool("keep-going", false, "skip files in a --files-from or --manifest list that don't exist, with a warning, instead of failing; and with --on-exists fail, skip VPs already in the output directory, packing the rest before failing with the list")
+    manifestIn := flag.String("manifest", "", "pack the files listed in this source manifest (tab separated source and archive paths, as the manifest command writes) instead of an input directory")
+    clean := flag.Bool("clean", false, "remove the VPs (and files named after them, like name.vp.sha256) already in the output directory before packing; nothing else there is touched")
+    maxVPSize := flag.Int("max-vp-size", 1000000000, "split VPs so none holds more than this many bytes of file data")
+    targetSize := flag.Int64("target-size", 0, "fill each VP up to about this many bytes, header and index included, before starting the next, for evenly sized parts (0 to split only at the limits)")
+    maxEntries := flag.Int("max-entries", 0, "split VPs so none has more than this many index entries, counting directory markers (0 for no limit)")
+    noSplit := flag.Bool("no-split-allowed", false, "fail, saying by how much, if a directory would need splitting into more than one VP to fit the limits above, for engines that can't load split VPs")
+    flag.BoolVar(&aztech.StrictMode, "strict", false, "fail on anything questionable rather than warning: non-ASCII names, names the engine can't tell apart, empty files, special files, and an output directory inside the input")
+    embed := flag.Bool("embed-manifest", false, "add a text file to each VP listing what's in it and when and how it was built (not counted when splitting)")
+    embedPath := flag.String("embed-manifest-path", "data/aztech-manifest.txt", "where --embed-manifest puts the manifest inside each VP")
+    flag.IntVar(&aztech.MaxNameBytes, "max-name-bytes", vp.MaxNameLength, "fail on names longer than this, for consumers with a shorter limit than the VP format's 31 bytes")
+    embedHash := flag.Bool("embed-hash", false, "append a SHA-256 of each VP after its index, which list checks; the engine ignores it, but tools that expect the index to end the file may not")
+    writeRetries := flag.Int("write-retries", 0, "how many times to write a file into a VP again from its start after a transient error (like EIO on a network mount) before failing; only the file in progress is retried, not the whole VP")
+    writeIndex := flag.Bool("write-index", false, "write a copy of each VP's header and index next to it, like maps.vp.idx, as the index command gives; its offsets are still positions in the VP")
+    checksum := flag.Bool("checksum", false, "write a checksum sidecar next to each VP, like maps.vp.sha256, in the format sha256sum -c and the verify command check, and for a directory split into several, a maps.vpset.sha256 listing every part")
+    compress := flag.String("compress", "", "compress each VP for transport as it's written, giving name.vp.gz or name.vp.zst: gzip or zstd; the engine can't load a compressed VP, so it has to be decompressed before it's installed, but this tool's commands read one as they would the VP")
+    compressLevel := flag.Int("compress-level", 0, "for --compress, the level to compress at, 1 (fastest) to 9 (smallest) for gzip, or to 19 for zstd, 0 for the default")
+    checksumAlgo := flag.String("checksum-algo", "sha256", "algorithm for --checksum: sha256, sha1 or blake2b (BLAKE2b-512, as b2sum prints); the sidecar is named .sha256, .sha1 or .b2 to match")
+    tocJSON := flag.Bool("toc-json", false, "print each VP's index as a line of JSON on stdout instead of writing them, with the offset each entry will get, in the same form as list --json")
+    dryRun := flag.Bool("dry-run", false, "go through everything up to writing the VPs, checks included, and log what would be written instead")
+    validateEngine := flag.Bool("validate-engine", false, "check every VP planned against the engine's limits, below, reporting everything over them, and fail if anything is (best with --dry-run)")
+    engineMaxVPs := flag.Int("engine-max-vps", aztech.DefaultEngineLimits.MaxVPs, "for --validate-engine, how many VPs the engine loads at once (0 for no limit)")
+    engineMaxEntries := flag.Int("engine-max-entries", aztech.DefaultEngineLimits.MaxEntries, "for --validate-engine, how many index entries a VP can have (0 for no limit)")
+    engineMaxName := flag.Int("engine-max-name", aztech.DefaultEngineLimits.MaxNameLength, "for --validate-engine, the longest name the engine takes, in bytes (0 for no limit)")
+    engineMaxPath := flag.Int("engine-max-path", aztech.DefaultEngineLimits.MaxPathLength, "for --validate-engine, the longest path inside a VP the engine takes, in bytes, like data/maps/foo.dds (0 for no limit)")
+    engineMaxDepth := flag.Int("engine-max-depth", aztech.DefaultEngineLimits.MaxDepth, "for --validate-engine, how many directories deep, data included, a file can be (0 for no limit)")
+    estimate := flag.Bool("estimate", false, "print the size each VP would be, header and index included, as tab separated lines on stdout, instead of writing them")
+    explainSplit := flag.Bool("explain-split", false, "print which VP each source file goes into, as tab separated lines on stdout, and warn about any file taking up more than half of the VP size limit")
+    namePad := flag.String("name-pad", "0x00", "byte to fill name fields with after the NUL ending each name, for older packers")
+    storeFullPath := flag.Bool("store-full-path", false, "store each file under its whole path in the VP instead of its basename, without directory markers, for consumers that read the index as a flat list (paths must fit in 31 bytes, and not differ only in case)")
+    flag.BoolVar(storeFullPath, "no-directory-entries", false, "the same as --store-full-path")
+    prefix := flag.String("prefix", "", "put everything in each VP inside this extra top level directory, or path of directories, like mods/mine")
+    trimPrefix := flag.String("trim-prefix", "", "take this path of directories, like data/mymod, out of each VP, putting what's in it at the top; anything not in it is an error (see --prefix for adding one)")
+    breakdown := flag.Bool("breakdown", false, "once everything's written, print the bytes and number of files of each extension across the VPs, largest first")
+    breakdownJSON := flag.Bool("breakdown-json", false, "like --breakdown, as a line of JSON")
+    manifestOut := flag.String("manifest-out", "", "once everything's written, write a JSON record of which file each entry of each VP was packed from, and where its data is, to this file")
+    summaryJSON := flag.Bool("summary-json", false, "like --summary, as a line of JSON: the VPs written, and each path skipped with its reason")
+    summary := flag.Bool("summary", false, "once everything is packed, list each VP written on stdout, sorted by path, with its size and entry count, then how many files and bytes the --exclude size limits left out, then a line for each path skipped, with why: excluded, too-large, too-small, unreadable, special-file or duplicate")
+    appendLogPath := flag.String("append-log", "", "append a line for each VP produced to this file: time, path, size, entry count and aztech version")
+    rootName := flag.String("root-name", "", "name to store the top directory of each VP under, instead of data")
+    twoPass := flag.Bool("two-pass-size", false, "write the header's index offset after the data instead of summing file sizes first")
+    lowerExtension := flag.Bool("lower-ext", false, "lowercase file extensions in stored names, leaving the rest of each name alone")
+    touchOutput := flag.String("touch-output-mtime", "", "set the modification time of each VP written, and of its checksum and index files, to this many seconds since 1970, or with source-latest, to the newest timestamp stored in the VP, for reproducible archives of the output")
+    buildEpoch := flag.String("build-epoch", "", "give every file the same timestamp, so everything in a build shares one time: now for the time the run started, or seconds since 1970")
+    reproducible := flag.Bool("reproducible", false, "make the output depend only on the input's paths and contents: file timestamps (and --embed-manifest's build time) are SOURCE_DATE_EPOCH, or 0 if it isn't set, instead of modification times; can't be used with --order readdir")
+    order := flag.String("order", "sorted", "order of entries within a directory: sorted by name, or readdir to keep the order the filesystem lists them in (output then depends on the filesystem)")
+    noDataCheck := flag.Bool("no-data-check", false, "pack inputs without a data directory: everything in the input goes into one VP named after it, with the input's own top level entries at the top of the VP instead of under data")
+    onlyDirs := flag.String("only-dir", "", "comma separated directories under data to pack, leaving out the rest, and the files directly in data (which otherwise go in data.vp)")
+    skipDirs := flag.String("skip-dir", "", "comma separated directories under data to leave out")
+    var excludeLarger, excludeSmaller aztech.ByteSize
+    flag.Var(&excludeLarger, "exclude-larger-than", "leave out files of more than this many bytes, which can end in K, M or G, as in 50M (0 for no limit)")
+    flag.Var(&excludeSmaller, "exclude-smaller-than", "leave out files of fewer than this many bytes, which can end in K, M or G")
+    var budget aztech.ByteSize
+    flag.Var(&budget, "budget", "instead of splitting, pack only the files that fit in a VP of this many bytes, which can end in K, M or G, as in 650M, leaving out the rest, as the summary lists")
+    budgetOrder := flag.String("budget-order", "largest", "which files --budget tries to fit first: largest, smallest (for the most files), or index, in the order they're packed")
+    var budgetPriority stringList
+    flag.Var(&budgetPriority, "budget-priority", "a pattern, like data/tables/**, for files --budget tries to fit before the rest, matched against paths in the VP; can be given more than once, earlier ones first")
+    allowNestedVP := flag.Bool("allow-nested-vp", false, "pack VPs found in the input without warning (or failing, under --strict); they're usually an earlier run's output left there by mistake")
+    followSymlinks := flag.Bool("follow-symlinks", false, "pack what symlinks point at, instead of skipping them like special files; a link to a directory in the input, or to one already packed through another link, is skipped with a warning rather than packed again")
+    normalizeEOL := flag.String("normalize-eol", "", "convert line endings in text files, picked by --eol-ext, to lf or crlf; binary files are never touched")
+    eolExt := flag.String("eol-ext", strings.Join(aztech.DefaultEOLExtensions, ","), "comma separated extensions of the files --normalize-eol converts")
+    entryFilter := flag.String("entry-filter", "", "pack only the files this expression is true for, like 'size > 1M && !match(\"**/*.txt\")', over name, path, ext, size and isDir; directories are kept if anything in them is")
+    var include, exclude stringList
+    flag.Var(&include, "include", "only pack files whose path from the top of the input matches this glob (can be given more than once); * ? and [...] match within a path element, as for path.Match, and an element of just ** matches any number of directories, as in **/*.tbl")
+    flag.Var(&exclude, "exclude", "leave out files and directories whose path from the top of the input matches this glob, in the same syntax as --include (can be given more than once); a directory left out isn't walked, so data/maps/** skips the whole of data/maps")
+    showVersion := flag.Bool("version", false, "print the version of aztech and exit")
+    progress := flag.Bool("progress", false, "report progress on stderr")
+    maxDepth := flag.Int("max-depth", 0, "fail if the input has directories nested deeper than this (0 for no limit)")
+    specialFiles := flag.String("special-files", "skip", "what to do with devices, sockets and FIFOs in the input: skip (with a warning) or error")
+    flag.Usage = func() {
+        fmt.Fprintf(os.Stderr, "usage: %s [pack] [flags] <input dir or archive>...\n", path.Base(os.Args[0]))
+        fmt.Fprintf(os.Stderr, "       %s rebuild [flags] <vp>\n", path.Base(os.Args[0]))
+        fmt.Fprintf(os.Stderr, "       %s extract [flags] <vp> <output dir>\n", path.Base(os.Args[0]))
+        fmt.Fprintf(os.Stderr, "       %s assert <vp> <expected.json>\n", path.Base(os.Args[0]))
+        fmt.Fprintf(os.Stderr, "       %s list [flags] <vp>\n", path.Base(os.Args[0]))
+        fmt.Fprintf(os.Stderr, "       %s compare [flags] <vp> <input directory>\n", path.Base(os.Args[0]))
+        fmt.Fprintf(os.Stderr, "       %s update [flags] <vp> <input directory>\n", path.Base(os.Args[0]))
+        fmt.Fprintf(os.Stderr, "       %s manifest [flags] <vp>\n", path.Base(os.Args[0]))
+        fmt.Fprintf(os.Stderr, "       %s verify <vp>...\n", path.Base(os.Args[0]))
+        fmt.Fprintf(os.Stderr, "       %s index [flags] <vp>\n", path.Base(os.Args[0]))
+        fmt.Fprintf(os.Stderr, "       %s du [flags] <dir>...\n", path.Base(os.Args[0]))
+        fmt.Fprintf(os.Stderr, "       %s check [flags] <vp>...\n", path.Base(os.Args[0]))
+        fmt.Fprintf(os.Stderr, "       %s reprocheck [pack flags] <input dir or archive>...\n", path.Base(os.Args[0]))
+        flag.PrintDefaults()
+    }
+    positional := parseInterspersed(flag.CommandLine, args)
+
+    if *showVersion {
+        fmt.Printf("aztech %s\n", aztech.Version())
+        return
+    }
+    if aztech.LogFormat != "text" && aztech.LogFormat != "kv" {
+        bad := aztech.LogFormat
+        aztech.LogFormat = "text"
+        fatalf("", "unknown --log-format %q, want text or kv", bad)
+    }
+    pad, err := strconv.ParseUint(*namePad, 0, 8)
+    if err != nil {
+        fatalf("", "bad --name-pad %q, want a byte like 0x00 or 0x20", *namePad)
+    }
+    if aztech.MaxNameBytes < 1 || aztech.MaxNameBytes > vp.MaxNameLength {
+        fatalf("", "--max-name-bytes %d is out of range, want 1 to %d", aztech.MaxNameBytes, vp.MaxNameLength)
+    }
+    if *onMissing != "skip" && *onMissing != "fail" {
+        fatalf("", "unknown --on-missing %q, want skip or fail", *onMissing)
+    }
+
+    // a --files-from list is read just like a --manifest one
+    if *filesFrom != "" {
+        if *manifestIn != "" {
+            fatalf("", "got both --manifest and --files-from, pass only one")
+        }
+        *manifestIn = *filesFrom
+    }
+    var inputs []string
+    switch {
+    case *input != "" && len(positional) > 0:
+        fatalf("", "got both --input %v and argument %v, pass only one", *input, positional[0])
+    case *manifestIn != "" && (*input != "" || len(positional) > 0):
+        fatalf("", "got both a file list and an input directory, pass only one")
+    case *input != "":
+        inputs = []string{*input}
+    case *manifestIn != "":
+        inputs = []string{*manifestIn}
+    case len(positional) > 0:
+        inputs = positional
+    default:
+        flag.Usage()
+        os.Exit(2)
+    }
+
+    var sourceDate time.Time
+    if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" && *reproducible {
+        secs, err := strconv.ParseInt(epoch, 10, 64)
+        if err != nil {
+            fatalf("", "bad SOURCE_DATE_EPOCH %q, want seconds since 1970", epoch)
+        }
+        sourceDate = time.Unix(secs, 0)
+    }
+    var epoch time.Time
+    switch *buildEpoch {
+    case "":
+    case "now":
+        epoch = time.Now()
+    default:
+        secs, err := strconv.ParseInt(*buildEpoch, 10, 64)
+        if err != nil {
+            fatalf("", "bad --build-epoch %q, want now or seconds since 1970", *buildEpoch)
+        }
+        epoch = time.Unix(secs, 0)
+    }
+    var outputMTime time.Time
+    switch *touchOutput {
+    case "", "source-latest":
+    default:
+        secs, err := strconv.ParseInt(*touchOutput, 10, 64)
+        if err != nil {
+            fatalf("", "bad --touch-output-mtime %q, want source-latest or seconds since 1970", *touchOutput)
+        }
+        outputMTime = time.Unix(secs, 0)
+    }
+    checksumName := ""
+    if *checksum {
+        checksumName = *checksumAlgo
+    }
+    var engineLimits *aztech.EngineLimits
+    if *validateEngine {
+        engineLimits = &aztech.EngineLimits{
+            MaxVPs: *engineMaxVPs,
+            MaxEntries: *engineMaxEntries,
+            MaxNameLength: *engineMaxName,
+            MaxPathLength: *engineMaxPath,
+            MaxDepth: *engineMaxDepth,
+        }
+    }
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+    opts := aztech.Options{
+        OutputDir: *output,
+        Clean: *clean,
+        FileLists: *manifestIn != "",
+        KeepGoing: *keepGoing,
+        SkipMissing: *onMissing == "skip",
+        NoDataCheck: *noDataCheck,
+        OnlyDirs: dirList(*onlyDirs),
+        SkipDirs: dirList(*skipDirs),
+        Include: include,
+        Exclude: exclude,
+        MaxDepth: *maxDepth,
+        SpecialFiles: *specialFiles,
+        Group: *group,
+        Order: *order,
+        LowerExt: *lowerExtension,
+        RootName: *rootName,
+        Prefix: *prefix,
+        TrimPrefix: *trimPrefix,
+        StoreFullPath: *storeFullPath,
+        NamePad: byte(pad),
+        MaxVPSize: *maxVPSize,
+        TargetSize: *targetSize,
+        MaxEntries: *maxEntries,
+        NoSplit: *noSplit,
+        Layout: *layout,
+        TwoPass: *twoPass,
+        EmbedManifest: *embed,
+        EmbedManifestPath: *embedPath,
+        EmbedHash: *embedHash,
+        Compress: *compress,
+        CompressLevel: *compressLevel,
+        Checksum: checksumName,
+        IndexFile: *writeIndex,
+        Estimate: *estimate,
+        TOCJSON: *tocJSON,
+        ExplainSplit: *explainSplit,
+        Summary: *summary,
+        SummaryJSON: *summaryJSON,
+        ManifestOut: *manifestOut,
+        Breakdown: *breakdown,
+        BreakdownJSON: *breakdownJSON,
+        AppendLog: *appendLogPath,
+        Progress: *progress,
+        Reproducible: *reproducible,
+        BuildEpoch: epoch,
+        SourceDate: sourceDate,
+        WriteRetries: *writeRetries,
+        OnExists: *onExists,
+        OutputMTime: outputMTime,
+        OutputMTimeFromSources: *touchOutput == "source-latest",
+        NormalizeEOL: *normalizeEOL,
+        EOLExtensions: dirList(*eolExt),
+        DryRun: *dryRun,
+        EngineLimits: engineLimits,
+        FollowSymlinks: *followSymlinks,
+        AllowNestedVP: *allowNestedVP,
+        ExcludeLargerThan: int64(excludeLarger),
+        ExcludeSmallerThan: int64(excludeSmaller),
+        EntryFilter: *entryFilter,
+        Budget: int64(budget),
+        BudgetOrder: *budgetOrder,
+        BudgetPriority: budgetPriority,
+    }
+    if reprocheck {
+        err = aztech.ReproCheck(ctx, inputs, opts)
+    } else {
+        err = aztech.Pack(ctx, inputs, opts)
+    }
+    if err != nil {
+        fatalf("", "%v", err)
+    }
+}
+
+// parseInterspersed parses args with flags, allowing flags after the
+// positional arguments too (as in "pack a b -o out"), and returns the
+// positional arguments.
+func parseInterspersed(flags *flag.FlagSet, args []string) []string {
+    positional := []string{}
+    for {
+        flags.Parse(args)
+        args = flags.Args()
+        if len(args) == 0 {
+            return positional
+        }
+        positional = append(positional, args[0])
+        args = args[1:]
+    }
+}
+
+// dirList splits a comma separated list of directory names.
+func dirList(list string) []string {
+    out := []string{}
+    for _, name := range strings.Split(list, ",") {
+        if name = strings.TrimSpace(name); name != "" {
+            out = append(out, name)
+        }
+    }
+    return out
+}
+
+// stringList is a flag that can be given more than once, collecting each.
+type stringList []string
+
+func (l *stringList) String() string {
+    return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+    *l = append(*l, v)
+    return nil
+}
+
+// fatalf logs an error and exits non-zero.
+func fatalf(p string, format string, args ...interface{}) {
+    msg := fmt.Sprintf(format, args...)
+    if aztech.LogFormat == "kv" {
+        aztech.LogEntry("error", p, -1, msg)
+        os.Exit(1)
+    }
+    log.Fatalf("error: %s\n", msg)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+    "bufio"
+    "flag"
+    "fmt"
+    "os"
+    "path"
+
+    "github.com/tcrayford/aztech"
+    "github.com/tcrayford/aztech/vp"
+)
+
+// manifestMain implements "aztech manifest", which writes a source
+// manifest for a VP to stdout, with the sources where extracting it would
+// put them. Packing with --manifest from that, after any edits, makes the
+// VP again.
+func manifestMain(args []string) {
+    flags := flag.NewFlagSet("manifest", flag.ExitOnError)
+    sourceDir := flags.String("source-dir", ".", "directory the VP is (or will be) extracted to, which source paths are given under")
+    flags.Usage = func() {
+        fmt.Fprintf(os.Stderr, "usage: %s manifest [flags] <vp>\n", path.Base(os.Args[0]))
+        flags.PrintDefaults()
+    }
+    flags.Parse(args)
+    if flags.NArg() != 1 {
+        flags.Usage()
+        os.Exit(2)
+    }
+    vpPath := flags.Arg(0)
+
+    f, err := aztech.OpenVP(vpPath)
+    if err != nil {
+        fatalf(vpPath, "%v", err)
+    }
+    defer f.Close()
+    entries, err := vp.ReadTOC(f, f.Size)
+    if err != nil {
+        fatalf(vpPath, "%v", err)
+    }
+    w := bufio.NewWriter(os.Stdout)
+    for _, entry := range entries {
+        if entry.IsDir {
+            continue
+        }
+        line := aztech.SourceManifestLine{Source: path.Join(*sourceDir, aztech.MemberPath(entry.Path)), ArchivePath: entry.Path}
+        if err := aztech.WriteSourceManifestLine(w, line); err != nil {
+            fatalf("", "%v", err)
+        }
+    }
+    if err := w.Flush(); err != nil {
+        fatalf("", "%v", err)
+    }
+}
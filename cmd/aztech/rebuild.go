@@ -0,0 +1,41 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+    "path"
+
+    "github.com/tcrayford/aztech"
+)
+
+// rebuildMain implements "aztech rebuild", which rewrites a VP with a clean
+// TOC laid out by the same rules as a fresh pack. Stray directory markers,
+// duplicate entries and unreferenced data are all dropped on the way, and
+// unbalanced markers are repaired as well as they can be.
+func rebuildMain(args []string) {
+    flags := flag.NewFlagSet("rebuild", flag.ExitOnError)
+    group := flags.String("group", "mixed", "order of entries within a directory: dirs-first, files-first or mixed")
+    output := flags.String("o", "", "write the rebuilt VP here instead of replacing the original")
+    flags.Usage = func() {
+        fmt.Fprintf(os.Stderr, "usage: %s rebuild [flags] <vp>\n", path.Base(os.Args[0]))
+        flags.PrintDefaults()
+    }
+    flags.Parse(args)
+    if flags.NArg() != 1 {
+        flags.Usage()
+        os.Exit(2)
+    }
+    if !aztech.ValidGroup(*group) {
+        fatalf("", "unknown --group %q, want dirs-first, files-first or mixed", *group)
+    }
+
+    vpPath := flags.Arg(0)
+    outPath := *output
+    if outPath == "" {
+        outPath = vpPath
+    }
+    if err := aztech.RebuildVP(vpPath, outPath, *group); err != nil {
+        fatalf(vpPath, "%v", err)
+    }
+}
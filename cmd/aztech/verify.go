@@ -0,0 +1,44 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+    "path"
+
+    "github.com/tcrayford/aztech"
+)
+
+// verifyMain implements "aztech verify", which checks VPs against their
+// checksum sidecars, going by each sidecar's extension for the algorithm,
+// or every part of a split set against its set checksum file.
+func verifyMain(args []string) {
+    flags := flag.NewFlagSet("verify", flag.ExitOnError)
+    flags.Usage = func() {
+        fmt.Fprintf(os.Stderr, "usage: %s verify <vp or .vpset checksum file>...\n", path.Base(os.Args[0]))
+        flags.PrintDefaults()
+    }
+    flags.Parse(args)
+    if flags.NArg() == 0 {
+        flags.Usage()
+        os.Exit(2)
+    }
+    failed := 0
+    for _, vpPath := range flags.Args() {
+        problems, err := aztech.Verify(vpPath)
+        if err != nil {
+            fatalf(vpPath, "%v", err)
+        }
+        for _, p := range problems {
+            aztech.LogEntry("error", vpPath, -1, p)
+        }
+        if len(problems) > 0 {
+            failed++
+        } else {
+            aztech.LogEntry("info", vpPath, -1, fmt.Sprintf("%v: OK", vpPath))
+        }
+    }
+    if failed > 0 {
+        fatalf("", "%d of %d VPs failed verification", failed, flags.NArg())
+    }
+}
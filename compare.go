@@ -0,0 +1,164 @@
+package aztech
+
+import (
+    "crypto/sha256"
+    "fmt"
+    "io"
+    "os"
+    "path"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/tcrayford/aztech/vp"
+)
+
+// Difference is one way a VP and the source it's compared with disagree.
+// Kind is "only-in-vp", "only-in-source", "size" or "content".
+type Difference struct {
+    Path string `json:"path"`
+    Kind string `json:"kind"`
+    Detail string `json:"detail,omitempty"`
+}
+
+func (d Difference) String() string {
+    switch d.Kind {
+    case "only-in-vp":
+        return fmt.Sprintf("%v is only in the VP", d.Path)
+    case "only-in-source":
+        return fmt.Sprintf("%v is only in the source", d.Path)
+    }
+    return fmt.Sprintf("%v differs in %v: %v", d.Path, d.Kind, d.Detail)
+}
+
+// CompareVP works out the TOC a pack of srcDir would give, with
+// sourceTOC, and compares it with the VP at vpPath, file by file. Paths
+// are matched case-insensitively, as the engine looks them up, and
+// differences come back sorted by path. The error is only for failing to
+// read either side at all. A VP that's one part of a split directory
+// holds only some of its files, so will show the rest as only in the
+// source.
+func CompareVP(vpPath string, srcDir string) ([]Difference, error) {
+    f, err := OpenVP(vpPath)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    entries, err := vp.ReadTOC(f, f.Size)
+    if err != nil {
+        return nil, err
+    }
+
+    expected, expectedPaths, err := sourceTOC(entries, srcDir, tocOptions{group: "mixed"})
+    if err != nil {
+        return nil, err
+    }
+
+    inSource := map[string]int{}
+    for i, entry := range expected {
+        if !entry.IsDir {
+            inSource[strings.ToLower(expectedPaths[i])] = i
+        }
+    }
+    diffs := []Difference{}
+    for _, entry := range entries {
+        if entry.IsDir {
+            continue
+        }
+        key := strings.ToLower(entry.Path)
+        i, ok := inSource[key]
+        if !ok {
+            diffs = append(diffs, Difference{Path: entry.Path, Kind: "only-in-vp"})
+            continue
+        }
+        delete(inSource, key)
+        want := expected[i]
+        if entry.Size != want.Size {
+            diffs = append(diffs, Difference{entry.Path, "size", fmt.Sprintf("%d bytes in the VP, %d in the source", entry.Size, want.Size)})
+            continue
+        }
+        same, err := sameContents(vp.OpenEntry(f, entry), want.Path)
+        if err != nil {
+            return nil, err
+        }
+        if !same {
+            diffs = append(diffs, Difference{entry.Path, "content", "same size, different bytes"})
+        }
+    }
+    for _, i := range inSource {
+        diffs = append(diffs, Difference{Path: expectedPaths[i], Kind: "only-in-source"})
+    }
+    sort.Slice(diffs, func(i, j int) bool {
+        return diffs[i].Path < diffs[j].Path
+    })
+    return diffs, nil
+}
+
+// sourceTOC works out the TOC a pack of srcDir would give for the VP
+// whose index is entries, the same way the pack command does with opts,
+// along with each entry's path in the archive.
+//
+// If the VP has a data directory, only the directories under data it
+// holds are packed, since a pack writes one VP for each, along with the
+// files directly in data if it has any of them; otherwise the whole of
+// srcDir is, as with --no-data-check.
+func sourceTOC(entries []vp.TOCEntry, srcDir string, opts tocOptions) ([]vp.TOCEntry, []string, error) {
+    root, err := walkDir(srcDir, walkOptions{})
+    if err != nil {
+        return nil, nil, err
+    }
+    units := map[string]bool{}
+    looseFiles := false
+    for _, entry := range entries {
+        if (entry.IsDir && entry.Name == "..") || path.Dir(entry.Path) != "data" {
+            continue
+        }
+        if entry.IsDir {
+            units[strings.ToLower(path.Base(entry.Path))] = true
+        } else {
+            looseFiles = true
+        }
+    }
+    var expected []vp.TOCEntry
+    if len(units) > 0 || looseFiles {
+        data := InputFileOrDir{"data", 0, time.Unix(0, 0), true, []InputFileOrDir{}}
+        for _, child := range root.children {
+            if child.isDir && path.Base(child.originalPath) == "data" {
+                for _, c := range child.children {
+                    if (c.isDir && units[strings.ToLower(path.Base(c.originalPath))]) || (!c.isDir && looseFiles) {
+                        data.children = append(data.children, c)
+                    }
+                }
+            }
+        }
+        expected, err = produceTOC(srcDir, data, opts)
+    } else {
+        expected, err = produceContentsTOC(srcDir, root, opts)
+    }
+    if err != nil {
+        return nil, nil, err
+    }
+    paths, err := vp.ArchivePaths(expected)
+    if err != nil {
+        return nil, nil, err
+    }
+    return expected, paths, nil
+}
+
+// sameContents says whether r holds the same bytes as the file at p, by
+// comparing their SHA-256s so neither has to be held in memory.
+func sameContents(r io.Reader, p string) (bool, error) {
+    f, err := os.Open(p)
+    if err != nil {
+        return false, err
+    }
+    defer f.Close()
+    a, b := sha256.New(), sha256.New()
+    if _, err := io.Copy(a, r); err != nil {
+        return false, err
+    }
+    if _, err := io.Copy(b, f); err != nil {
+        return false, err
+    }
+    return string(a.Sum(nil)) == string(b.Sum(nil)), nil
+}
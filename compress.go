@@ -0,0 +1,184 @@
+package aztech
+
+import (
+    "bytes"
+    "compress/gzip"
+    "fmt"
+    "io"
+    "os"
+    "strings"
+    "sync"
+)
+
+// A VP packed with Options.Compress is compressed whole, for moving it
+// around, and isn't something the engine can load: it reads VPs raw, so
+// one has to be decompressed, as with gunzip or unzstd, before it's
+// installed. The commands here that read VPs decompress them as they go,
+// going by their magic rather than their name; see OpenVP.
+const (
+    gzipMagic = "\x1f\x8b"
+    zstdMagic = "\x28\xb5\x2f\xfd"
+)
+
+// compressExt is the extension a VP compressed with method gets after its
+// .vp, or "" if method is none.
+func compressExt(method string) string {
+    switch method {
+    case "gzip":
+        return ".gz"
+    case "zstd":
+        return ".zst"
+    }
+    return ""
+}
+
+// isVPName reports whether name is a VP's, compressed or not.
+func isVPName(name string) bool {
+    name = strings.ToLower(name)
+    return strings.HasSuffix(name, ".vp") || strings.HasSuffix(name, ".vp.gz") || strings.HasSuffix(name, ".vp.zst")
+}
+
+// checkCompression fails unless method, with level, is one that can be
+// written. A level of 0 is the method's default.
+func checkCompression(method string, level int) error {
+    switch method {
+    case "":
+        if level != 0 {
+            return fmt.Errorf("a compression level needs a compression method")
+        }
+    case "gzip":
+        if level < 0 || level > gzip.BestCompression {
+            return fmt.Errorf("gzip compression level %d is out of range, want 1 to %d", level, gzip.BestCompression)
+        }
+    case "zstd":
+        if level < 0 || level > zstdMaxLevel {
+            return fmt.Errorf("zstd compression level %d is out of range, want 1 to %d", level, zstdMaxLevel)
+        }
+    default:
+        return fmt.Errorf("unknown compression %q, want gzip or zstd", method)
+    }
+    return nil
+}
+
+// compressWriter is w through method's encoder, at level. Closing it
+// flushes the encoder, but doesn't close w.
+func compressWriter(w io.Writer, method string, level int) (io.WriteCloser, error) {
+    if err := checkCompression(method, level); err != nil {
+        return nil, err
+    }
+    if method == "zstd" {
+        return newZstdWriter(w, level), nil
+    }
+    if level == 0 {
+        level = gzip.DefaultCompression
+    }
+    return gzip.NewWriterLevel(w, level)
+}
+
+// VPFile is a VP opened for reading, decompressed as it's read if it was
+// compressed for transport.
+type VPFile struct {
+    io.ReaderAt
+    Size int64
+    f *os.File
+}
+
+// OpenVP opens the VP at vpPath, decompressing it if needs be.
+//
+// A compressed VP isn't decompressed into memory, which a big one wouldn't
+// fit in, but as a stream, from the start to wherever's read; see
+// decompressedVP. That holds no more than the decoder's window, but means
+// a compressed VP can't be read in part the way a plain one is: finding its
+// size takes a pass through the whole of it, and its index, being at the
+// end, most of another.
+func OpenVP(vpPath string) (*VPFile, error) {
+    f, err := os.Open(vpPath)
+    if err != nil {
+        return nil, err
+    }
+    info, err := f.Stat()
+    if err != nil {
+        f.Close()
+        return nil, err
+    }
+    magic := make([]byte, len(zstdMagic))
+    n, _ := f.ReadAt(magic, 0)
+    magic = magic[:n]
+    var d *decompressedVP
+    switch {
+    case bytes.HasPrefix(magic, []byte(gzipMagic)):
+        d = &decompressedVP{f: f, size: info.Size(), decompress: func(r io.Reader) (io.Reader, error) {
+            return gzip.NewReader(r)
+        }}
+    case bytes.HasPrefix(magic, []byte(zstdMagic)):
+        d = &decompressedVP{f: f, size: info.Size(), decompress: func(r io.Reader) (io.Reader, error) {
+            return newZstdReader(r), nil
+        }}
+    default:
+        return &VPFile{f, info.Size(), f}, nil
+    }
+    size, err := d.decompressedSize()
+    if err != nil {
+        f.Close()
+        return nil, fmt.Errorf("decompressing %v: %w", vpPath, err)
+    }
+    return &VPFile{d, size, f}, nil
+}
+
+func (v *VPFile) Close() error {
+    return v.f.Close()
+}
+
+// decompressedVP is a ReaderAt over what a compressed VP decompresses to.
+// It decompresses from the start up to what's read, and carries on from
+// there for a read further on, as reading a VP's files in order is; one
+// further back starts again from the start.
+type decompressedVP struct {
+    f *os.File
+    size int64
+    decompress func(io.Reader) (io.Reader, error)
+
+    mu sync.Mutex
+    r io.Reader
+    // how far into the decompressed VP r is
+    pos int64
+}
+
+// decompressedSize decompresses the whole VP, to find how big it is.
+func (d *decompressedVP) decompressedSize() (int64, error) {
+    r, err := d.decompress(io.NewSectionReader(d.f, 0, d.size))
+    if err != nil {
+        return 0, err
+    }
+    return io.Copy(io.Discard, r)
+}
+
+func (d *decompressedVP) ReadAt(p []byte, off int64) (int, error) {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    if d.r == nil || off < d.pos {
+        r, err := d.decompress(io.NewSectionReader(d.f, 0, d.size))
+        if err != nil {
+            return 0, err
+        }
+        d.r, d.pos = r, 0
+    }
+    if off > d.pos {
+        skipped, err := io.CopyN(io.Discard, d.r, off - d.pos)
+        d.pos += skipped
+        if err != nil {
+            d.r = nil
+            return 0, err
+        }
+    }
+    n, err := io.ReadFull(d.r, p)
+    d.pos += int64(n)
+    switch err {
+    case nil:
+    case io.ErrUnexpectedEOF:
+        err = io.EOF
+    default:
+        d.r = nil
+    }
+    return n, err
+}
@@ -0,0 +1,93 @@
+package aztech
+
+import (
+    "context"
+    "io"
+    "path"
+    "reflect"
+    "runtime/debug"
+    "strings"
+    "testing"
+
+    "github.com/tcrayford/aztech/vp"
+)
+
+// readVP is every entry of the VP at vpPath, read back to front, opened
+// with OpenVP.
+func readVP(t *testing.T, vpPath string) map[string][]byte {
+    f, err := OpenVP(vpPath)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+    toc, err := vp.ReadTOC(f, f.Size)
+    if err != nil {
+        t.Fatalf("%v: %v", vpPath, err)
+    }
+    files := map[string][]byte{}
+    for i := len(toc) - 1; i >= 0; i-- {
+        b, err := io.ReadAll(vp.OpenEntry(f, toc[i]))
+        if err != nil {
+            t.Fatalf("%v: %v: %v", vpPath, toc[i].Path, err)
+        }
+        files[toc[i].Path] = b
+    }
+    return files
+}
+
+func TestOpenVPCompressed(t *testing.T) {
+    in := path.Join(t.TempDir(), "in")
+    files := map[string]string{}
+    for _, p := range []string{"data/maps/a.pof", "data/maps/b.pof", "data/maps/sub/c.dds"} {
+        files[p] = strings.Repeat(p, 1000)
+    }
+    writeFiles(t, in, files)
+    out := t.TempDir()
+    if err := Pack(context.Background(), []string{in}, Options{OutputDir: out}); err != nil {
+        t.Fatal(err)
+    }
+    want := readVP(t, path.Join(out, "maps.vp"))
+    for _, method := range []string{"gzip", "zstd"} {
+        out := t.TempDir()
+        if err := Pack(context.Background(), []string{in}, Options{OutputDir: out, Compress: method}); err != nil {
+            t.Fatal(err)
+        }
+        if got := readVP(t, path.Join(out, "maps.vp" + compressExt(method))); !reflect.DeepEqual(got, want) {
+            t.Errorf("%v: read back %q, want %q", method, got, want)
+        }
+    }
+}
+
+func TestOpenVPCompressedDoesntHoldIt(t *testing.T) {
+    if testing.Short() {
+        t.Skip("packs a large VP")
+    }
+    const size = 64 << 20
+    in := path.Join(t.TempDir(), "in")
+    writeFiles(t, in, map[string]string{"data/maps/big.pof": string(make([]byte, size))})
+    defer debug.SetGCPercent(debug.SetGCPercent(1))
+    for _, method := range []string{"gzip", "zstd"} {
+        out := t.TempDir()
+        if err := Pack(context.Background(), []string{in}, Options{OutputDir: out, Compress: method}); err != nil {
+            t.Fatal(err)
+        }
+        before := liveHeap()
+        var toc []vp.TOCEntry
+        peak := peakHeap(func() {
+            f, err := OpenVP(path.Join(out, "maps.vp" + compressExt(method)))
+            if err != nil {
+                t.Fatal(err)
+            }
+            defer f.Close()
+            if toc, err = vp.ReadTOC(f, f.Size); err != nil {
+                t.Fatal(err)
+            }
+        })
+        if len(toc) != 5 || toc[2].Size != size {
+            t.Fatalf("%v: read %v", method, toc)
+        }
+        if used := peak - before; used > size / 4 {
+            t.Errorf("%v: reading the index of a %d byte VP took up to %d bytes of heap", method, size, used)
+        }
+    }
+}
@@ -0,0 +1,43 @@
+package aztech
+
+import (
+    "io/ioutil"
+    "path"
+    "strings"
+)
+
+// DuTotal is how much file data, going by the index, some VPs hold.
+type DuTotal struct {
+    Name string `json:"name"`
+    Size int64 `json:"size"`
+    Files int `json:"files"`
+}
+
+// DuGroup is the top level directory inside a VP that the file at p, a
+// path in the archive, is counted under for --by-dir: the directory in the
+// top one, since that's data in any VP the engine loads, or the top one
+// itself for files directly in it.
+func DuGroup(p string) string {
+    elements := strings.Split(p, "/")
+    if len(elements) > 2 {
+        elements = elements[:2]
+    } else {
+        elements = elements[:len(elements) - 1]
+    }
+    return strings.Join(elements, "/") + "/"
+}
+
+// VPsIn lists the VPs directly in dir, compressed ones included, by name.
+func VPsIn(dir string) ([]string, error) {
+    fileInfos, err := ioutil.ReadDir(dir)
+    if err != nil {
+        return nil, err
+    }
+    vps := []string{}
+    for _, f := range fileInfos {
+        if f.Mode().IsRegular() && isVPName(f.Name()) {
+            vps = append(vps, path.Join(dir, f.Name()))
+        }
+    }
+    return vps, nil
+}
@@ -0,0 +1,64 @@
+package aztech
+
+import (
+    "fmt"
+    "strings"
+
+    "github.com/tcrayford/aztech/vp"
+)
+
+// EngineLimits are the most the engine can load, which --validate-engine
+// checks the VPs planned against. Zero means no limit.
+type EngineLimits struct {
+    // MaxVPs is how many VPs can be loaded at once, across the whole run.
+    MaxVPs int
+    // MaxEntries is how many index entries a VP can have, counting
+    // directory markers.
+    MaxEntries int
+    // MaxNameLength is the longest name, in bytes.
+    MaxNameLength int
+    // MaxPathLength is the longest path inside a VP, in bytes, like
+    // data/maps/foo.dds.
+    MaxPathLength int
+    // MaxDepth is how many directories deep a file can be in a VP,
+    // counting data.
+    MaxDepth int
+}
+
+// DefaultEngineLimits are FreeSpace 2 Open's: it loads up to 500 VPs
+// (MAX_ROOTS in cfilesystem.cpp), takes names of up to 31 bytes as the
+// format does, and paths shorter than CF_MAX_PATHNAME_LENGTH, 256. It has
+// no limit on entries or depth of its own.
+var DefaultEngineLimits = EngineLimits{
+    MaxVPs: 500,
+    MaxNameLength: vp.MaxNameLength,
+    MaxPathLength: 255,
+}
+
+// engineViolations lists everything in toc, the planned index of the VP
+// at vpPath, that goes over limits.
+func engineViolations(vpPath string, toc []vp.TOCEntry, limits EngineLimits) ([]string, error) {
+    out := []string{}
+    if limits.MaxEntries > 0 && len(toc) > limits.MaxEntries {
+        out = append(out, fmt.Sprintf("%v has %d index entries, more than the engine's %d", vpPath, len(toc), limits.MaxEntries))
+    }
+    paths, err := vp.ArchivePaths(toc)
+    if err != nil {
+        return nil, err
+    }
+    for i, entry := range toc {
+        if entry.IsDir && entry.Name == ".." {
+            continue
+        }
+        if limits.MaxNameLength > 0 && len(entry.Name) > limits.MaxNameLength {
+            out = append(out, fmt.Sprintf("%v: name %q is %d bytes, more than the engine's %d", vpPath, entry.Name, len(entry.Name), limits.MaxNameLength))
+        }
+        if limits.MaxPathLength > 0 && len(paths[i]) > limits.MaxPathLength {
+            out = append(out, fmt.Sprintf("%v: %v is %d bytes, more than the engine's %d", vpPath, paths[i], len(paths[i]), limits.MaxPathLength))
+        }
+        if depth := strings.Count(paths[i], "/"); !entry.IsDir && limits.MaxDepth > 0 && depth > limits.MaxDepth {
+            out = append(out, fmt.Sprintf("%v: %v is %d directories deep, more than the engine's %d", vpPath, paths[i], depth, limits.MaxDepth))
+        }
+    }
+    return out, nil
+}
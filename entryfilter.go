@@ -0,0 +1,368 @@
+package aztech
+
+import (
+    "fmt"
+    "path"
+    "strconv"
+    "strings"
+)
+
+// entryFilter is a compiled --entry-filter: an expression that decides,
+// for each file in the input, whether it's packed. Its grammar is
+//
+//  expr    = and { "||" and }
+//  and     = not { "&&" not }
+//  not     = "!" not | compare
+//  compare = operand [ ( "==" | "!=" | "<" | "<=" | ">" | ">=" ) operand ]
+//  operand = number | string | field | "true" | "false"
+//          | "match" "(" string ")" | "(" expr ")"
+//
+// A number is a count of bytes, which can end in K, M or G as the size
+// flags' can, and a string is double quoted, as in Go. The fields are
+// name, the basename; path, from the top of the input, like
+// data/maps/foo.pof; ext, the extension in lower case with its dot, or ""
+// for none; size, in bytes, 0 for a directory; and isDir. match(p) is
+// whether path matches the glob p, as with --include (see pathFilter).
+// Numbers compare with any operator, strings and booleans only with ==
+// and !=, and the whole expression has to be a boolean.
+//
+// Directories are kept if anything in them is, or if the filter is true
+// for them, as with isDir for ones that are empty anyway.
+type entryFilter struct {
+    source string
+    eval func(filterEntry) filterValue
+}
+
+// filterEntry is what an entryFilter is evaluated on.
+type filterEntry struct {
+    name string
+    path string
+    size int64
+    isDir bool
+}
+
+// filterValue is the value of an expression or part of one; which field
+// is used goes by its filterType.
+type filterValue struct {
+    b bool
+    n int64
+    s string
+}
+
+type filterType int
+
+const (
+    filterBool filterType = iota
+    filterNumber
+    filterString
+)
+
+func (t filterType) String() string {
+    return [...]string{"a boolean", "a number", "a string"}[t]
+}
+
+// parseEntryFilter compiles source, or says what's wrong with it.
+func parseEntryFilter(source string) (*entryFilter, error) {
+    tokens, err := filterTokens(source)
+    if err != nil {
+        return nil, fmt.Errorf("entry filter %q: %v", source, err)
+    }
+    p := &filterParser{tokens: tokens}
+    typ, eval, err := p.or()
+    if err == nil && p.pos < len(p.tokens) {
+        err = fmt.Errorf("unexpected %q", p.tokens[p.pos])
+    }
+    if err == nil && typ != filterBool {
+        err = fmt.Errorf("it's %v, not a boolean", typ)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("entry filter %q: %v", source, err)
+    }
+    return &entryFilter{source, eval}, nil
+}
+
+// keeps reports whether the filter is true for the entry at p, a path
+// from the top of the input.
+func (f *entryFilter) keeps(p string, size int64, isDir bool) bool {
+    return f.eval(filterEntry{path.Base(p), p, size, isDir}).b
+}
+
+// filterTokens splits source into operators, parentheses, words (fields,
+// keywords and numbers) and quoted strings.
+func filterTokens(source string) ([]string, error) {
+    tokens := []string{}
+    for i := 0; i < len(source); {
+        c := source[i]
+        switch {
+        case c == ' ' || c == '\t' || c == '\n':
+            i++
+        case c == '"':
+            end := i + 1
+            for end < len(source) && source[end] != '"' {
+                if source[end] == '\\' {
+                    end++
+                }
+                end++
+            }
+            if end >= len(source) {
+                return nil, fmt.Errorf("unterminated string at %d", i)
+            }
+            tokens = append(tokens, source[i:end + 1])
+            i = end + 1
+        case isWordByte(c):
+            end := i
+            for end < len(source) && isWordByte(source[end]) {
+                end++
+            }
+            tokens = append(tokens, source[i:end])
+            i = end
+        default:
+            op := ""
+            for _, candidate := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")"} {
+                if strings.HasPrefix(source[i:], candidate) {
+                    op = candidate
+                    break
+                }
+            }
+            if op == "" {
+                return nil, fmt.Errorf("unexpected %q at %d", c, i)
+            }
+            tokens = append(tokens, op)
+            i += len(op)
+        }
+    }
+    return tokens, nil
+}
+
+func isWordByte(c byte) bool {
+    return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// filterParser parses tokens by recursive descent, a level of the grammar
+// to a method, each returning the type of what it parsed and a function
+// evaluating it.
+type filterParser struct {
+    tokens []string
+    pos int
+}
+
+type filterEval func(filterEntry) filterValue
+
+func (p *filterParser) peek() string {
+    if p.pos < len(p.tokens) {
+        return p.tokens[p.pos]
+    }
+    return ""
+}
+
+func (p *filterParser) expect(token string) error {
+    if p.peek() != token {
+        if p.peek() == "" {
+            return fmt.Errorf("expected %q at the end", token)
+        }
+        return fmt.Errorf("expected %q, not %q", token, p.peek())
+    }
+    p.pos++
+    return nil
+}
+
+// logical parses operands joined by op, with next parsing each operand.
+func (p *filterParser) logical(op string, next func() (filterType, filterEval, error)) (filterType, filterEval, error) {
+    typ, eval, err := next()
+    if err != nil {
+        return 0, nil, err
+    }
+    for p.peek() == op {
+        p.pos++
+        rightType, right, err := next()
+        if err != nil {
+            return 0, nil, err
+        }
+        if typ != filterBool || rightType != filterBool {
+            return 0, nil, fmt.Errorf("%v needs booleans either side", op)
+        }
+        left := eval
+        if op == "&&" {
+            eval = func(e filterEntry) filterValue {
+                return filterValue{b: left(e).b && right(e).b}
+            }
+        } else {
+            eval = func(e filterEntry) filterValue {
+                return filterValue{b: left(e).b || right(e).b}
+            }
+        }
+    }
+    return typ, eval, nil
+}
+
+func (p *filterParser) or() (filterType, filterEval, error) {
+    return p.logical("||", p.and)
+}
+
+func (p *filterParser) and() (filterType, filterEval, error) {
+    return p.logical("&&", p.not)
+}
+
+func (p *filterParser) not() (filterType, filterEval, error) {
+    if p.peek() != "!" {
+        return p.compare()
+    }
+    p.pos++
+    typ, eval, err := p.not()
+    if err != nil {
+        return 0, nil, err
+    }
+    if typ != filterBool {
+        return 0, nil, fmt.Errorf("! needs a boolean, not %v", typ)
+    }
+    return filterBool, func(e filterEntry) filterValue {
+        return filterValue{b: !eval(e).b}
+    }, nil
+}
+
+func (p *filterParser) compare() (filterType, filterEval, error) {
+    typ, left, err := p.operand()
+    if err != nil {
+        return 0, nil, err
+    }
+    op := p.peek()
+    switch op {
+    case "==", "!=", "<", "<=", ">", ">=":
+    default:
+        return typ, left, nil
+    }
+    p.pos++
+    rightType, right, err := p.operand()
+    if err != nil {
+        return 0, nil, err
+    }
+    if typ != rightType {
+        return 0, nil, fmt.Errorf("can't compare %v with %v", typ, rightType)
+    }
+    if typ != filterNumber && op != "==" && op != "!=" {
+        return 0, nil, fmt.Errorf("%v only compares numbers", op)
+    }
+    return filterBool, func(e filterEntry) filterValue {
+        a, b := left(e), right(e)
+        switch op {
+        case "==":
+            return filterValue{b: a == b}
+        case "!=":
+            return filterValue{b: a != b}
+        case "<":
+            return filterValue{b: a.n < b.n}
+        case "<=":
+            return filterValue{b: a.n <= b.n}
+        case ">":
+            return filterValue{b: a.n > b.n}
+        }
+        return filterValue{b: a.n >= b.n}
+    }, nil
+}
+
+func (p *filterParser) operand() (filterType, filterEval, error) {
+    token := p.peek()
+    if token == "" {
+        return 0, nil, fmt.Errorf("expression ends too soon")
+    }
+    p.pos++
+    constant := func(v filterValue) filterEval {
+        return func(filterEntry) filterValue {
+            return v
+        }
+    }
+    switch {
+    case token == "(":
+        typ, eval, err := p.or()
+        if err != nil {
+            return 0, nil, err
+        }
+        return typ, eval, p.expect(")")
+    case token[0] == '"':
+        s, err := strconv.Unquote(token)
+        if err != nil {
+            return 0, nil, fmt.Errorf("bad string %v", token)
+        }
+        return filterString, constant(filterValue{s: s}), nil
+    case token[0] >= '0' && token[0] <= '9':
+        var size ByteSize
+        if err := size.Set(token); err != nil {
+            return 0, nil, err
+        }
+        return filterNumber, constant(filterValue{n: int64(size)}), nil
+    }
+    switch token {
+    case "true", "false":
+        return filterBool, constant(filterValue{b: token == "true"}), nil
+    case "name":
+        return filterString, func(e filterEntry) filterValue {
+            return filterValue{s: e.name}
+        }, nil
+    case "path":
+        return filterString, func(e filterEntry) filterValue {
+            return filterValue{s: e.path}
+        }, nil
+    case "ext":
+        return filterString, func(e filterEntry) filterValue {
+            return filterValue{s: strings.ToLower(path.Ext(e.name))}
+        }, nil
+    case "size":
+        return filterNumber, func(e filterEntry) filterValue {
+            return filterValue{n: e.size}
+        }, nil
+    case "isDir":
+        return filterBool, func(e filterEntry) filterValue {
+            return filterValue{b: e.isDir}
+        }, nil
+    case "match":
+        if err := p.expect("("); err != nil {
+            return 0, nil, err
+        }
+        if !strings.HasPrefix(p.peek(), "\"") {
+            return 0, nil, fmt.Errorf("match takes a quoted pattern")
+        }
+        pattern, err := strconv.Unquote(p.peek())
+        if err != nil {
+            return 0, nil, fmt.Errorf("bad string %v", p.peek())
+        }
+        if err := checkPattern(pattern); err != nil {
+            return 0, nil, err
+        }
+        p.pos++
+        if err := p.expect(")"); err != nil {
+            return 0, nil, err
+        }
+        return filterBool, func(e filterEntry) filterValue {
+            return filterValue{b: globMatch(pattern, e.path)}
+        }, nil
+    }
+    return 0, nil, fmt.Errorf("unknown %q, want a field (name, path, ext, size, isDir), match(...), a number or a string", token)
+}
+
+// dropByFilter returns dir without what filter doesn't keep, at any depth,
+// and how many files and bytes it left out. rel gives the path filter sees
+// for an originalPath. dir itself is kept whatever the filter says. What's
+// left out is noted in skips.
+func dropByFilter(dir InputFileOrDir, filter *entryFilter, rel func(string) string, skips skipLog) (InputFileOrDir, int, int64) {
+    dropped, bytes := 0, int64(0)
+    children := []InputFileOrDir{}
+    for _, c := range dir.children {
+        if c.isDir {
+            var n int
+            var b int64
+            c, n, b = dropByFilter(c, filter, rel, skips)
+            dropped, bytes = dropped + n, bytes + b
+            if len(c.children) == 0 && !filter.keeps(rel(c.originalPath), 0, true) {
+                skips.note(c.originalPath, skipFiltered)
+                continue
+            }
+        } else if !filter.keeps(rel(c.originalPath), int64(c.size), false) {
+            dropped, bytes = dropped + 1, bytes + int64(c.size)
+            skips.note(c.originalPath, skipFiltered)
+            continue
+        }
+        children = append(children, c)
+    }
+    dir.children = children
+    return dir, dropped, bytes
+}
@@ -0,0 +1,121 @@
+package aztech
+
+import (
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestFilterTokens(t *testing.T) {
+    for _, c := range []struct {
+        source string
+        want []string
+    }{
+        {"", []string{}},
+        {"size>=1K&&!isDir", []string{"size", ">=", "1K", "&&", "!", "isDir"}},
+        {" ( a||b )\t!=\nc ", []string{"(", "a", "||", "b", ")", "!=", "c"}},
+        {"<<=>>=", []string{"<", "<=", ">", ">="}},
+        {`name == "a \"b\" c"`, []string{"name", "==", `"a \"b\" c"`}},
+        {`match("**/*.pof")`, []string{"match", "(", `"**/*.pof"`, ")"}},
+    } {
+        got, err := filterTokens(c.source)
+        if err != nil || !reflect.DeepEqual(got, c.want) {
+            t.Errorf("%q: split into %q (%v), want %q", c.source, got, err, c.want)
+        }
+    }
+    for _, source := range []string{`name == "open`, `name == "ends in \"`, "size = 1", "ext == '.pof'", "a & b"} {
+        if got, err := filterTokens(source); err == nil {
+            t.Errorf("%q: split into %q with no error", source, got)
+        }
+    }
+}
+
+func TestEntryFilter(t *testing.T) {
+    pof := filterEntry{name: "A.POF", path: "data/maps/A.POF", size: 2048}
+    dir := filterEntry{name: "maps", path: "data/maps", isDir: true}
+    for _, c := range []struct {
+        source string
+        entry filterEntry
+        want bool
+    }{
+        // && binds tighter than ||, and ! tighter than both
+        {"true || false && false", pof, true},
+        {"(true || false) && false", pof, false},
+        {"false && false || true", pof, true},
+        {"!false && false", pof, false},
+        {"!(false && false)", pof, true},
+        // ! chains, and takes in a whole comparison
+        {"!!true", pof, true},
+        {"!!!true", pof, false},
+        {"! size > 1K", pof, false},
+        {"!isDir", dir, false},
+        // sizes, with suffixes either case
+        {"size == 2048", pof, true},
+        {"size == 2K", pof, true},
+        {"size == 2k", pof, true},
+        {"size > 1K && size < 1M", pof, true},
+        {"size >= 2K && size <= 2K", pof, true},
+        {"size != 2K", pof, false},
+        {"1M == 1048576", pof, true},
+        {"1G > 1023M", pof, true},
+        {"size == 0", dir, true},
+        // strings and fields
+        {`ext == ".pof"`, pof, true},
+        {`name == "A.POF"`, pof, true},
+        {`path == "data/maps/A.POF"`, pof, true},
+        {`path != "data/maps/a.pof"`, pof, true},
+        {`ext == ""`, dir, true},
+        {`isDir == true`, dir, true},
+        {`isDir != (size > 0)`, pof, true},
+        // match goes by path, as --include does
+        {`match("**/*.POF")`, pof, true},
+        {`match("data/*/*.POF")`, pof, true},
+        {`match("*.POF")`, pof, false},
+        {`match("data/**") && !isDir`, dir, false},
+    } {
+        f, err := parseEntryFilter(c.source)
+        if err != nil {
+            t.Errorf("%q: %v", c.source, err)
+            continue
+        }
+        if got := f.eval(c.entry).b; got != c.want {
+            t.Errorf("%q on %v is %v, want %v", c.source, c.entry.path, got, c.want)
+        }
+    }
+}
+
+func TestEntryFilterErrors(t *testing.T) {
+    for _, c := range []struct {
+        source string
+        err string
+    }{
+        {"", "ends too soon"},
+        {"size >", "ends too soon"},
+        {"size", "it's a number, not a boolean"},
+        {`name`, "it's a string, not a boolean"},
+        {"name == 3", "can't compare a string with a number"},
+        {"isDir == 1", "can't compare a boolean with a number"},
+        {`name < "b"`, "< only compares numbers"},
+        {"true >= false", ">= only compares numbers"},
+        {"!size", "! needs a boolean, not a number"},
+        {"!!name", "! needs a boolean, not a string"},
+        {"size && true", "&& needs booleans either side"},
+        {`true || ext`, "|| needs booleans either side"},
+        {"10Q > 1", `"10Q" isn't a size`},
+        {"-1 < size", `unexpected '-'`},
+        {"colour == 3", `unknown "colour"`},
+        {"(true", `expected ")" at the end`},
+        {"(true true)", `expected ")", not "true"`},
+        {"true true", `unexpected "true"`},
+        {"match", `expected "(" at the end`},
+        {"match(name)", "match takes a quoted pattern"},
+        {`match("data/[")`, "bad pattern"},
+        {`match("")`, "empty pattern"},
+        {`match("**/*.pof"`, `expected ")" at the end`},
+    } {
+        _, err := parseEntryFilter(c.source)
+        if err == nil || !strings.Contains(err.Error(), c.err) {
+            t.Errorf("%q: gave %v, want an error saying %q", c.source, err, c.err)
+        }
+    }
+}
@@ -0,0 +1,118 @@
+package aztech
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "path"
+    "strings"
+
+    "github.com/tcrayford/aztech/vp"
+)
+
+// DefaultEOLExtensions are the text files line endings are normalized in
+// when no others are given: tables, modular tables, missions, campaigns,
+// scripts and plain text.
+var DefaultEOLExtensions = []string{".tbl", ".tbm", ".fs2", ".fc2", ".lua", ".txt", ".cfg"}
+
+// eolReader converts every line ending in what it reads to LF, or with
+// crlf, to CRLF. A CR that isn't followed by an LF is left alone.
+type eolReader struct {
+    r *bufio.Reader
+    crlf bool
+    // whether the last byte read in was a CR
+    lastCR bool
+    // an LF still to be given out after the CR put before it
+    pendingLF bool
+}
+
+func newEOLReader(r io.Reader, crlf bool) *eolReader {
+    return &eolReader{r: bufio.NewReader(r), crlf: crlf}
+}
+
+func (e *eolReader) Read(p []byte) (int, error) {
+    n := 0
+    for n < len(p) {
+        if e.pendingLF {
+            p[n] = '\n'
+            n++
+            e.pendingLF = false
+            continue
+        }
+        b, err := e.r.ReadByte()
+        if err != nil {
+            if n > 0 {
+                return n, nil
+            }
+            return 0, err
+        }
+        wasCR := e.lastCR
+        e.lastCR = b == '\r'
+        if b == '\r' && !e.crlf {
+            if next, err := e.r.Peek(1); err == nil && next[0] == '\n' {
+                continue
+            }
+        }
+        if b == '\n' && e.crlf && !wasCR {
+            b = '\r'
+            e.pendingLF = true
+        }
+        p[n] = b
+        n++
+    }
+    return n, nil
+}
+
+// eolConverted reports whether the file at p is one of extensions, which
+// are matched without regard to case, with or without their dot.
+func eolConverted(p string, extensions []string) bool {
+    ext := strings.TrimPrefix(path.Ext(p), ".")
+    for _, e := range extensions {
+        if ext != "" && strings.EqualFold(ext, strings.TrimPrefix(e, ".")) {
+            return true
+        }
+    }
+    return false
+}
+
+// normalizeEOLSizes gives the files in toc that are one of extensions the
+// size they'll be once their line endings are converted, reading each
+// through an eolReader, and returns which those were by Path.
+// The sizes are in place before the TOC is split or laid out, so both,
+// and the header, agree with what eolTransform has printVP write.
+func normalizeEOLSizes(toc []vp.TOCEntry, src fileSource, crlf bool, extensions []string) ([]vp.TOCEntry, map[string]bool, error) {
+    out := append([]vp.TOCEntry{}, toc...)
+    converted := map[string]bool{}
+    for i, entry := range out {
+        if entry.IsDir || !eolConverted(entry.Path, extensions) {
+            continue
+        }
+        f, err := src.Open(entry.Path)
+        if err != nil {
+            return nil, nil, err
+        }
+        size, err := io.Copy(io.Discard, newEOLReader(f, crlf))
+        f.Close()
+        if err != nil {
+            return nil, nil, fmt.Errorf("reading %v: %w", entry.Path, err)
+        }
+        out[i].Size = int32(size)
+        converted[entry.Path] = true
+    }
+    return out, converted, nil
+}
+
+// eolTransform converts the line endings of the files in converted, with
+// the sizes normalizeEOLSizes gave them in toc, passing the rest through.
+func eolTransform(toc []vp.TOCEntry, converted map[string]bool, crlf bool) Transform {
+    sizes := map[string]int64{}
+    for _, entry := range toc {
+        sizes[entry.Path] = int64(entry.Size)
+    }
+    return func(p string, r io.Reader) (io.Reader, int64, error) {
+        if converted[p] {
+            r = newEOLReader(r, crlf)
+        }
+        return r, sizes[p], nil
+    }
+}
@@ -0,0 +1,90 @@
+package aztech
+
+import (
+    "context"
+    "io"
+    "path"
+    "reflect"
+    "strings"
+    "testing"
+    "testing/iotest"
+)
+
+func TestEOLReader(t *testing.T) {
+    for _, c := range []struct {
+        in string
+        lf string
+        crlf string
+    }{
+        {"", "", ""},
+        {"a\nb\n", "a\nb\n", "a\r\nb\r\n"},
+        {"a\r\nb\r\n", "a\nb\n", "a\r\nb\r\n"},
+        {"a\r\nb\nc", "a\nb\nc", "a\r\nb\r\nc"},
+        // a CR on its own is left alone
+        {"a\rb\r", "a\rb\r", "a\rb\r"},
+        {"\r\r\n\n", "\r\n\n", "\r\r\n\r\n"},
+    } {
+        for _, crlf := range []bool{false, true} {
+            want := c.lf
+            if crlf {
+                want = c.crlf
+            }
+            // a byte at a time too, so a CRLF is split across reads
+            for _, r := range []io.Reader{strings.NewReader(c.in), iotest.OneByteReader(strings.NewReader(c.in))} {
+                got, err := io.ReadAll(newEOLReader(r, crlf))
+                if err != nil || string(got) != want {
+                    t.Errorf("%q, crlf %v: read %q (%v), want %q", c.in, crlf, got, err, want)
+                }
+            }
+        }
+    }
+}
+
+func TestPackNormalizeEOL(t *testing.T) {
+    in := path.Join(t.TempDir(), "in")
+    files := map[string]string{
+        "data/tables/a.tbl": "one\r\ntwo\r\n",
+        "data/tables/b.TBM": "one\ntwo\n",
+        "data/tables/c.pof": "one\r\ntwo\n",
+        "data/tables/d.tbl": "three\r\nfour\r\nfive\r\n",
+    }
+    writeFiles(t, in, files)
+    for _, c := range []struct {
+        opts Options
+        want map[string]string
+    }{
+        // sizes change both ways, moving every offset after them, and
+        // what isn't text is left alone
+        {Options{NormalizeEOL: "lf"}, map[string]string{
+            "data/tables/a.tbl": "one\ntwo\n",
+            "data/tables/b.TBM": "one\ntwo\n",
+            "data/tables/c.pof": "one\r\ntwo\n",
+            "data/tables/d.tbl": "three\nfour\nfive\n",
+        }},
+        {Options{NormalizeEOL: "crlf"}, map[string]string{
+            "data/tables/a.tbl": "one\r\ntwo\r\n",
+            "data/tables/b.TBM": "one\r\ntwo\r\n",
+            "data/tables/c.pof": "one\r\ntwo\n",
+            "data/tables/d.tbl": "three\r\nfour\r\nfive\r\n",
+        }},
+        {Options{NormalizeEOL: "lf", EOLExtensions: []string{"pof", ".TBL"}}, map[string]string{
+            "data/tables/a.tbl": "one\ntwo\n",
+            "data/tables/b.TBM": "one\ntwo\n",
+            "data/tables/c.pof": "one\ntwo\n",
+            "data/tables/d.tbl": "three\nfour\nfive\n",
+        }},
+        {Options{}, files},
+    } {
+        for _, twoPass := range []bool{false, true} {
+            out := t.TempDir()
+            c.opts.OutputDir = out
+            c.opts.TwoPass = twoPass
+            if err := Pack(context.Background(), []string{in}, c.opts); err != nil {
+                t.Fatal(err)
+            }
+            if got := laidOutFiles(t, path.Join(out, "tables.vp")); !reflect.DeepEqual(got, c.want) {
+                t.Errorf("%q %q, two pass %v: packed %q, want %q", c.opts.NormalizeEOL, c.opts.EOLExtensions, twoPass, got, c.want)
+            }
+        }
+    }
+}
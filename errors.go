@@ -0,0 +1,17 @@
+package aztech
+
+import (
+    "errors"
+)
+
+// The errors Pack and the rest can fail with that a caller might want to
+// handle, wrapped with the details, to be picked out with errors.Is, along
+// with vp.ErrNameTooLong, for a name that doesn't fit the index or is
+// longer than --max-name-bytes allows, and vp.ErrSizeOverflow. Other
+// errors wrap what caused them where there's a cause, like an
+// *fs.PathError from reading an input, for errors.As.
+var (
+    // ErrArchiveExists is a VP to be written that's already there, when
+    // OnExists is "fail".
+    ErrArchiveExists = errors.New("VP already exists")
+)
@@ -0,0 +1,109 @@
+package aztech
+
+import (
+    "context"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path"
+    "time"
+
+    "github.com/tcrayford/aztech/vp"
+)
+
+// placeholderNote is written to the top of a --structure-only extraction,
+// so nobody takes the empty files in it for the real thing.
+const placeholderNote = ".aztech-placeholders"
+
+// ExtractOptions are the ways ExtractVP can be tweaked.
+type ExtractOptions struct {
+    // StructureOnly leaves files empty rather than copying them, with a
+    // placeholderNote file saying so
+    StructureOnly bool
+    // Progress, if not nil, is told the bytes extracted so far
+    Progress func(written, total int64)
+    // FileMode and DirMode are what files and directories are created
+    // with, less the umask, as the VP has no permissions of its own;
+    // 0644 and 0755 if zero
+    FileMode os.FileMode
+    DirMode os.FileMode
+}
+
+// withDefaults fills in the defaults that a zero field stands for.
+func (o ExtractOptions) withDefaults() ExtractOptions {
+    if o.FileMode == 0 {
+        o.FileMode = 0644
+    }
+    if o.DirMode == 0 {
+        o.DirMode = 0755
+    }
+    return o
+}
+
+// ExtractVP writes out everything in the VP at vpPath under outDir, at its
+// path inside the archive, with its stored timestamp. It stops as soon as
+// it can once ctx is cancelled. Files that exist already are written over,
+// keeping their permissions.
+func ExtractVP(ctx context.Context, vpPath string, outDir string, opts ExtractOptions) error {
+    opts = opts.withDefaults()
+    f, err := OpenVP(vpPath)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    entries, err := vp.ReadTOC(f, f.Size)
+    if err != nil {
+        return err
+    }
+
+    var total int64 = 0
+    for _, entry := range entries {
+        if !entry.IsDir {
+            total += int64(entry.Size)
+        }
+    }
+    var written int64 = 0
+    for _, entry := range entries {
+        if entry.IsDir && entry.Name == ".." {
+            continue
+        }
+        // MemberPath stops a hostile name from climbing out of outDir
+        target := path.Join(outDir, MemberPath(entry.Path))
+        if entry.IsDir {
+            if err := os.MkdirAll(target, opts.DirMode); err != nil {
+                return err
+            }
+            continue
+        }
+        if err := os.MkdirAll(path.Dir(target), opts.DirMode); err != nil {
+            return err
+        }
+        out, err := os.OpenFile(target, os.O_RDWR | os.O_CREATE | os.O_TRUNC, opts.FileMode)
+        if err != nil {
+            return err
+        }
+        if !opts.StructureOnly {
+            written, err = copyContext(ctx, out, vp.OpenEntry(f, entry), written, total, opts.Progress)
+        }
+        if closeErr := out.Close(); err == nil {
+            err = closeErr
+        }
+        if err != nil {
+            // don't leave a partly extracted file looking like a whole one
+            os.Remove(target)
+            return err
+        }
+        modTime := time.Unix(int64(entry.Timestamp), 0)
+        if err := os.Chtimes(target, modTime, modTime); err != nil {
+            return err
+        }
+    }
+    if opts.StructureOnly {
+        note := fmt.Sprintf("The files under here are empty placeholders for those in %v, extracted with --structure-only.\n", vpPath)
+        if err := os.MkdirAll(outDir, opts.DirMode); err != nil {
+            return err
+        }
+        return ioutil.WriteFile(path.Join(outDir, placeholderNote), []byte(note), opts.FileMode)
+    }
+    return nil
+}
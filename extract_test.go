@@ -0,0 +1,49 @@
+package aztech
+
+import (
+    "context"
+    "os"
+    "path"
+    "testing"
+)
+
+func TestExtractVPModes(t *testing.T) {
+    in := path.Join(t.TempDir(), "in")
+    writeFiles(t, in, map[string]string{"data/maps/a.pof": "aaa", "data/maps/sub/b.pof": "bbb"})
+    out := t.TempDir()
+    if err := Pack(context.Background(), []string{in}, Options{OutputDir: out}); err != nil {
+        t.Fatal(err)
+    }
+    for _, c := range []struct {
+        opts ExtractOptions
+        file os.FileMode
+        dir os.FileMode
+    }{
+        // a zero mode is the default, not no permissions at all
+        {ExtractOptions{}, 0644, 0755},
+        {ExtractOptions{FileMode: 0600}, 0600, 0755},
+        {ExtractOptions{FileMode: 0600, DirMode: 0700}, 0600, 0700},
+    } {
+        x := path.Join(t.TempDir(), "x")
+        if err := ExtractVP(context.Background(), path.Join(out, "maps.vp"), x, c.opts); err != nil {
+            t.Fatal(err)
+        }
+        // the umask can only take bits away, and leaves the owner's alone
+        check := func(p string, want os.FileMode, isDir bool) {
+            info, err := os.Stat(path.Join(x, p))
+            if err != nil {
+                t.Fatal(err)
+            }
+            if info.IsDir() != isDir || info.Mode().Perm() &^ want != 0 || info.Mode().Perm() & 0700 != want & 0700 {
+                t.Errorf("%+v: %v is %v, want %v less the umask", c.opts, p, info.Mode(), want)
+            }
+        }
+        check("data/maps", c.dir, true)
+        check("data/maps/sub", c.dir, true)
+        check("data/maps/a.pof", c.file, false)
+        check("data/maps/sub/b.pof", c.file, false)
+        if b, err := os.ReadFile(path.Join(x, "data/maps/sub/b.pof")); err != nil || string(b) != "bbb" {
+            t.Errorf("%+v: extracted b.pof holds %q (%v)", c.opts, b, err)
+        }
+    }
+}
@@ -0,0 +1,167 @@
+package aztech
+
+import (
+    "fmt"
+    "math"
+    "path"
+    "strconv"
+    "strings"
+)
+
+// pathFilter picks what's packed by glob patterns on paths from the top of
+// the input, like data/maps/foo.pof. A pattern is matched an element at a
+// time: *, ? and [...] work as for path.Match within an element, and an
+// element that's just ** matches any number of elements, none included.
+// So **/*.blend matches .blend files at any depth, and data/maps/** the
+// maps directory and everything in it.
+type pathFilter struct {
+    // include, if not empty, keeps only the files matching one of them
+    include []string
+    // exclude leaves out the files and directories matching any of them;
+    // a directory left out isn't walked at all
+    exclude []string
+}
+
+// checkPattern fails if pattern isn't a usable glob.
+func checkPattern(pattern string) error {
+    if pattern == "" {
+        return fmt.Errorf("empty pattern")
+    }
+    for _, element := range strings.Split(pattern, "/") {
+        if element == "**" {
+            continue
+        }
+        if _, err := path.Match(element, ""); err != nil {
+            return fmt.Errorf("bad pattern %q: %w", pattern, err)
+        }
+    }
+    return nil
+}
+
+// globMatch reports whether p matches pattern.
+func globMatch(pattern string, p string) bool {
+    return matchElements(strings.Split(pattern, "/"), strings.Split(p, "/"))
+}
+
+func matchElements(pattern []string, p []string) bool {
+    for len(pattern) > 0 {
+        if pattern[0] == "**" {
+            for i := 0; i <= len(p); i++ {
+                if matchElements(pattern[1:], p[i:]) {
+                    return true
+                }
+            }
+            return false
+        }
+        if len(p) == 0 {
+            return false
+        }
+        if ok, _ := path.Match(pattern[0], p[0]); !ok {
+            return false
+        }
+        pattern, p = pattern[1:], p[1:]
+    }
+    return len(p) == 0
+}
+
+// skips says whether the file or directory at p should be left out,
+// given that the directories it's in weren't.
+func (f pathFilter) skips(p string, isDir bool) bool {
+    for _, pattern := range f.exclude {
+        if globMatch(pattern, p) {
+            return true
+        }
+    }
+    if isDir || len(f.include) == 0 {
+        return false
+    }
+    for _, pattern := range f.include {
+        if globMatch(pattern, p) {
+            return false
+        }
+    }
+    return true
+}
+
+// skipsPath is skips for a path whose directories haven't been checked.
+func (f pathFilter) skipsPath(p string, isDir bool) bool {
+    for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+        if f.skips(dir, true) {
+            return true
+        }
+    }
+    return f.skips(p, isDir)
+}
+
+// relPath is the last depth elements of p: its path from the top of the
+// input, for something depth levels down.
+func relPath(p string, depth int) string {
+    elements := strings.Split(p, "/")
+    if depth < len(elements) {
+        elements = elements[len(elements) - depth:]
+    }
+    return strings.Join(elements, "/")
+}
+
+// dropBySize returns dir without the files in it, at any depth, smaller
+// than min bytes or larger than max, either being no limit if 0, and how
+// many files and bytes it left out. Directories are kept even if that
+// empties them, as they are by the patterns. What's left out is noted in
+// skips.
+func dropBySize(dir InputFileOrDir, min int64, max int64, skips skipLog) (InputFileOrDir, int, int64) {
+    if min == 0 && max == 0 {
+        return dir, 0, 0
+    }
+    dropped, bytes := 0, int64(0)
+    children := []InputFileOrDir{}
+    for _, c := range dir.children {
+        if c.isDir {
+            var n int
+            var b int64
+            c, n, b = dropBySize(c, min, max, skips)
+            dropped, bytes = dropped + n, bytes + b
+        } else if size := int64(c.size); size < min || (max > 0 && size > max) {
+            dropped, bytes = dropped + 1, bytes + size
+            if size < min {
+                skips.note(c.originalPath, skipTooSmall)
+            } else {
+                skips.note(c.originalPath, skipTooLarge)
+            }
+            continue
+        }
+        children = append(children, c)
+    }
+    dir.children = children
+    return dir, dropped, bytes
+}
+
+// ByteSize is a flag for a number of bytes, which can end in K, M or G
+// for kibibytes, mebibytes or gibibytes.
+type ByteSize int64
+
+func (s *ByteSize) String() string {
+    return strconv.FormatInt(int64(*s), 10)
+}
+
+func (s *ByteSize) Set(v string) error {
+    digits, multiplier := v, int64(1)
+    if v != "" {
+        switch v[len(v) - 1] {
+        case 'K', 'k':
+            multiplier = 1 << 10
+        case 'M', 'm':
+            multiplier = 1 << 20
+        case 'G', 'g':
+            multiplier = 1 << 30
+        }
+    }
+    if multiplier > 1 {
+        digits = v[:len(v) - 1]
+    }
+    n, err := strconv.ParseInt(digits, 10, 64)
+    if err != nil || n < 0 || n > math.MaxInt64 / multiplier {
+        return fmt.Errorf("%q isn't a size, like 1000, 64K or 50M", v)
+    }
+    *s = ByteSize(n * multiplier)
+    return nil
+}
@@ -0,0 +1,80 @@
+package aztech
+
+import (
+    "context"
+    "net"
+    "path"
+    "strings"
+    "testing"
+)
+
+func TestGlobMatch(t *testing.T) {
+    for _, c := range []struct {
+        pattern string
+        p string
+        want bool
+    }{
+        {"**/*.blend", "a.blend", true},
+        {"**/*.blend", "data/maps/a.blend", true},
+        {"**/*.blend", "data/maps/a.blend.bak", false},
+        {"**/*.blend", "data/maps", false},
+        {"a/**/b", "a/b", true},
+        {"a/**/b", "a/x/b", true},
+        {"a/**/b", "a/x/y/b", true},
+        {"a/**/b", "a/bb", false},
+        {"a/**/b", "a/b/c", false},
+        {"a/**/b", "x/a/b", false},
+        {"data/**", "data", true},
+        {"data/**", "data/maps", true},
+        {"data/**", "data/maps/a.pof", true},
+        {"data/**", "database", false},
+        {"data/**", "mod/data", false},
+        {"data/maps/*.pof", "data/maps/a.pof", true},
+        {"data/maps/*.pof", "data/maps/sub/a.pof", false},
+        {"*", "data", true},
+        {"*", "data/maps", false},
+        {"**", "data/maps/a.pof", true},
+        {"**/**/a.pof", "a.pof", true},
+        {"data/?.tbl", "data/a.tbl", true},
+        {"data/?.tbl", "data/ab.tbl", false},
+        {"data/[ab].tbl", "data/b.tbl", true},
+        {"data/[ab].tbl", "data/c.tbl", false},
+    } {
+        if err := checkPattern(c.pattern); err != nil {
+            t.Errorf("%q: %v", c.pattern, err)
+        }
+        if got := globMatch(c.pattern, c.p); got != c.want {
+            t.Errorf("%q matching %q is %v, want %v", c.pattern, c.p, got, c.want)
+        }
+    }
+    for _, pattern := range []string{"", "data/[", "**/[a-"} {
+        if checkPattern(pattern) == nil {
+            t.Errorf("%q was taken for a pattern", pattern)
+        }
+    }
+}
+
+func TestPackExcludedDirectoryNotWalked(t *testing.T) {
+    in := path.Join(t.TempDir(), "in")
+    writeFiles(t, in, map[string]string{"data/maps/a.pof": "a", "data/maps/junk/b.pof": "b"})
+    // a socket can't be packed, so walking into junk fails
+    l, err := net.Listen("unix", path.Join(in, "data/maps/junk/socket"))
+    if err != nil {
+        t.Skipf("can't make a socket to test with: %v", err)
+    }
+    defer l.Close()
+    if err := Pack(context.Background(), []string{in}, Options{OutputDir: t.TempDir(), SpecialFiles: "error"}); err == nil || !strings.Contains(err.Error(), "socket") {
+        t.Fatalf("packing with the socket gave %v", err)
+    }
+
+    for _, pattern := range []string{"data/maps/junk", "**/junk", "data/*/junk"} {
+        out := t.TempDir()
+        if err := Pack(context.Background(), []string{in}, Options{OutputDir: out, SpecialFiles: "error", Exclude: []string{pattern}}); err != nil {
+            t.Errorf("excluding %q: %v", pattern, err)
+            continue
+        }
+        if got := vpPaths(t, path.Join(out, "maps.vp")); strings.Join(got, " ") != "data data/maps data/maps/a.pof data ." {
+            t.Errorf("excluding %q packed %q", pattern, got)
+        }
+    }
+}
@@ -0,0 +1,3 @@
+module github.com/tcrayford/aztech
+
+go 1.24
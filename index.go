@@ -0,0 +1,43 @@
+package aztech
+
+import (
+    "encoding/binary"
+    "fmt"
+    "io"
+
+    "github.com/tcrayford/aztech/vp"
+)
+
+// IndexFile reads the header and index of the VP at vpPath, as indexBytes
+// gives them.
+func IndexFile(vpPath string) ([]byte, error) {
+    f, err := OpenVP(vpPath)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    return indexBytes(f, f.Size)
+}
+
+// indexBytes returns the raw 16 byte header of the VP in r, which is size
+// bytes long, followed straight away by its index. Both are copied as
+// they are, so every offset, the header's index offset included, is still
+// a position in the VP rather than in what's returned; the index is
+// always at byte 16 of that. The VP is checked as vp.ReadTOC would first.
+func indexBytes(r io.ReaderAt, size int64) ([]byte, error) {
+    if _, err := vp.ReadTOC(r, size); err != nil {
+        return nil, err
+    }
+    header := make([]byte, vp.HeaderSize)
+    if _, err := r.ReadAt(header, 0); err != nil {
+        return nil, fmt.Errorf("reading header: %w", err)
+    }
+    indexOffset := int64(binary.LittleEndian.Uint32(header[8:12]))
+    count := int64(binary.LittleEndian.Uint32(header[12:16]))
+    out := make([]byte, vp.HeaderSize + count * vp.IndexEntrySize)
+    copy(out, header)
+    if _, err := r.ReadAt(out[vp.HeaderSize:], indexOffset); err != nil {
+        return nil, fmt.Errorf("reading index: %w", err)
+    }
+    return out, nil
+}
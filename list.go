@@ -0,0 +1,56 @@
+package aztech
+
+import (
+    "encoding/json"
+    "fmt"
+
+    "github.com/tcrayford/aztech/vp"
+)
+
+// tocJSONEntry is how an index entry is shown by list --json and pack's
+// --toc-json, which share a form so one can be checked against the other.
+type tocJSONEntry struct {
+    Path string `json:"path"`
+    Name string `json:"name"`
+    Offset int32 `json:"offset"`
+    Size int32 `json:"size"`
+    Timestamp int32 `json:"timestamp"`
+    Dir bool `json:"dir,omitempty"`
+}
+
+// PrintTOCJSON prints the index toc, for the VP at vpPath, as one line of
+// JSON on stdout: the VP's path and every entry, in index order.
+func PrintTOCJSON(vpPath string, toc []vp.TOCEntry) error {
+    paths, err := vp.ArchivePaths(toc)
+    if err != nil {
+        return err
+    }
+    return printEntriesJSON(vpPath, toc, paths)
+}
+
+// PrintEntriesJSON is PrintTOCJSON for entries read back from a VP, whose
+// Paths are already where they are in the archive. Those can be any of
+// the VP's entries, as they needn't make up a whole index.
+func PrintEntriesJSON(vpPath string, entries []vp.TOCEntry) error {
+    paths := make([]string, len(entries))
+    for i, entry := range entries {
+        paths[i] = entry.Path
+    }
+    return printEntriesJSON(vpPath, entries, paths)
+}
+
+func printEntriesJSON(vpPath string, toc []vp.TOCEntry, paths []string) error {
+    entries := make([]tocJSONEntry, len(toc))
+    for i, entry := range toc {
+        entries[i] = tocJSONEntry{paths[i], entry.Name, entry.Offset, entry.Size, entry.Timestamp, entry.IsDir}
+    }
+    out, err := json.Marshal(struct {
+        VP string `json:"vp"`
+        Entries []tocJSONEntry `json:"entries"`
+    }{vpPath, entries})
+    if err != nil {
+        return err
+    }
+    fmt.Println(string(out))
+    return nil
+}
@@ -0,0 +1,215 @@
+package aztech
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// LogFormat is how diagnostics are written to stderr: "text" for the
+// usual prose, or "kv" for one key=value record per line (level, msg and,
+// where known, path and size) for log shippers to index.
+var LogFormat = "text"
+
+// Verbose has LogEntry write debug records, which are otherwise left out.
+var Verbose = false
+
+// StrictMode turns everything complain is told about into an error, for
+// builds that should stop on anything questionable. That covers:
+//   - names that aren't plain ASCII
+//   - files and directories the engine can't tell apart by name
+//   - empty files (otherwise packed all the same)
+//   - special files and non-regular archive members (otherwise skipped)
+//   - files listed twice in an archive input (otherwise the first is used)
+//   - an output directory inside the input (otherwise excluded)
+var StrictMode = false
+
+// Why something was left out, for the summary.
+const (
+    // by a pattern, or by OnlyDirs or SkipDirs
+    skipExcluded = "excluded"
+    // by the size limits
+    skipTooLarge = "too-large"
+    skipTooSmall = "too-small"
+    // by the budget
+    skipOverBudget = "over-budget"
+    // by the entry filter
+    skipFiltered = "filtered"
+    // gone, or a symlink to nothing
+    skipUnreadable = "unreadable"
+    // not a regular file or directory, symlinks not followed included
+    skipSpecialFile = "special-file"
+    // a repeat of something packed already
+    skipDuplicate = "duplicate"
+    // a VP that's in the output directory already
+    skipExists = "exists"
+)
+
+// skippedEntry is something left out of the VPs, and why.
+type skippedEntry struct {
+    Path string `json:"path"`
+    Reason string `json:"reason"`
+}
+
+// skipLog is everything left out of the VPs in a run, and why, for the
+// summary. Each packer has its own, handed down to what it walks and
+// writes with; a nil one, as everything else walks with, notes nothing.
+type skipLog map[skippedEntry]bool
+
+// note records that p was left out, and why: one of the reasons above.
+func (l skipLog) note(p string, reason string) {
+    if l != nil {
+        l[skippedEntry{p, reason}] = true
+    }
+}
+
+// entries lists what's been skipped by reason, then path.
+func (l skipLog) entries() []skippedEntry {
+    out := []skippedEntry{}
+    for s := range l {
+        out = append(out, s)
+    }
+    sort.Slice(out, func(i, j int) bool {
+        if out[i].Reason != out[j].Reason {
+            return out[i].Reason < out[j].Reason
+        }
+        return out[i].Path < out[j].Path
+    })
+    return out
+}
+
+// LogEntry writes a single diagnostic. p may be empty and size negative
+// when they don't apply; in text format they're only shown if msg
+// mentions them. Debug records are dropped unless Verbose is set.
+func LogEntry(level string, p string, size int64, msg string) {
+    if level == "debug" && !Verbose {
+        return
+    }
+    if LogFormat == "kv" {
+        fields := []string{"level=" + kvValue(level), "msg=" + kvValue(msg)}
+        if p != "" {
+            fields = append(fields, "path=" + kvValue(p))
+        }
+        if size >= 0 {
+            fields = append(fields, "size=" + strconv.FormatInt(size, 10))
+        }
+        fmt.Fprintln(os.Stderr, strings.Join(fields, " "))
+        return
+    }
+    switch level {
+    case "warning", "error":
+        fmt.Fprintf(os.Stderr, "%s: %s\n", level, msg)
+    default:
+        fmt.Fprintln(os.Stderr, msg)
+    }
+}
+
+func warnf(p string, format string, args ...interface{}) {
+    LogEntry("warning", p, -1, fmt.Sprintf(format, args...))
+}
+
+// complain reports something questionable about p. Under --strict it's
+// returned as an error to fail with; otherwise it's logged as a warning,
+// along with what's being done about it (if anything), and nil is
+// returned so work can go on.
+func complain(p string, consequence string, format string, args ...interface{}) error {
+    msg := fmt.Sprintf(format, args...)
+    if StrictMode {
+        return errors.New(msg)
+    }
+    if consequence != "" {
+        msg += ", " + consequence
+    }
+    warnf(p, "%s", msg)
+    return nil
+}
+
+// kvValue quotes v if it would otherwise break up the record.
+func kvValue(v string) string {
+    if v == "" || strings.ContainsAny(v, " \t\r\n\"=\\") || !strconv.CanBackquote(v) {
+        return strconv.Quote(v)
+    }
+    return v
+}
+
+// appendLog records a produced VP at the end of the log at logPath, one
+// tab separated line of when, where, how many bytes and entries, and the
+// aztech version. The line goes out in a single write to a file opened
+// for appending, so lines from concurrent runs don't interleave.
+func appendLog(logPath string, vpPath string, size int64, entries int) error {
+    f, err := os.OpenFile(logPath, os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0644)
+    if err != nil {
+        return err
+    }
+    line := fmt.Sprintf("%s\t%s\t%d\t%d\t%s\n", time.Now().UTC().Format(time.RFC3339), vpPath, size, entries, Version())
+    _, err = f.Write([]byte(line))
+    if closeErr := f.Close(); err == nil {
+        err = closeErr
+    }
+    return err
+}
+
+// writtenVP is a VP a run produced, for --summary, and for --manifest-out
+// the input it came from and its files.
+type writtenVP struct {
+    path string
+    size int64
+    entries int
+    input string
+    mapped []mappedEntry
+}
+
+// printSummary lists vps on stdout, a tab separated line of path, size and
+// entry count for each. They're sorted by path rather than left in the
+// order they were written, which follows the walk, so that reports from
+// different runs can be diffed. If any files were left out for their
+// size, a line gives how many and their size in the same columns, with a
+// path of "excluded by size". Then comes a line for everything skipped,
+// of "skipped", the reason and the path, as skipLog.entries orders them.
+func printSummary(vps []writtenVP, excluded int, excludedBytes int64, skipped skipLog) {
+    for _, vp := range sortedVPs(vps) {
+        fmt.Printf("%s\t%d\t%d\n", vp.path, vp.size, vp.entries)
+    }
+    if excluded > 0 {
+        fmt.Printf("excluded by size\t%d\t%d\n", excludedBytes, excluded)
+    }
+    for _, s := range skipped.entries() {
+        fmt.Printf("skipped\t%s\t%s\n", s.Reason, s.Path)
+    }
+}
+
+// printSummaryJSON is printSummary as a line of JSON.
+func printSummaryJSON(vps []writtenVP, skipped skipLog) error {
+    type vpJSON struct {
+        Path string `json:"path"`
+        Size int64 `json:"size"`
+        Entries int `json:"entries"`
+    }
+    written := []vpJSON{}
+    for _, vp := range sortedVPs(vps) {
+        written = append(written, vpJSON{vp.path, vp.size, vp.entries})
+    }
+    out, err := json.Marshal(struct {
+        VPs []vpJSON `json:"vps"`
+        Skipped []skippedEntry `json:"skipped"`
+    }{written, skipped.entries()})
+    if err != nil {
+        return err
+    }
+    fmt.Println(string(out))
+    return nil
+}
+
+// sortedVPs is vps sorted by path.
+func sortedVPs(vps []writtenVP) []writtenVP {
+    sorted := append([]writtenVP{}, vps...)
+    sort.Slice(sorted, func(i, j int) bool {
+        return sorted[i].path < sorted[j].path
+    })
+    return sorted
+}
@@ -0,0 +1,109 @@
+package aztech
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "path"
+    "strings"
+    "time"
+
+    "github.com/tcrayford/aztech/vp"
+)
+
+// memSource serves generated files from memory, falling back to the
+// wrapped fileSource for everything else.
+type memSource struct {
+    fileSource
+    files map[string][]byte
+}
+
+func (s memSource) Open(name string) (io.ReadCloser, error) {
+    if b, ok := s.files[name]; ok {
+        return ioutil.NopCloser(bytes.NewReader(b)), nil
+    }
+    return s.fileSource.Open(name)
+}
+
+// embedManifest adds a generated text file at p inside chunk, the TOC for
+// the VP called vpName, saying what built it and when and listing every
+// file in it. The returned source serves the manifest on top of src.
+//
+// The manifest goes in after splitting, so isn't counted against any of
+// the split limits.
+func embedManifest(chunk []vp.TOCEntry, src fileSource, vpName string, p string, built time.Time) ([]vp.TOCEntry, fileSource, error) {
+    content, err := manifestText(vpName, chunk, built)
+    if err != nil {
+        return nil, nil, err
+    }
+    name, err := checkName(p)
+    if err != nil {
+        return nil, nil, err
+    }
+    chunk, err = insertFile(chunk, p, vp.TOCEntry {
+        Size: int32(len(content)),
+        Name: name,
+        Timestamp: int32(built.Unix()),
+        Path: p,
+    })
+    if err != nil {
+        return nil, nil, err
+    }
+    return chunk, memSource{src, map[string][]byte{p: content}}, nil
+}
+
+func manifestText(vpName string, chunk []vp.TOCEntry, built time.Time) ([]byte, error) {
+    var b bytes.Buffer
+    fmt.Fprintf(&b, "%s, packed by aztech %s\n", vpName, Version())
+    fmt.Fprintf(&b, "built %s\n\n", built.UTC().Format(time.RFC3339))
+    paths, err := vp.ArchivePaths(chunk)
+    if err != nil {
+        return nil, err
+    }
+    for i, entry := range chunk {
+        if !entry.IsDir {
+            fmt.Fprintf(&b, "%s\t%d\n", paths[i], entry.Size)
+        }
+    }
+    return b.Bytes(), nil
+}
+
+// insertFile puts entry into chunk at p: inside the markers for p's
+// directory, ahead of the first entry there that sorts after it. If
+// that directory isn't in chunk, it's opened just for entry at the end.
+func insertFile(chunk []vp.TOCEntry, p string, entry vp.TOCEntry) ([]vp.TOCEntry, error) {
+    parent := path.Dir(p)
+    dir := "."
+    for i, e := range chunk {
+        if dir == parent {
+            if e.Name == entry.Name {
+                return nil, fmt.Errorf("can't add %v, there's already an entry there", p)
+            }
+            if (e.IsDir && e.Name == "..") || e.Name > entry.Name {
+                out := append([]vp.TOCEntry{}, chunk[:i]...)
+                out = append(out, entry)
+                return append(out, chunk[i:]...), nil
+            }
+        }
+        if e.IsDir && e.Name == ".." {
+            dir = path.Dir(dir)
+        } else if e.IsDir {
+            dir = path.Join(dir, e.Name)
+        }
+    }
+    out := append([]vp.TOCEntry{}, chunk...)
+    opened := []string{}
+    if parent != "." {
+        opened = strings.Split(parent, "/")
+    }
+    for i, name := range opened {
+        out = append(out, vp.TOCEntry {
+            Name: name,
+            Path: path.Join(opened[:i + 1]...),
+            IsDir: true,
+        })
+    }
+    out = append(out, entry)
+    return closeDirs(out, out[len(chunk):len(chunk) + len(opened)]), nil
+}
@@ -0,0 +1,1068 @@
+package aztech
+
+import (
+    "context"
+    "fmt"
+    "hash"
+    "io"
+    "io/fs"
+    "io/ioutil"
+    "math"
+    "os"
+    "path"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/tcrayford/aztech/vp"
+)
+
+// Options says how Pack packs its inputs. Every field's zero value is
+// what aztech does without the matching flag, so Options{} packs the way
+// a bare "aztech <input>" does, and fields added later won't change what
+// existing callers get.
+//
+// Strictness, the name length limit and the log format aren't here: they
+// apply to everything the package does, not just packing, so are the
+// package variables StrictMode, MaxNameBytes and LogFormat.
+type Options struct {
+    // OutputDir is where the VPs are written; "tmp" if empty. It has to
+    // exist already.
+    OutputDir string
+    // OnExists is what to do about a VP that's already in OutputDir:
+    // "fail" (the default), "overwrite" it, "rename" the new one, as
+    // freePath does, or "skip" it, leaving the old one. Under KeepGoing,
+    // "fail" skips it too, failing once the rest are written. An old VP
+    // that's overwritten is only replaced once the new one is written in
+    // full, so stays as it was if packing fails.
+    OnExists string
+    // Clean removes the VPs already in OutputDir, and the files named
+    // after them, before anything is packed.
+    Clean bool
+    // FileLists takes each input as a list of files to pack, in the
+    // source manifest format, instead of a directory or archive.
+    FileLists bool
+    // KeepGoing skips files in a list that don't exist, with a warning,
+    // and VPs that exist already when OnExists is "fail", with an error
+    // for each that Pack fails with at the end.
+    KeepGoing bool
+    // SkipMissing leaves out files that go between the walk and being
+    // packed, with a warning, instead of failing.
+    SkipMissing bool
+    // NoDataCheck packs inputs without a data directory, each into one VP
+    // named after it.
+    NoDataCheck bool
+    // OnlyDirs, if not empty, are the directories under data to pack,
+    // leaving out the rest and the files directly in data, which
+    // otherwise go in data.vp; SkipDirs are directories to leave out.
+    OnlyDirs []string
+    SkipDirs []string
+    // Include, if not empty, are glob patterns (see pathFilter) picking
+    // the files to pack; files and directories matching one of Exclude
+    // are left out.
+    Include []string
+    Exclude []string
+    // ExcludeSmallerThan and ExcludeLargerThan, if above 0, leave out
+    // files of fewer or more bytes than them.
+    ExcludeSmallerThan int64
+    ExcludeLargerThan int64
+    // EntryFilter, if set, is an expression picking the files to pack;
+    // see entryFilter for what it can say.
+    EntryFilter string
+    // FollowSymlinks packs what symlinks in input directories point at,
+    // rather than skipping them like special files; see linkTracker.
+    FollowSymlinks bool
+    // AllowNestedVP packs VPs found in input directories without the
+    // warning, or under --strict, the error, they'd otherwise get.
+    AllowNestedVP bool
+    // MaxDepth, if above 0, is how deep directories can be nested.
+    MaxDepth int
+    // SpecialFiles is "skip" (the default) or "error".
+    SpecialFiles string
+
+    // Group is "mixed" (the default), "dirs-first" or "files-first".
+    Group string
+    // Order is "sorted" (the default) or "readdir".
+    Order string
+    // LowerExt lowercases file extensions in stored names.
+    LowerExt bool
+    // RootName, if set, is stored in place of data.
+    RootName string
+    // Prefix, if set, is an extra top level directory in each VP, or a
+    // path of them. TrimPrefix, if set, is a path of directories taken
+    // out, everything in each VP having to be in it; it's taken out
+    // before Prefix is put in.
+    Prefix string
+    TrimPrefix string
+    // StoreFullPath stores files under their whole paths, without
+    // directory markers.
+    StoreFullPath bool
+    // NamePad fills name fields after each name's NUL.
+    NamePad byte
+
+    // MaxVPSize caps the file data in each VP; 1000000000 if 0.
+    MaxVPSize int
+    // TargetSize, if above 0, is how big to fill each VP before the next.
+    TargetSize int64
+    // MaxEntries, if above 0, caps the index entries in each VP.
+    MaxEntries int
+    // Budget, if above 0, is how big each VP can be, header and index
+    // included. Instead of splitting, the files that fit are packed and
+    // the rest left out: see fitBudget, which BudgetOrder and
+    // BudgetPriority are for.
+    Budget int64
+    BudgetOrder string
+    BudgetPriority []string
+    // NoSplit fails instead of splitting anything over those limits.
+    NoSplit bool
+
+    // WriteRetries is how many times a file that fails to copy with a
+    // transient error is written again from its start before giving up.
+    WriteRetries int
+    // Layout is "index" (the default) or "small-first".
+    Layout string
+    // NormalizeEOL, if "lf" or "crlf", converts line endings to it in the
+    // files with one of EOLExtensions, DefaultEOLExtensions if empty.
+    NormalizeEOL string
+    EOLExtensions []string
+    // Transform, if not nil, rewrites each file's contents as it's written
+    // into a VP. It runs after NormalizeEOL, getting the converted
+    // contents of the files that converts. Splitting, budgets and anything
+    // else that goes by size use the sizes from before it; the header and
+    // index get the sizes it gives. It can't be used with PackStream.
+    Transform Transform
+    // TwoPass patches the header after the data instead of summing sizes.
+    TwoPass bool
+    // EmbedManifest adds a manifest at EmbedManifestPath inside each VP,
+    // "data/aztech-manifest.txt" if empty.
+    EmbedManifest bool
+    EmbedManifestPath string
+    // EmbedHash appends a SHA-256 trailer to each VP.
+    EmbedHash bool
+    // Compress, if set, is how each VP is compressed as it's written, for
+    // transport: gzip or zstd, at CompressLevel if that's not 0, giving
+    // name.vp.gz or name.vp.zst.
+    // The engine can't load a compressed VP; see compress.go.
+    Compress string
+    CompressLevel int
+    // IndexFile writes a copy of each VP's header and index next to it,
+    // named like foo.vp.idx, as indexBytes gives them.
+    IndexFile bool
+    // Checksum, if set, is the algorithm to write a checksum sidecar
+    // next to each VP with: sha256, sha1 or blake2b.
+    Checksum string
+    // Built is the build time the embedded manifest gives; now if zero,
+    // or SourceDate with Reproducible.
+    Built time.Time
+    // Reproducible makes the output depend only on the input's paths and
+    // contents: every file's timestamp is SourceDate (the epoch if zero)
+    // rather than its modification time, and Order can't be "readdir".
+    Reproducible bool
+    SourceDate time.Time
+    // BuildEpoch, if not zero, is every file's timestamp, and the build
+    // time if Built is zero, so all of a build shares one time. It can't
+    // be used with Reproducible, which sets the timestamps its own way.
+    BuildEpoch time.Time
+    // OutputMTime, if not zero, is the modification time given to each VP
+    // written, and the files written alongside it, like checksums. With
+    // OutputMTimeFromSources, it's the newest timestamp in each VP's
+    // index instead.
+    OutputMTime time.Time
+    OutputMTimeFromSources bool
+
+    // DryRun goes through everything up to writing the VPs, checks
+    // included, and logs what it would write instead.
+    DryRun bool
+    // EngineLimits, if set, are checked against every VP planned, all
+    // that go over being reported; Pack then fails, once it's done.
+    EngineLimits *EngineLimits
+    // Estimate prints each VP's size on stdout instead of writing it.
+    Estimate bool
+    // TOCJSON prints each VP's index as JSON on stdout instead of writing
+    // it, with the offsets it would have.
+    TOCJSON bool
+    // ExplainSplit prints which VP each source file goes into on stdout.
+    ExplainSplit bool
+    // Summary lists every VP written on stdout once they all are, and
+    // everything skipped, with why; SummaryJSON does it as JSON.
+    Summary bool
+    SummaryJSON bool
+    // ManifestOut, if set, is a file to write a JSON record of the run to
+    // once everything's written: every VP, the input it came from, and
+    // which file each of its entries was packed from and where its data
+    // is, with everything skipped and why. See writeBuildManifest.
+    ManifestOut string
+    // Breakdown adds up the file data written by extension, and prints
+    // the totals on stdout, largest first, once everything's written;
+    // BreakdownJSON does it as JSON.
+    Breakdown bool
+    BreakdownJSON bool
+    // AppendLog, if set, is a file to add a line to for each VP written.
+    AppendLog string
+    // Progress reports how far along each VP is on stderr.
+    Progress bool
+}
+
+// withDefaults fills in the defaults that a zero field stands for.
+func (o Options) withDefaults() Options {
+    if o.OutputDir == "" {
+        o.OutputDir = "tmp"
+    }
+    if o.SpecialFiles == "" {
+        o.SpecialFiles = "skip"
+    }
+    if o.Group == "" {
+        o.Group = "mixed"
+    }
+    if o.Order == "" {
+        o.Order = "sorted"
+    }
+    if o.BudgetOrder == "" {
+        o.BudgetOrder = "largest"
+    }
+    if o.MaxVPSize == 0 {
+        o.MaxVPSize = 1000000000
+    }
+    if o.Layout == "" {
+        o.Layout = "index"
+    }
+    if o.OnExists == "" {
+        o.OnExists = "fail"
+    }
+    if len(o.EOLExtensions) == 0 {
+        o.EOLExtensions = DefaultEOLExtensions
+    }
+    if o.EmbedManifestPath == "" {
+        o.EmbedManifestPath = "data/aztech-manifest.txt"
+    }
+    if o.SourceDate.IsZero() {
+        o.SourceDate = time.Unix(0, 0)
+    }
+    if o.Built.IsZero() && o.Reproducible {
+        o.Built = o.SourceDate
+    }
+    if o.Built.IsZero() && !o.BuildEpoch.IsZero() {
+        o.Built = o.BuildEpoch
+    }
+    if o.Built.IsZero() {
+        o.Built = time.Now()
+    }
+    return o
+}
+
+// check returns an error for options that don't make sense, once the
+// defaults are filled in.
+func (o Options) check() error {
+    if !ValidGroup(o.Group) {
+        return fmt.Errorf("unknown group %q, want dirs-first, files-first or mixed", o.Group)
+    }
+    if o.Order != "sorted" && o.Order != "readdir" {
+        return fmt.Errorf("unknown order %q, want sorted or readdir", o.Order)
+    }
+    if o.Layout != "index" && o.Layout != "small-first" {
+        return fmt.Errorf("unknown layout %q, want index or small-first", o.Layout)
+    }
+    if o.OnExists != "fail" && o.OnExists != "overwrite" && o.OnExists != "rename" && o.OnExists != "skip" {
+        return fmt.Errorf("unknown on exists policy %q, want fail, overwrite, rename or skip", o.OnExists)
+    }
+    if o.BudgetOrder != "largest" && o.BudgetOrder != "smallest" && o.BudgetOrder != "index" {
+        return fmt.Errorf("unknown budget order %q, want largest, smallest or index", o.BudgetOrder)
+    }
+    if o.Budget < 0 {
+        return fmt.Errorf("budget %d is negative", o.Budget)
+    }
+    if o.Budget > 0 && (o.TargetSize > 0 || o.MaxEntries > 0) {
+        return fmt.Errorf("a budget packs what fits into one VP, so can't be used with a target size or entry limit, which split")
+    }
+    if o.NormalizeEOL != "" && o.NormalizeEOL != "lf" && o.NormalizeEOL != "crlf" {
+        return fmt.Errorf("unknown line ending %q, want lf or crlf", o.NormalizeEOL)
+    }
+    if o.SpecialFiles != "skip" && o.SpecialFiles != "error" {
+        return fmt.Errorf("unknown special files setting %q, want skip or error", o.SpecialFiles)
+    }
+    if o.NoDataCheck && (len(o.OnlyDirs) > 0 || len(o.SkipDirs) > 0) {
+        return fmt.Errorf("only and skip directories pick directories in data, so can't be used when there's no data check")
+    }
+    for _, pattern := range append(append(append([]string{}, o.Include...), o.Exclude...), o.BudgetPriority...) {
+        if err := checkPattern(pattern); err != nil {
+            return err
+        }
+    }
+    if o.Reproducible && o.Order == "readdir" {
+        return fmt.Errorf("readdir order depends on the filesystem, so can't be reproducible")
+    }
+    if t := o.SourceDate.Unix(); t < math.MinInt32 || t > math.MaxInt32 {
+        return fmt.Errorf("source date %v doesn't fit a VP timestamp", o.SourceDate.UTC())
+    }
+    if !o.BuildEpoch.IsZero() {
+        if o.Reproducible {
+            return fmt.Errorf("a build epoch and reproducible both set the timestamps, pass only one")
+        }
+        if t := o.BuildEpoch.Unix(); t < math.MinInt32 || t > math.MaxInt32 {
+            return fmt.Errorf("build epoch %v doesn't fit a VP timestamp", o.BuildEpoch.UTC())
+        }
+    }
+    if o.EntryFilter != "" {
+        if _, err := parseEntryFilter(o.EntryFilter); err != nil {
+            return err
+        }
+    }
+    if o.ExcludeSmallerThan < 0 || o.ExcludeLargerThan < 0 {
+        return fmt.Errorf("size limits can't be negative")
+    }
+    if !o.OutputMTime.IsZero() && o.OutputMTimeFromSources {
+        return fmt.Errorf("an output mtime can't be both fixed and from the sources")
+    }
+    if o.WriteRetries < 0 {
+        return fmt.Errorf("write retries %d is negative", o.WriteRetries)
+    }
+    if err := checkCompression(o.Compress, o.CompressLevel); err != nil {
+        return err
+    }
+    if o.Compress != "" && o.EmbedHash {
+        return fmt.Errorf("a hash trailer goes on the end of a VP, so can't be embedded in a compressed one")
+    }
+    if o.Checksum != "" && checksumAlgoNamed(o.Checksum) == nil {
+        return fmt.Errorf("unknown checksum algorithm %q, want sha256, sha1 or blake2b", o.Checksum)
+    }
+    if o.EmbedManifest {
+        p := path.Clean(o.EmbedManifestPath)
+        if path.IsAbs(p) || p == "." || p == ".." || strings.HasPrefix(p, "../") {
+            return fmt.Errorf("embedded manifest path %v isn't a path inside the VP", o.EmbedManifestPath)
+        }
+    }
+    return nil
+}
+
+// Pack packs each of inputs into VPs in opts.OutputDir: one for each
+// directory in its data directory (split further if it's too big) and
+// data.vp for any files directly in data, or one for the whole input with
+// NoDataCheck. It stops at the first error, and as soon as it can once
+// ctx is cancelled, leaving behind the VPs already finished but never a
+// partly written one.
+func Pack(ctx context.Context, inputs []string, opts Options) error {
+    p, err := newPacker(opts)
+    if err != nil {
+        return err
+    }
+    opts = p.opts
+    outputDir := opts.OutputDir
+    for _, inputDir := range inputs {
+        // so ./data/.., foo/ and the like are named and reported as the
+        // directory they are
+        inputDir = path.Clean(inputDir)
+        walkOpts := p.walkOptions()
+        var root InputFileOrDir
+        var src fileSource
+        var err error
+        lazy := false
+        if archiveKind(inputDir) != "" || opts.FileLists {
+            if opts.FileLists {
+                root, src, err = walkManifest(inputDir, walkOpts)
+            } else {
+                root, src, err = walkArchive(inputDir, walkOpts)
+            }
+            if err != nil {
+                return err
+            }
+            if err := p.checkData(inputDir, root); err != nil {
+                src.Close()
+                return err
+            }
+        } else {
+            if !opts.NoDataCheck {
+                dataDir, err := os.Stat(path.Join(inputDir, "data"))
+                if err != nil {
+                    return err
+                }
+                if !dataDir.Mode().IsDir() {
+                    return fmt.Errorf("%v is not a directory", path.Join(inputDir, "data"))
+                }
+            }
+
+            if opts.FollowSymlinks {
+                if walkOpts.links, err = newLinkTracker(inputDir, walkOpts.skips); err != nil {
+                    return err
+                }
+            }
+
+            exclude, inside, err := outputInsideInput(inputDir, outputDir)
+            if err != nil {
+                return err
+            }
+            if inside {
+                if exclude == path.Clean(inputDir) {
+                    return fmt.Errorf("output directory %v is the input directory %v", outputDir, inputDir)
+                }
+                if err := complain(outputDir, "excluding it from the walk", "output directory %v is inside %v", outputDir, inputDir); err != nil {
+                    return err
+                }
+                walkOpts.exclude = exclude
+            }
+
+            // only go as far as the directories in data for now; each is
+            // walked as it's packed, so just one of them is held at a time
+            stubOpts := walkOpts
+            if !opts.NoDataCheck {
+                stubOpts.stubDepth = 2
+                lazy = true
+            }
+            root, err = walkDir(inputDir, stubOpts)
+            if err != nil {
+                return err
+            }
+            src = dirSource{}
+        }
+        written, err := p.packInput(ctx, inputDir, root, src, lazy, walkOpts)
+        src.Close()
+        if err != nil {
+            return err
+        }
+        if len(inputs) > 1 {
+            LogEntry("info", inputDir, -1, fmt.Sprintf("%v: wrote %d VPs", inputDir, written))
+        }
+    }
+    return p.summarise()
+}
+
+// PackFS is Pack for a single input read from fsys, such as an embed.FS,
+// a zip.Reader or os.DirFS, walked with fs.WalkDir. name is what the input
+// is called in messages, and with NoDataCheck, what its VP is named after.
+func PackFS(ctx context.Context, fsys fs.FS, name string, opts Options) error {
+    p, err := newPacker(opts)
+    if err != nil {
+        return err
+    }
+    walkOpts := p.walkOptions()
+    root, src, err := walkFS(fsys, walkOpts)
+    if err != nil {
+        return err
+    }
+    defer src.Close()
+    if err := p.checkData(name, root); err != nil {
+        return err
+    }
+    if _, err := p.packInput(ctx, name, root, src, false, walkOpts); err != nil {
+        return err
+    }
+    return p.summarise()
+}
+
+// newPacker fills in opts' defaults and checks them, then cleans the
+// output directory if asked to, ready to pack.
+func newPacker(opts Options) (*packer, error) {
+    opts = opts.withDefaults()
+    if err := opts.check(); err != nil {
+        return nil, err
+    }
+    if opts.Clean {
+        if err := cleanOutput(opts.OutputDir); err != nil {
+            return nil, err
+        }
+    }
+    p := &packer{
+        opts: opts,
+        only: map[string]bool{},
+        skip: map[string]bool{},
+        produced: map[string]string{},
+        breakdown: map[string]*extTotal{},
+        skipped: skipLog{},
+    }
+    if opts.EntryFilter != "" {
+        // check has made sure it parses
+        p.entryFilter, _ = parseEntryFilter(opts.EntryFilter)
+    }
+    for _, name := range opts.OnlyDirs {
+        p.only[name] = true
+    }
+    for _, name := range opts.SkipDirs {
+        p.skip[name] = true
+    }
+    return p, nil
+}
+
+// tocOptions is how the TOCs of the VPs are made.
+func (o Options) tocOptions() tocOptions {
+    tocOpts := tocOptions{
+        group: o.Group,
+        rawOrder: o.Order == "readdir",
+        lowerExt: o.LowerExt,
+        rootName: o.RootName,
+        prefix: o.Prefix,
+        trimPrefix: o.TrimPrefix,
+        storeFullPath: o.StoreFullPath,
+    }
+    if o.Reproducible {
+        tocOpts.stamp = &o.SourceDate
+    }
+    if !o.BuildEpoch.IsZero() {
+        tocOpts.stamp = &o.BuildEpoch
+    }
+    return tocOpts
+}
+
+// walkOptions is how the packer's inputs are walked.
+func (p *packer) walkOptions() walkOptions {
+    return walkOptions{
+        keepGoing: p.opts.KeepGoing,
+        specialFiles: p.opts.SpecialFiles,
+        maxDepth: p.opts.MaxDepth,
+        rawOrder: p.opts.Order == "readdir",
+        filter: pathFilter{p.opts.Include, p.opts.Exclude},
+        nestedVPs: !p.opts.AllowNestedVP,
+        skips: p.skipped,
+    }
+}
+
+// checkData fails if the input called name, walked as far as root, has
+// no data directory, unless that's not needed.
+func (p *packer) checkData(name string, root InputFileOrDir) error {
+    if p.opts.NoDataCheck {
+        return nil
+    }
+    for _, child := range root.children {
+        if child.isDir && path.Base(child.originalPath) == "data" {
+            return nil
+        }
+    }
+    return fmt.Errorf("%v has no data directory", name)
+}
+
+// summarise reports what was excluded for its size, by the entry filter
+// or for the budget, lists what was written and skipped if the summary's
+// wanted, writes the build manifest if that is, and the breakdown by
+// extension if that is. Last, it fails if any VPs weren't written as they
+// existed already, or if anything went over EngineLimits.
+func (p *packer) summarise() error {
+    if p.sizeExcluded > 0 {
+        LogEntry("info", "", -1, fmt.Sprintf("left out %d files of %d bytes for their size", p.sizeExcluded, p.sizeExcludedBytes))
+    }
+    if p.filtered > 0 {
+        LogEntry("info", "", -1, fmt.Sprintf("left out %d files of %d bytes by the entry filter", p.filtered, p.filteredBytes))
+    }
+    if p.overBudget > 0 {
+        LogEntry("info", "", -1, fmt.Sprintf("left out %d files of %d bytes that didn't fit the budget", p.overBudget, p.overBudgetBytes))
+    }
+    if p.opts.Summary {
+        printSummary(p.wrote, p.sizeExcluded, p.sizeExcludedBytes, p.skipped)
+    }
+    if p.opts.SummaryJSON {
+        if err := printSummaryJSON(p.wrote, p.skipped); err != nil {
+            return err
+        }
+    }
+    if p.opts.ManifestOut != "" {
+        if err := writeBuildManifest(p.opts.ManifestOut, p.wrote, p.skipped); err != nil {
+            return err
+        }
+    }
+    if p.opts.Breakdown || p.opts.BreakdownJSON {
+        if err := printBreakdown(p.breakdown, p.opts.BreakdownJSON); err != nil {
+            return err
+        }
+    }
+    if len(p.existing) > 0 {
+        return fmt.Errorf("%w, so wasn't written: %v", ErrArchiveExists, strings.Join(p.existing, ", "))
+    }
+    if limits := p.opts.EngineLimits; limits != nil {
+        if limits.MaxVPs > 0 && p.planned > limits.MaxVPs {
+            msg := fmt.Sprintf("%d VPs planned, more than the %d the engine loads", p.planned, limits.MaxVPs)
+            LogEntry("error", "", -1, msg)
+            p.engineProblems = append(p.engineProblems, msg)
+        }
+        if len(p.engineProblems) > 0 {
+            return fmt.Errorf("%d problems with the engine's limits", len(p.engineProblems))
+        }
+    }
+    return nil
+}
+
+// packer is what Pack keeps track of from one input to the next.
+type packer struct {
+    opts Options
+    // the directories in data to pack or leave out
+    only map[string]bool
+    skip map[string]bool
+    // which input each VP written came from, to catch two roots with a
+    // directory of the same name
+    produced map[string]string
+    // everything written, for the summary, and its files by extension,
+    // for the breakdown
+    wrote []writtenVP
+    breakdown map[string]*extTotal
+    // everything left out, and why, for the summary and build manifest
+    skipped skipLog
+    // the VPs not written under KeepGoing because they existed already
+    existing []string
+    // how many VPs were planned, and what went over EngineLimits
+    planned int
+    engineProblems []string
+    // files left out for their size, and how many bytes they came to
+    sizeExcluded int
+    sizeExcludedBytes int64
+    // files left out to fit the budget, and how many bytes they came to
+    overBudget int
+    overBudgetBytes int64
+    // Options.EntryFilter, compiled, and the files it left out, and how
+    // many bytes they came to
+    entryFilter *entryFilter
+    filtered int
+    filteredBytes int64
+}
+
+// packInput writes the VPs for one of Pack's inputs, once it's walked as
+// far as root, and says how many it wrote. If lazy, the directories in
+// data have still to be walked, with walkOpts.
+func (p *packer) packInput(ctx context.Context, inputDir string, root InputFileOrDir, src fileSource, lazy bool, walkOpts walkOptions) (int, error) {
+    opts := p.opts
+    only, skip, produced := p.only, p.skip, p.produced
+    outputDir := opts.OutputDir
+    embedPath := path.Clean(opts.EmbedManifestPath)
+    for _, child := range root.children {
+        if path.Base(child.originalPath) == "data" {
+            for _, list := range []map[string]bool{only, skip} {
+                for name := range list {
+                    if !hasSubdir(child, name) {
+                        return 0, fmt.Errorf("there's no %v directory in %v", name, child.originalPath)
+                    }
+                }
+            }
+        }
+    }
+
+    written := 0
+    // we break up one toc per folder in data, for now, and the files
+    // directly in data go together into data.vp; with NoDataCheck the
+    // whole input goes into one
+    units := []InputFileOrDir{}
+    // which unit is data's own files, if any: data itself, with only
+    // them as its children
+    looseUnit := -1
+    if opts.NoDataCheck {
+        units = append(units, root)
+    } else {
+        for _, child := range root.children {
+            if path.Base(child.originalPath) != "data" {
+                continue
+            }
+            loose := child
+            loose.children = []InputFileOrDir{}
+            for _, c := range child.children {
+                if c.isDir {
+                    units = append(units, c)
+                } else {
+                    loose.children = append(loose.children, c)
+                }
+            }
+            // whatever order the walk found them in, go through them by
+            // name, so the logs and any clash come out the same every run
+            sort.SliceStable(units, func(a, b int) bool {
+                return path.Base(units[a].originalPath) < path.Base(units[b].originalPath)
+            })
+            if len(loose.children) == 0 {
+                continue
+            }
+            for _, u := range units {
+                if strings.EqualFold(path.Base(u.originalPath), "data") {
+                    return 0, fmt.Errorf("the files directly in %v and the directory %v would both be packed into data.vp", child.originalPath, u.originalPath)
+                }
+            }
+            looseUnit = len(units)
+            units = append(units, loose)
+        }
+    }
+    var err error
+    for i, dataChild := range units {
+        if ctx.Err() != nil {
+            return 0, fmt.Errorf("%v: interrupted", inputDir)
+        }
+        // data's own files are named for it, so are left out by OnlyDirs
+        // as a directory that isn't listed would be
+        name := path.Base(dataChild.originalPath)
+        if opts.NoDataCheck {
+            if name = inputName(inputDir); name == "" {
+                return 0, fmt.Errorf("%v has no name to give its VP", inputDir)
+            }
+        } else if (len(only) > 0 && !only[name]) || skip[name] {
+            p.skipped.note(dataChild.originalPath, skipExcluded)
+            continue
+        }
+        if lazy && dataChild.isDir && i != looseUnit {
+            dataChild, err = walkDirDepth(dataChild.originalPath, walkOpts, 2)
+            if err != nil {
+                return 0, err
+            }
+        }
+        // before anything's laid out, so they take up no room in the
+        // index, data or splitting
+        var dropped int
+        var droppedBytes int64
+        dataChild, dropped, droppedBytes = dropBySize(dataChild, opts.ExcludeSmallerThan, opts.ExcludeLargerThan, p.skipped)
+        p.sizeExcluded += dropped
+        p.sizeExcludedBytes += droppedBytes
+        if p.entryFilter != nil {
+            rel := func(p string) string {
+                return strings.TrimPrefix(p, inputDir + "/")
+            }
+            dataChild, dropped, droppedBytes = dropByFilter(dataChild, p.entryFilter, rel, p.skipped)
+            p.filtered += dropped
+            p.filteredBytes += droppedBytes
+        }
+        tocOpts := opts.tocOptions()
+        var toc []vp.TOCEntry
+        if opts.NoDataCheck {
+            toc, err = produceContentsTOC(inputDir, dataChild, tocOpts)
+        } else {
+            newChild := InputFileOrDir {
+                originalPath: "data",
+                size: 0,
+                modTime: time.Unix(0, 0),
+                isDir: true,
+                children: []InputFileOrDir{ dataChild },
+            }
+            if i == looseUnit {
+                newChild.children = dataChild.children
+            }
+            toc, err = produceTOC(inputDir, newChild, tocOpts)
+        }
+        if err != nil {
+            return 0, err
+        }
+        var eolFiles map[string]bool
+        if opts.NormalizeEOL != "" {
+            toc, eolFiles, err = normalizeEOLSizes(toc, src, opts.NormalizeEOL == "crlf", opts.EOLExtensions)
+            if err != nil {
+                return 0, err
+            }
+        }
+        if opts.Budget > 0 {
+            var left []vp.TOCEntry
+            toc, left, err = fitBudget(toc, opts.Budget, opts.BudgetOrder, opts.BudgetPriority)
+            if err != nil {
+                return 0, fmt.Errorf("%v: %w", dataChild.originalPath, err)
+            }
+            for _, entry := range left {
+                p.skipped.note(entry.Path, skipOverBudget)
+                p.overBudget++
+                p.overBudgetBytes += int64(entry.Size)
+            }
+        }
+        splitOpts := splitOptions{
+            maxSize: int32(opts.MaxVPSize),
+            maxEntries: opts.MaxEntries,
+            targetSize: opts.TargetSize,
+        }
+        split, err := splitTOCs(toc, splitOpts)
+        if err != nil {
+            return 0, err
+        }
+        if len(split) > 1 && opts.Budget > 0 {
+            return 0, fmt.Errorf("what fits the budget from %v would still be split into %d VPs: %v", dataChild.originalPath, len(split), overLimits(toc, opts))
+        }
+        if len(split) > 1 && opts.NoSplit {
+            return 0, fmt.Errorf("%v would be split into %d VPs, which isn't allowed: %v", dataChild.originalPath, len(split), overLimits(toc, opts))
+        }
+        if err := checkSplitPaths(split); err != nil {
+            return 0, err
+        }
+        parts := nameParts(name, split)
+        // each part's checksum line, for the set's, if it's split, and the
+        // newest of their modification times, if they're set
+        setSums := []string{}
+        var setMTime time.Time
+        for _, part := range parts {
+            filename := part.filename
+            subtoc := part.toc
+            vpPath := path.Join(outputDir, filename) + compressExt(opts.Compress)
+            if other, ok := produced[vpPath]; ok && other != inputDir {
+                return 0, fmt.Errorf("%v and %v would both be written to %v", other, inputDir, vpPath)
+            }
+            produced[vpPath] = inputDir
+            if opts.ExplainSplit {
+                for _, source := range part.sources {
+                    fmt.Printf("%s\t%s\n", vpPath, source)
+                }
+            }
+            if opts.ExplainSplit || opts.Estimate {
+                for _, msg := range dominantFiles(part.toc, splitOpts) {
+                    warnf(vpPath, "%v: %v", vpPath, msg)
+                }
+            }
+            vpSrc := src
+            if opts.EmbedManifest {
+                subtoc, vpSrc, err = embedManifest(subtoc, src, filename, embedPath, opts.Built)
+                if err != nil {
+                    return 0, err
+                }
+            }
+            if err := checkChunkPaths(subtoc); err != nil {
+                return 0, fmt.Errorf("%v: %w", vpPath, err)
+            }
+            p.planned++
+            if opts.EngineLimits != nil {
+                problems, err := engineViolations(vpPath, subtoc, *opts.EngineLimits)
+                if err != nil {
+                    return 0, err
+                }
+                for _, msg := range problems {
+                    LogEntry("error", vpPath, -1, msg)
+                }
+                p.engineProblems = append(p.engineProblems, problems...)
+            }
+            if opts.TOCJSON {
+                offsets := dataOffsets(subtoc, opts.Layout == "small-first")
+                for i := range subtoc {
+                    subtoc[i].Offset = offsets[i]
+                }
+                if err := PrintTOCJSON(vpPath, subtoc); err != nil {
+                    return 0, err
+                }
+                continue
+            }
+            if opts.Estimate {
+                size := vpSize(subtoc)
+                if opts.EmbedHash {
+                    size += hashTrailerSize
+                }
+                fmt.Printf("%s\t%d\n", vpPath, size)
+                continue
+            }
+            if _, err := os.Stat(vpPath); !os.IsNotExist(err) {
+                switch opts.OnExists {
+                case "fail":
+                    if !opts.KeepGoing {
+                        return 0, fmt.Errorf("%w: %v", ErrArchiveExists, vpPath)
+                    }
+                    LogEntry("error", vpPath, -1, fmt.Sprintf("%v already exists, skipping it", vpPath))
+                    p.skipped.note(vpPath, skipExists)
+                    p.existing = append(p.existing, vpPath)
+                    continue
+                case "skip":
+                    warnf(vpPath, "%v already exists, leaving it as it is", vpPath)
+                    p.skipped.note(vpPath, skipExists)
+                    continue
+                case "overwrite":
+                    if !opts.DryRun {
+                        LogEntry("info", vpPath, -1, fmt.Sprintf("overwriting %v", vpPath))
+                    }
+                case "rename":
+                    renamed, err := freePath(vpPath, ".vp" + compressExt(opts.Compress))
+                    if err != nil {
+                        return 0, err
+                    }
+                    LogEntry("info", vpPath, -1, fmt.Sprintf("%v already exists, writing %v instead", vpPath, renamed))
+                    vpPath = renamed
+                }
+            }
+            if opts.DryRun {
+                LogEntry("info", vpPath, -1, fmt.Sprintf("would write %v: %d entries, %d bytes", vpPath, len(subtoc), vpSize(subtoc)))
+                continue
+            }
+            // written beside vpPath and renamed over it once it's whole,
+            // so a VP already there is only replaced by a good one
+            f, err := ioutil.TempFile(path.Dir(vpPath), "." + path.Base(vpPath) + ".*")
+            if err != nil {
+                return 0, err
+            }
+            tmpPath := f.Name()
+            var hook func(written, total int64)
+            if opts.Progress {
+                hook = ProgressPrinter(vpPath)
+            }
+            // the checksum is worked out as the VP is written, unless it's
+            // going to be changed afterwards or parts may be written over
+            // on a retry, when it's read back instead
+            var out io.Writer = f
+            var h hash.Hash
+            algo := checksumAlgoNamed(opts.Checksum)
+            if algo != nil && !opts.TwoPass && !opts.EmbedHash && opts.WriteRetries == 0 {
+                h = algo.new()
+                out = io.MultiWriter(f, h)
+            }
+            var zw io.WriteCloser
+            if opts.Compress != "" {
+                if zw, err = compressWriter(out, opts.Compress, opts.CompressLevel); err != nil {
+                    f.Close()
+                    os.Remove(tmpPath)
+                    return 0, err
+                }
+                out = zw
+            }
+            transform := opts.Transform
+            if len(eolFiles) > 0 {
+                transform = chainTransforms(eolTransform(subtoc, eolFiles, opts.NormalizeEOL == "crlf"), opts.Transform)
+            }
+            err = printVP(ctx, dataChild, subtoc, vpSrc, out, printOptions{
+                twoPass: opts.TwoPass,
+                progress: hook,
+                namePad: opts.NamePad,
+                skipMissing: opts.SkipMissing,
+                smallFirst: opts.Layout == "small-first",
+                retries: opts.WriteRetries,
+                transform: transform,
+                skips: p.skipped,
+            })
+            if zw != nil {
+                if closeErr := zw.Close(); err == nil {
+                    err = closeErr
+                }
+            }
+            if err == nil {
+                err = f.Chmod(0644)
+            }
+            if closeErr := f.Close(); err == nil {
+                err = closeErr
+            }
+            if err == nil && opts.EmbedHash {
+                err = appendHashTrailer(tmpPath)
+            }
+            var index []byte
+            if err == nil && opts.IndexFile {
+                index, err = IndexFile(tmpPath)
+            }
+            var sum []byte
+            if err == nil && algo != nil {
+                if h != nil {
+                    sum = h.Sum(nil)
+                } else {
+                    sum, err = fileChecksum(tmpPath, algo)
+                }
+            }
+            mtime, setTime := p.outputMTime(subtoc)
+            if err == nil && setTime {
+                err = os.Chtimes(tmpPath, mtime, mtime)
+            }
+            if err == nil {
+                err = os.Rename(tmpPath, vpPath)
+            }
+            if err != nil {
+                // it's only partly written, so mustn't be left to ship;
+                // whatever was at vpPath is left as it was
+                os.Remove(tmpPath)
+                if ctx.Err() != nil {
+                    return 0, fmt.Errorf("interrupted, removed partial %v", vpPath)
+                }
+                return 0, err
+            }
+            // the VP is whole now; what's written alongside it is removed
+            // if it can't be finished, rather than being left stale
+            outputs := []string{}
+            if opts.IndexFile {
+                err = ioutil.WriteFile(vpPath + ".idx", index, 0644)
+                outputs = append(outputs, vpPath + ".idx")
+            }
+            if err == nil && algo != nil {
+                err = writeChecksum(vpPath, algo, sum)
+                setSums = append(setSums, checksumLine(vpPath, sum))
+                outputs = append(outputs, vpPath + algo.ext)
+            }
+            if err == nil && setTime {
+                for _, o := range outputs {
+                    if err = os.Chtimes(o, mtime, mtime); err != nil {
+                        break
+                    }
+                }
+                if mtime.After(setMTime) {
+                    setMTime = mtime
+                }
+            }
+            if err != nil {
+                for _, o := range outputs {
+                    os.Remove(o)
+                }
+                return 0, err
+            }
+            if opts.AppendLog != "" || opts.Summary || opts.SummaryJSON || opts.ManifestOut != "" {
+                info, err := os.Stat(vpPath)
+                if err != nil {
+                    return 0, err
+                }
+                if opts.AppendLog != "" {
+                    if err := appendLog(opts.AppendLog, vpPath, info.Size(), len(subtoc)); err != nil {
+                        return 0, err
+                    }
+                }
+                w := writtenVP{path: vpPath, size: info.Size(), entries: len(subtoc), input: inputDir}
+                if opts.ManifestOut != "" {
+                    if w.mapped, err = mapEntries(vpPath, subtoc, vpSrc); err != nil {
+                        return 0, err
+                    }
+                }
+                p.wrote = append(p.wrote, w)
+            }
+            addBreakdown(p.breakdown, subtoc)
+            written++
+        }
+        if algo := checksumAlgoNamed(opts.Checksum); algo != nil && len(parts) > 1 && len(setSums) == len(parts) {
+            setPath := path.Join(outputDir, name + setChecksumExt + algo.ext)
+            if opts.OnExists == "rename" {
+                if setPath, err = freePath(setPath, setChecksumExt + algo.ext); err != nil {
+                    return 0, err
+                }
+            }
+            if err := ioutil.WriteFile(setPath, []byte(strings.Join(setSums, "")), 0644); err != nil {
+                return 0, err
+            }
+            if _, ok := p.outputMTime(nil); ok {
+                if err := os.Chtimes(setPath, setMTime, setMTime); err != nil {
+                    return 0, err
+                }
+            }
+        }
+    }
+    return written, nil
+}
+
+// outputMTime is the modification time to give the VP of toc and what's
+// written with it, if there's one to set.
+func (p *packer) outputMTime(toc []vp.TOCEntry) (time.Time, bool) {
+    if !p.opts.OutputMTime.IsZero() {
+        return p.opts.OutputMTime, true
+    }
+    if !p.opts.OutputMTimeFromSources {
+        return time.Time{}, false
+    }
+    var newest int32 = 0
+    for _, entry := range toc {
+        if !entry.IsDir && entry.Timestamp > newest {
+            newest = entry.Timestamp
+        }
+    }
+    return time.Unix(int64(newest), 0), true
+}
+
+// freePath is the first of p with .1, .2 and so on put in before ext, which
+// it ends with, that nothing's at yet: maps.1.vp for maps.vp.
+func freePath(p string, ext string) (string, error) {
+    for n := 1; ; n++ {
+        candidate := fmt.Sprintf("%s.%d%s", strings.TrimSuffix(p, ext), n, ext)
+        if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+            return candidate, nil
+        } else if err != nil {
+            return "", err
+        }
+    }
+}
+
+// overLimits says which of the split limits in opts toc goes over, and by
+// how much.
+func overLimits(toc []vp.TOCEntry, opts Options) string {
+    var data int64 = 0
+    for _, entry := range toc {
+        data += int64(entry.Size)
+    }
+    over := []string{}
+    if data > int64(opts.MaxVPSize) {
+        over = append(over, fmt.Sprintf("its %d bytes of file data are %d over the limit of %d", data, data - int64(opts.MaxVPSize), opts.MaxVPSize))
+    }
+    if opts.MaxEntries > 0 && len(toc) > opts.MaxEntries {
+        over = append(over, fmt.Sprintf("its %d index entries are %d over the limit of %d", len(toc), len(toc) - opts.MaxEntries, opts.MaxEntries))
+    }
+    if size := vpSize(toc); opts.TargetSize > 0 && size > opts.TargetSize {
+        over = append(over, fmt.Sprintf("at %d bytes it's %d over the target size of %d", size, size - opts.TargetSize, opts.TargetSize))
+    }
+    if len(over) == 0 {
+        return "no single file fits the limits"
+    }
+    return strings.Join(over, ", and ")
+}
@@ -0,0 +1,462 @@
+package aztech
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "math/rand"
+    "os"
+    "path"
+    "reflect"
+    "runtime"
+    "runtime/debug"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/tcrayford/aztech/vp"
+)
+
+// makeTree writes dirs directories under in/data, each holding files one
+// byte files, and returns in.
+func makeTree(t testing.TB, dirs int, files int) string {
+    in := path.Join(t.TempDir(), "in")
+    for d := 0; d < dirs; d++ {
+        dir := path.Join(in, "data", fmt.Sprintf("dir%02d", d))
+        if err := os.MkdirAll(dir, 0755); err != nil {
+            t.Fatal(err)
+        }
+        for f := 0; f < files; f++ {
+            name := path.Join(dir, fmt.Sprintf("a-fairly-long-name-%06d.tbl", f))
+            if err := os.WriteFile(name, []byte("x"), 0644); err != nil {
+                t.Fatal(err)
+            }
+        }
+    }
+    return in
+}
+
+// writeFiles writes each of files, by path under dir.
+func writeFiles(t testing.TB, dir string, files map[string]string) {
+    for p, content := range files {
+        if err := os.MkdirAll(path.Dir(path.Join(dir, p)), 0755); err != nil {
+            t.Fatal(err)
+        }
+        if err := os.WriteFile(path.Join(dir, p), []byte(content), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+}
+
+// liveHeap is the heap in use once everything unreachable is collected.
+func liveHeap() uint64 {
+    runtime.GC()
+    var m runtime.MemStats
+    runtime.ReadMemStats(&m)
+    return m.HeapAlloc
+}
+
+// peakHeap runs f, sampling the heap while it does, and returns the most
+// it saw in use.
+func peakHeap(f func()) uint64 {
+    var peak uint64
+    done := make(chan bool)
+    var wg sync.WaitGroup
+    wg.Add(1)
+    go func() {
+        defer wg.Done()
+        var m runtime.MemStats
+        for {
+            runtime.ReadMemStats(&m)
+            if m.HeapAlloc > peak {
+                peak = m.HeapAlloc
+            }
+            select {
+            case <-done:
+                return
+            case <-time.After(100 * time.Microsecond):
+            }
+        }
+    }()
+    f()
+    close(done)
+    wg.Wait()
+    return peak
+}
+
+func TestPackHoldsOneDataDirectoryAtATime(t *testing.T) {
+    if testing.Short() {
+        t.Skip("writes a large tree")
+    }
+    const dirs = 8
+    in := makeTree(t, dirs, 3000)
+    // collect almost as soon as anything's garbage, so the samples are
+    // close to what's really held
+    defer debug.SetGCPercent(debug.SetGCPercent(1))
+
+    before := liveHeap()
+    tree, err := walkDir(in, walkOptions{specialFiles: "skip"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    whole := liveHeap() - before
+    runtime.KeepAlive(tree)
+    tree = InputFileOrDir{}
+
+    out := t.TempDir()
+    before = liveHeap()
+    var packErr error
+    peak := peakHeap(func() {
+        packErr = Pack(context.Background(), []string{in}, Options{OutputDir: out})
+    })
+    if packErr != nil {
+        t.Fatal(packErr)
+    }
+    // one directory's tree and TOC is about two eighths of the whole
+    // tree; holding them all at once would be well over half
+    if used := peak - before; used > whole / 2 {
+        t.Errorf("packing took up to %d bytes of heap; the whole tree is %d, so more than one directory was held at once", used, whole)
+    }
+}
+
+// vpPaths is the path of every entry in the VP at p.
+func vpPaths(t *testing.T, p string) []string {
+    b, err := os.ReadFile(p)
+    if err != nil {
+        t.Fatal(err)
+    }
+    toc, err := vp.ReadTOC(bytes.NewReader(b), int64(len(b)))
+    if err != nil {
+        t.Fatalf("%v: %v", p, err)
+    }
+    paths := []string{}
+    for _, entry := range toc {
+        paths = append(paths, entry.Path)
+    }
+    return paths
+}
+
+func TestPackFilesDirectlyInData(t *testing.T) {
+    in := path.Join(t.TempDir(), "in")
+    writeFiles(t, in, map[string]string{"data/somefile.tbl": "loose", "data/subdir/x.tbl": "x"})
+    out := t.TempDir()
+    if err := Pack(context.Background(), []string{in}, Options{OutputDir: out}); err != nil {
+        t.Fatal(err)
+    }
+    names := []string{}
+    files, err := os.ReadDir(out)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, f := range files {
+        names = append(names, f.Name())
+    }
+    if want := []string{"data.vp", "subdir.vp"}; !reflect.DeepEqual(names, want) {
+        t.Fatalf("packed %q, want %q", names, want)
+    }
+    // the loose file goes in data.vp, and only there
+    if got, want := vpPaths(t, path.Join(out, "data.vp")), []string{"data", "data/somefile.tbl", "."}; !reflect.DeepEqual(got, want) {
+        t.Errorf("data.vp holds %q, want %q", got, want)
+    }
+    if got, want := vpPaths(t, path.Join(out, "subdir.vp")), []string{"data", "data/subdir", "data/subdir/x.tbl", "data", "."}; !reflect.DeepEqual(got, want) {
+        t.Errorf("subdir.vp holds %q, want %q", got, want)
+    }
+}
+
+// shuffled is a copy of tree with the children of every directory in a
+// random order, as a filesystem might list them.
+func shuffled(tree InputFileOrDir, r *rand.Rand) InputFileOrDir {
+    children := make([]InputFileOrDir, len(tree.children))
+    for i, c := range tree.children {
+        children[i] = shuffled(c, r)
+    }
+    r.Shuffle(len(children), func(i, j int) {
+        children[i], children[j] = children[j], children[i]
+    })
+    tree.children = children
+    return tree
+}
+
+// packedFiles is the contents of every file Pack wrote into out, keyed by
+// name.
+func packedFiles(t *testing.T, out string) map[string][]byte {
+    files, err := os.ReadDir(out)
+    if err != nil {
+        t.Fatal(err)
+    }
+    contents := map[string][]byte{}
+    for _, f := range files {
+        b, err := os.ReadFile(path.Join(out, f.Name()))
+        if err != nil {
+            t.Fatal(err)
+        }
+        contents[f.Name()] = b
+    }
+    return contents
+}
+
+// laidOutFiles is every file in the VP at vpPath, by path, failing if
+// their data isn't one after the other from the header on, in index
+// order, as printVP lays it out.
+func laidOutFiles(t *testing.T, vpPath string) map[string]string {
+    f, err := os.Open(vpPath)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+    info, err := f.Stat()
+    if err != nil {
+        t.Fatal(err)
+    }
+    toc, err := vp.ReadTOC(f, info.Size())
+    if err != nil {
+        t.Fatalf("%v: %v", vpPath, err)
+    }
+    files := map[string]string{}
+    offset := int32(vp.HeaderSize)
+    for _, entry := range toc {
+        if entry.IsDir {
+            continue
+        }
+        if entry.Offset != offset {
+            t.Errorf("%v: %v is at %d, want %d", vpPath, entry.Path, entry.Offset, offset)
+        }
+        b, err := io.ReadAll(vp.OpenEntry(f, entry))
+        if err != nil {
+            t.Fatal(err)
+        }
+        files[entry.Path] = string(b)
+        offset += entry.Size
+    }
+    return files
+}
+
+func TestPackShuffledEnumeration(t *testing.T) {
+    in := path.Join(t.TempDir(), "in")
+    files := map[string]string{}
+    for _, p := range []string{"data/zeta.tbl", "data/alpha.tbl", "data/maps/b.pof", "data/maps/a.pof", "data/maps/sub/c.dds", "data/tables/ships.tbl", "data/effects/x.ani"} {
+        files[p] = p
+    }
+    writeFiles(t, in, files)
+
+    pack := func(root InputFileOrDir) map[string][]byte {
+        out := t.TempDir()
+        p, err := newPacker(Options{OutputDir: out, Reproducible: true})
+        if err != nil {
+            t.Fatal(err)
+        }
+        if _, err := p.packInput(context.Background(), in, root, dirSource{}, false, p.walkOptions()); err != nil {
+            t.Fatal(err)
+        }
+        return packedFiles(t, out)
+    }
+    root, err := walkDir(in, walkOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := pack(root)
+    if len(want) != 4 {
+        t.Fatalf("packed %d files, want 4", len(want))
+    }
+    for seed := int64(1); seed <= 10; seed++ {
+        if got := pack(shuffled(root, rand.New(rand.NewSource(seed)))); !reflect.DeepEqual(got, want) {
+            t.Errorf("seed %d: shuffling the walk changed the output", seed)
+        }
+    }
+}
+
+func TestPackInputSpellings(t *testing.T) {
+    base := t.TempDir()
+    in := path.Join(base, "mod")
+    writeFiles(t, in, map[string]string{"data/maps/a.pof": "data/maps/a.pof", "data/tables/ships.tbl": "data/tables/ships.tbl"})
+    pack := func(input string, noDataCheck bool) map[string][]byte {
+        out := t.TempDir()
+        if err := Pack(context.Background(), []string{input}, Options{OutputDir: out, Reproducible: true, NoDataCheck: noDataCheck}); err != nil {
+            t.Fatalf("%q: %v", input, err)
+        }
+        return packedFiles(t, out)
+    }
+
+    t.Chdir(base)
+    want := pack("mod", false)
+    wantWhole := pack("mod", true)
+    if _, ok := wantWhole["mod.vp"]; !ok || len(wantWhole) != 1 {
+        t.Fatalf("--no-data-check packed %d files, want just mod.vp", len(wantWhole))
+    }
+    check := func(input string) {
+        if got := pack(input, false); !reflect.DeepEqual(got, want) {
+            t.Errorf("%q packed differently from mod", input)
+        }
+        // the VP for the whole input is named for the directory, whatever
+        // it was called
+        if got := pack(input, true); !reflect.DeepEqual(got, wantWhole) {
+            t.Errorf("%q packed differently from mod with --no-data-check", input)
+        }
+    }
+    for _, input := range []string{"mod/", "./mod", "./mod/", "mod/data/..", in, in + "/"} {
+        check(input)
+    }
+    t.Chdir(in)
+    for _, input := range []string{".", "./", "data/.."} {
+        check(input)
+    }
+}
+
+func TestPackOverwriteKeepsOldVPOnFailure(t *testing.T) {
+    in := path.Join(t.TempDir(), "in")
+    writeFiles(t, in, map[string]string{"data/maps/a.pof": "data/maps/a.pof", "data/maps/b.pof": "data/maps/b.pof"})
+    out := t.TempDir()
+    old := []byte("the VP from last time")
+    if err := os.WriteFile(path.Join(out, "maps.vp"), old, 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    // b.pof goes between the walk and being packed, so packing fails part
+    // way through the VP
+    p, err := newPacker(Options{OutputDir: out, OnExists: "overwrite"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    root, err := walkDir(in, p.walkOptions())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := os.Remove(path.Join(in, "data/maps/b.pof")); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := p.packInput(context.Background(), in, root, dirSource{}, false, p.walkOptions()); err == nil {
+        t.Fatal("no error packing a file that's gone")
+    }
+    if got := packedFiles(t, out); !reflect.DeepEqual(got, map[string][]byte{"maps.vp": old}) {
+        t.Errorf("a failed overwrite left %q", got)
+    }
+
+    // and one that works replaces it
+    if err := Pack(context.Background(), []string{in}, Options{OutputDir: out, OnExists: "overwrite"}); err != nil {
+        t.Fatal(err)
+    }
+    if got := vpPaths(t, path.Join(out, "maps.vp")); !reflect.DeepEqual(got, []string{"data", "data/maps", "data/maps/a.pof", "data", "."}) {
+        t.Errorf("overwritten maps.vp holds %q", got)
+    }
+}
+
+func TestPackTransformChangingSizes(t *testing.T) {
+    in := path.Join(t.TempDir(), "in")
+    writeFiles(t, in, map[string]string{
+        "data/maps/a.pof": "aaa",
+        "data/maps/sub/b.pof": "b",
+        "data/tables/c.tbl": "one\r\ntwo\r\n",
+        "data/tables/d.tbl": "d",
+    })
+    // each file twice over, so every offset after the first file moves
+    twice := func(p string, r io.Reader) (io.Reader, int64, error) {
+        b, err := io.ReadAll(r)
+        if err != nil {
+            return nil, 0, err
+        }
+        return bytes.NewReader(append(b, b...)), int64(2 * len(b)), nil
+    }
+    want := map[string]string{
+        "data/maps/a.pof": "aaaaaa",
+        "data/maps/sub/b.pof": "bb",
+        "data/tables/c.tbl": "one\ntwo\none\ntwo\n",
+        "data/tables/d.tbl": "dd",
+    }
+    for _, twoPass := range []bool{false, true} {
+        out := t.TempDir()
+        opts := Options{OutputDir: out, NormalizeEOL: "lf", Transform: twice, TwoPass: twoPass}
+        if err := Pack(context.Background(), []string{in}, opts); err != nil {
+            t.Fatal(err)
+        }
+        got := laidOutFiles(t, path.Join(out, "maps.vp"))
+        for p, b := range laidOutFiles(t, path.Join(out, "tables.vp")) {
+            got[p] = b
+        }
+        if !reflect.DeepEqual(got, want) {
+            t.Errorf("two pass %v: packed %q, want %q", twoPass, got, want)
+        }
+    }
+}
+
+func TestPackStoreFullPath(t *testing.T) {
+    in := path.Join(t.TempDir(), "in")
+    writeFiles(t, in, map[string]string{"data/maps/a.pof": "a", "data/maps/sub/b.pof": "b"})
+    out := t.TempDir()
+    if err := Pack(context.Background(), []string{in}, Options{OutputDir: out, StoreFullPath: true}); err != nil {
+        t.Fatal(err)
+    }
+    f, err := os.Open(path.Join(out, "maps.vp"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+    info, err := f.Stat()
+    if err != nil {
+        t.Fatal(err)
+    }
+    toc, err := vp.ReadTOC(f, info.Size())
+    if err != nil {
+        t.Fatal(err)
+    }
+    names := []string{}
+    for _, entry := range toc {
+        names = append(names, entry.Name)
+    }
+    if want := []string{"data/maps/a.pof", "data/maps/sub/b.pof"}; !reflect.DeepEqual(names, want) {
+        t.Errorf("packed %q, want only the files, named by their paths", names)
+    }
+
+    for _, c := range []struct {
+        what string
+        files map[string]string
+        err string
+    }{
+        {"paths differing only in case", map[string]string{"data/maps/sub/a.pof": "a", "data/maps/Sub/a.pof": "A"}, "differ only in case"},
+        {"a path too long", map[string]string{"data/maps/" + strings.Repeat("x", MaxNameBytes - 13) + ".pof": "x"}, "more than the"},
+    } {
+        in := path.Join(t.TempDir(), "in")
+        writeFiles(t, in, c.files)
+        err := Pack(context.Background(), []string{in}, Options{OutputDir: t.TempDir(), StoreFullPath: true})
+        if err == nil || !strings.Contains(err.Error(), c.err) {
+            t.Errorf("%v: packing gave %v, want an error saying %q", c.what, err, c.err)
+        }
+    }
+}
+
+func TestPackSkipsKeptApart(t *testing.T) {
+    // packs running at once each report only what they left out
+    var wg sync.WaitGroup
+    manifests := make([]string, 4)
+    for i := range manifests {
+        in := path.Join(t.TempDir(), "in")
+        writeFiles(t, in, map[string]string{"data/maps/a.pof": "a", fmt.Sprintf("data/maps/%d.tmp", i): "x"})
+        out := t.TempDir()
+        manifests[i] = path.Join(out, "manifest.json")
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            if err := Pack(context.Background(), []string{in}, Options{OutputDir: out, Exclude: []string{"**/*.tmp"}, ManifestOut: manifests[i]}); err != nil {
+                t.Error(err)
+            }
+        }()
+    }
+    wg.Wait()
+    for i, p := range manifests {
+        b, err := os.ReadFile(p)
+        if err != nil {
+            t.Fatal(err)
+        }
+        var manifest struct {
+            Skipped []skippedEntry `json:"skipped"`
+        }
+        if err := json.Unmarshal(b, &manifest); err != nil {
+            t.Fatal(err)
+        }
+        if len(manifest.Skipped) != 1 || path.Base(manifest.Skipped[0].Path) != fmt.Sprintf("%d.tmp", i) {
+            t.Errorf("pack %d skipped %+v", i, manifest.Skipped)
+        }
+    }
+}
@@ -0,0 +1,62 @@
+package aztech
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "os"
+)
+
+// copyContext copies src to dst like io.Copy, but gives up with ctx's error
+// once ctx is cancelled, and after each chunk tells progress (if not nil)
+// how many of total bytes are done. written is the count before this copy
+// starts, so one count can run across several files; the new count is
+// returned.
+func copyContext(ctx context.Context, dst io.Writer, src io.Reader, written int64, total int64, progress func(written, total int64)) (int64, error) {
+    buf := make([]byte, 32 * 1024)
+    for {
+        if err := ctx.Err(); err != nil {
+            return written, err
+        }
+        n, err := src.Read(buf)
+        if n > 0 {
+            if _, err := dst.Write(buf[:n]); err != nil {
+                return written, err
+            }
+            written += int64(n)
+            if progress != nil {
+                progress(written, total)
+            }
+        }
+        if err == io.EOF {
+            return written, nil
+        }
+        if err != nil {
+            return written, err
+        }
+    }
+}
+
+// ProgressPrinter returns a progress hook that reports how far through
+// label we are on stderr, each time the percentage changes.
+func ProgressPrinter(label string) func(written, total int64) {
+    last := -1
+    return func(written, total int64) {
+        pct := 100
+        if total > 0 {
+            pct = int(written * 100 / total)
+        }
+        if pct == last {
+            return
+        }
+        last = pct
+        if LogFormat == "kv" {
+            LogEntry("info", label, written, fmt.Sprintf("%d%% done", pct))
+            return
+        }
+        fmt.Fprintf(os.Stderr, "\r%v: %3d%%", label, pct)
+        if written >= total {
+            fmt.Fprintln(os.Stderr)
+        }
+    }
+}
@@ -0,0 +1,72 @@
+package aztech
+
+import (
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "time"
+
+    "github.com/tcrayford/aztech/vp"
+)
+
+// ReadTOCFile is vp.ReadTOC for the VP at vpPath.
+func ReadTOCFile(vpPath string) ([]vp.TOCEntry, error) {
+    f, err := OpenVP(vpPath)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    return vp.ReadTOC(f, f.Size)
+}
+
+// tocFileInfo presents a TOC entry as an os.FileInfo, so that entries read
+// back from a VP can be gathered into a tree like archive members are.
+type tocFileInfo struct {
+    entry vp.TOCEntry
+}
+
+func (fi tocFileInfo) Name() string {
+    return fi.entry.Name
+}
+
+func (fi tocFileInfo) Size() int64 {
+    return int64(fi.entry.Size)
+}
+
+func (fi tocFileInfo) Mode() os.FileMode {
+    if fi.entry.IsDir {
+        return os.ModeDir | 0755
+    }
+    return 0644
+}
+
+func (fi tocFileInfo) ModTime() time.Time {
+    return time.Unix(int64(fi.entry.Timestamp), 0)
+}
+
+func (fi tocFileInfo) IsDir() bool {
+    return fi.entry.IsDir
+}
+
+func (fi tocFileInfo) Sys() interface{} {
+    return nil
+}
+
+// vpSource reads files out of an existing VP, by their path in it.
+type vpSource struct {
+    r io.ReaderAt
+    entries map[string]vp.TOCEntry
+}
+
+func (s vpSource) Open(name string) (io.ReadCloser, error) {
+    entry, ok := s.entries[name]
+    if !ok {
+        return nil, fmt.Errorf("%v is not in the archive", name)
+    }
+    return ioutil.NopCloser(vp.OpenEntry(s.r, entry)), nil
+}
+
+func (s vpSource) Close() error {
+    return nil
+}
@@ -0,0 +1,86 @@
+package aztech
+
+import (
+    "context"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path"
+
+    "github.com/tcrayford/aztech/vp"
+)
+
+// RebuildVP reads the VP at vpPath and writes the rebuilt one to outPath
+// by way of a temporary file, so outPath can be vpPath itself. group is
+// the order of entries within a directory, as pack's --group takes it.
+func RebuildVP(vpPath string, outPath string, group string) error {
+    opts := tocOptions{group: group}
+    f, err := OpenVP(vpPath)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    // the rebuilt VP isn't compressed, so can't take a compressed one's
+    // name
+    if _, raw := f.ReaderAt.(*os.File); !raw && outPath == vpPath {
+        return fmt.Errorf("%v is compressed, so the rebuilt VP needs a path of its own (-o)", vpPath)
+    }
+    info, err := f.f.Stat()
+    if err != nil {
+        return err
+    }
+    entries, err := vp.ReadIndex(f, f.Size)
+    if err != nil {
+        return err
+    }
+    paths, err := vp.ArchivePaths(entries)
+    if err != nil {
+        if err := complain(vpPath, "rebuilding from the paths that can be made out", "%v", err); err != nil {
+            return err
+        }
+    }
+    for i, p := range paths {
+        entries[i].Path = p
+    }
+
+    tree := newArchiveTree(walkOptions{})
+    src := vpSource{f, map[string]vp.TOCEntry{}}
+    for _, entry := range entries {
+        if entry.IsDir && entry.Name == ".." {
+            continue
+        }
+        added, err := tree.add(entry.Path, tocFileInfo{entry}, true)
+        if err != nil {
+            return err
+        }
+        if added {
+            src.entries[MemberPath(entry.Path)] = entry
+        }
+    }
+    root := tree.build(".")
+    toc := []vp.TOCEntry{}
+    for _, child := range root.children {
+        childTOC, err := produceTOC(".", child, opts)
+        if err != nil {
+            return err
+        }
+        toc = append(toc, childTOC...)
+    }
+
+    tmp, err := ioutil.TempFile(path.Dir(outPath), "." + path.Base(outPath) + ".*")
+    if err != nil {
+        return err
+    }
+    err = printVP(context.Background(), root, toc, src, tmp, printOptions{})
+    if err == nil {
+        err = tmp.Chmod(info.Mode().Perm())
+    }
+    if closeErr := tmp.Close(); err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        os.Remove(tmp.Name())
+        return err
+    }
+    return os.Rename(tmp.Name(), outPath)
+}
@@ -0,0 +1,163 @@
+package aztech
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "path"
+    "sort"
+    "strings"
+
+    "github.com/tcrayford/aztech/vp"
+)
+
+// ReproCheck packs inputs twice with opts, each time into a temporary
+// directory of its own, and fails unless both packs wrote the same files
+// with the same bytes, naming the first offset that differs and what's
+// there. It's for catching anything that isn't deterministic, like the
+// order a directory is listed in, timestamps, or map iteration.
+func ReproCheck(ctx context.Context, inputs []string, opts Options) error {
+    if opts.DryRun || opts.Estimate || opts.TOCJSON {
+        return fmt.Errorf("a dry run, estimate or TOC JSON writes no VPs to compare")
+    }
+    // only the packs' output is compared, so nothing else is written
+    opts.Clean = false
+    opts.Summary = false
+    opts.SummaryJSON = false
+    opts.AppendLog = ""
+    dirs := []string{}
+    for i := 0; i < 2; i++ {
+        dir, err := os.MkdirTemp("", "aztech-reprocheck-")
+        if err != nil {
+            return err
+        }
+        defer os.RemoveAll(dir)
+        opts.OutputDir = dir
+        if err := Pack(ctx, inputs, opts); err != nil {
+            return fmt.Errorf("pack %d of 2: %w", i + 1, err)
+        }
+        dirs = append(dirs, dir)
+    }
+
+    names := [][]string{}
+    for _, dir := range dirs {
+        fileInfos, err := ioutil.ReadDir(dir)
+        if err != nil {
+            return err
+        }
+        list := []string{}
+        for _, f := range fileInfos {
+            list = append(list, f.Name())
+        }
+        sort.Strings(list)
+        names = append(names, list)
+    }
+    if strings.Join(names[0], "\n") != strings.Join(names[1], "\n") {
+        return fmt.Errorf("the two packs wrote different files: %v, then %v", strings.Join(names[0], ", "), strings.Join(names[1], ", "))
+    }
+    for _, name := range names[0] {
+        offset, same, err := firstDifference(path.Join(dirs[0], name), path.Join(dirs[1], name))
+        if err != nil {
+            return err
+        }
+        if same {
+            continue
+        }
+        where := ""
+        if strings.HasSuffix(strings.ToLower(name), ".vp") {
+            where = ", " + whereInVP(path.Join(dirs[0], name), offset)
+        }
+        return fmt.Errorf("%v differs between the two packs from offset %d%v", name, offset, where)
+    }
+    LogEntry("info", "", -1, fmt.Sprintf("packed twice, and the %d files written came out the same", len(names[0])))
+    return nil
+}
+
+// firstDifference compares the files at a and b, and if they differ, says
+// the offset of the first byte that does, which is the length of the
+// shorter if one is the start of the other.
+func firstDifference(a string, b string) (int64, bool, error) {
+    fa, err := os.Open(a)
+    if err != nil {
+        return 0, false, err
+    }
+    defer fa.Close()
+    fb, err := os.Open(b)
+    if err != nil {
+        return 0, false, err
+    }
+    defer fb.Close()
+    ra, rb := bufio.NewReader(fa), bufio.NewReader(fb)
+    bufA, bufB := make([]byte, 64 * 1024), make([]byte, 64 * 1024)
+    var offset int64 = 0
+    for {
+        na, errA := io.ReadFull(ra, bufA)
+        nb, errB := io.ReadFull(rb, bufB)
+        if errA != nil && errA != io.EOF && errA != io.ErrUnexpectedEOF {
+            return 0, false, errA
+        }
+        if errB != nil && errB != io.EOF && errB != io.ErrUnexpectedEOF {
+            return 0, false, errB
+        }
+        n := na
+        if nb < n {
+            n = nb
+        }
+        for i := 0; i < n; i++ {
+            if bufA[i] != bufB[i] {
+                return offset + int64(i), false, nil
+            }
+        }
+        if na != nb {
+            return offset + int64(n), false, nil
+        }
+        if na < len(bufA) {
+            return 0, true, nil
+        }
+        offset += int64(n)
+    }
+}
+
+// whereInVP describes what's at offset in the VP at vpPath: the header,
+// a file's data, or an index entry, by its path in the archive.
+func whereInVP(vpPath string, offset int64) string {
+    if offset < vp.HeaderSize {
+        return "in the header"
+    }
+    f, err := os.Open(vpPath)
+    if err != nil {
+        return "somewhere unknown"
+    }
+    defer f.Close()
+    header := make([]byte, vp.HeaderSize)
+    if _, err := io.ReadFull(f, header); err != nil || !bytes.Equal(header[:4], []byte(vp.Magic)) {
+        return "in what isn't a VP"
+    }
+    indexOffset := int64(binary.LittleEndian.Uint32(header[8:]))
+    entries, err := ReadTOCFile(vpPath)
+    if err != nil {
+        return "in a VP that can't be read"
+    }
+    paths, err := vp.ArchivePaths(entries)
+    if err != nil {
+        return "in a VP that can't be read"
+    }
+    if offset >= indexOffset {
+        i := (offset - indexOffset) / vp.IndexEntrySize
+        if i < int64(len(entries)) {
+            return fmt.Sprintf("in the index entry for %v", paths[i])
+        }
+        return "past the end of the index"
+    }
+    for i, entry := range entries {
+        if !entry.IsDir && offset >= int64(entry.Offset) && offset < int64(entry.Offset) + int64(entry.Size) {
+            return fmt.Sprintf("in the data of %v", paths[i])
+        }
+    }
+    return "between files' data"
+}
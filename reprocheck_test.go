@@ -0,0 +1,104 @@
+package aztech
+
+import (
+    "bytes"
+    "context"
+    "fmt"
+    "io"
+    "os"
+    "path"
+    "strings"
+    "testing"
+
+    "github.com/tcrayford/aztech/vp"
+)
+
+func TestFirstDifference(t *testing.T) {
+    big := strings.Repeat("x", 200000)
+    for _, c := range []struct {
+        a string
+        b string
+        offset int64
+        same bool
+    }{
+        {"", "", 0, true},
+        {"abc", "abc", 0, true},
+        {big, big, 0, true},
+        {"abc", "abd", 2, false},
+        {"abc", "xbc", 0, false},
+        // one the start of the other differs where the shorter ends
+        {"abc", "abcd", 3, false},
+        {big + "y", big, 200000, false},
+        {big[:100000] + "y" + big[100001:], big, 100000, false},
+    } {
+        dir := t.TempDir()
+        writeFiles(t, dir, map[string]string{"a": c.a, "b": c.b})
+        offset, same, err := firstDifference(path.Join(dir, "a"), path.Join(dir, "b"))
+        if err != nil || offset != c.offset || same != c.same {
+            t.Errorf("%d and %d bytes: gave %d, %v, %v, want %d, %v", len(c.a), len(c.b), offset, same, err, c.offset, c.same)
+        }
+    }
+}
+
+func TestWhereInVP(t *testing.T) {
+    in := path.Join(t.TempDir(), "in")
+    writeFiles(t, in, map[string]string{"data/maps/a.pof": "aaa", "data/maps/sub/b.pof": "bbbb"})
+    out := t.TempDir()
+    if err := Pack(context.Background(), []string{in}, Options{OutputDir: out}); err != nil {
+        t.Fatal(err)
+    }
+    vpPath := path.Join(out, "maps.vp")
+    b, err := os.ReadFile(vpPath)
+    if err != nil {
+        t.Fatal(err)
+    }
+    toc, err := vp.ReadTOC(bytes.NewReader(b), int64(len(b)))
+    if err != nil {
+        t.Fatal(err)
+    }
+    indexOffset := int64(len(b)) - int64(len(toc)) * vp.IndexEntrySize
+    for _, c := range []struct {
+        offset int64
+        want string
+    }{
+        {0, "in the header"},
+        {vp.HeaderSize - 1, "in the header"},
+        {vp.HeaderSize, "in the data of data/maps/a.pof"},
+        {vp.HeaderSize + 2, "in the data of data/maps/a.pof"},
+        {vp.HeaderSize + 3, "in the data of data/maps/sub/b.pof"},
+        {indexOffset, "in the index entry for data"},
+        {indexOffset + 2 * vp.IndexEntrySize + 5, "in the index entry for data/maps/a.pof"},
+        {int64(len(b)), "past the end of the index"},
+    } {
+        if got := whereInVP(vpPath, c.offset); got != c.want {
+            t.Errorf("offset %d is %q, want %q", c.offset, got, c.want)
+        }
+    }
+}
+
+func TestReproCheck(t *testing.T) {
+    in := path.Join(t.TempDir(), "in")
+    writeFiles(t, in, map[string]string{"data/maps/a.pof": "aaa", "data/maps/sub/b.pof": "bbbb"})
+    if err := ReproCheck(context.Background(), []string{in}, Options{}); err != nil {
+        t.Errorf("packing the same input twice: %v", err)
+    }
+
+    // b.pof's last byte is which pack it's in
+    packs := 0
+    differ := func(p string, r io.Reader) (io.Reader, int64, error) {
+        b, err := io.ReadAll(r)
+        if err != nil {
+            return nil, 0, err
+        }
+        if strings.HasSuffix(p, "b.pof") {
+            b = append(b[:len(b) - 1], fmt.Sprint(packs)...)
+            packs++
+        }
+        return bytes.NewReader(b), int64(len(b)), nil
+    }
+    err := ReproCheck(context.Background(), []string{in}, Options{Transform: differ, TwoPass: true})
+    want := fmt.Sprintf("maps.vp differs between the two packs from offset %d, in the data of data/maps/sub/b.pof", vp.HeaderSize + 3 + 3)
+    if err == nil || err.Error() != want {
+        t.Errorf("packing differently twice gave %v, want %q", err, want)
+    }
+}
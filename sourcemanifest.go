@@ -0,0 +1,121 @@
+package aztech
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "os"
+    "path"
+    "strings"
+    "time"
+)
+
+// A source manifest lists files to pack and where each goes, one per line
+// as the source path and the path inside the archive, tab separated. A
+// line with just a source path puts the file at that path in the archive,
+// so lists from find and the like work as they are. Blank lines and lines
+// starting with # are ignored. Relative source paths are taken from the
+// current directory.
+type SourceManifestLine struct {
+    Source string
+    ArchivePath string
+}
+
+// readSourceManifest parses a source manifest from r, named name for
+// errors.
+func readSourceManifest(r io.Reader, name string) ([]SourceManifestLine, error) {
+    out := []SourceManifestLine{}
+    scanner := bufio.NewScanner(r)
+    for n := 1; scanner.Scan(); n++ {
+        line := scanner.Text()
+        if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        fields := strings.Split(line, "\t")
+        if len(fields) == 1 {
+            fields = append(fields, MemberPath(fields[0]))
+        }
+        if len(fields) != 2 || fields[0] == "" || fields[1] == "" {
+            return nil, fmt.Errorf("%v:%d: want a source path, optionally followed by a tab and an archive path", name, n)
+        }
+        out = append(out, SourceManifestLine{fields[0], fields[1]})
+    }
+    return out, scanner.Err()
+}
+
+// WriteSourceManifestLine writes one line of a source manifest to w.
+func WriteSourceManifestLine(w io.Writer, line SourceManifestLine) error {
+    _, err := fmt.Fprintf(w, "%s\t%s\n", line.Source, line.ArchivePath)
+    return err
+}
+
+// renamedFileInfo is a file's os.FileInfo under the name it's packed as.
+type renamedFileInfo struct {
+    os.FileInfo
+    name string
+}
+
+func (fi renamedFileInfo) Name() string {
+    return fi.name
+}
+
+// manifestSource reads files from the source paths a source manifest
+// gave for them.
+type manifestSource struct {
+    sources map[string]string
+}
+
+func (s manifestSource) Open(name string) (io.ReadCloser, error) {
+    source, ok := s.sources[name]
+    if !ok {
+        return nil, fmt.Errorf("%v is not in the manifest", name)
+    }
+    return os.Open(source)
+}
+
+func (s manifestSource) Close() error {
+    return nil
+}
+
+// walkManifest builds the same shape of tree as walkDir from the source
+// manifest at manifestPath ("-" for stdin), rooted at "." with the files
+// at their archive paths. Files in the tree are read back through the
+// returned fileSource. Listed files that don't exist are an error, unless
+// opts says to keep going.
+func walkManifest(manifestPath string, opts walkOptions) (InputFileOrDir, fileSource, error) {
+    f := os.Stdin
+    if manifestPath != "-" {
+        var err error
+        f, err = os.Open(manifestPath)
+        if err != nil {
+            return InputFileOrDir{"err", 0, time.Unix(0,0), false, []InputFileOrDir{}}, nil, err
+        }
+        defer f.Close()
+    }
+    lines, err := readSourceManifest(f, manifestPath)
+    if err != nil {
+        return InputFileOrDir{"err", 0, time.Unix(0,0), false, []InputFileOrDir{}}, nil, err
+    }
+    tree := newArchiveTree(opts)
+    src := manifestSource{map[string]string{}}
+    for _, line := range lines {
+        fi, err := os.Stat(line.Source)
+        if os.IsNotExist(err) && opts.keepGoing {
+            warnf(line.Source, "%v, skipping it", err)
+            opts.skips.note(line.Source, skipUnreadable)
+            continue
+        }
+        if err != nil {
+            return InputFileOrDir{"err", 0, time.Unix(0,0), false, []InputFileOrDir{}}, nil, err
+        }
+        p := MemberPath(line.ArchivePath)
+        added, err := tree.add(p, renamedFileInfo{fi, path.Base(p)}, fi.Mode().IsRegular())
+        if err != nil {
+            return InputFileOrDir{"err", 0, time.Unix(0,0), false, []InputFileOrDir{}}, nil, err
+        }
+        if added {
+            src.sources[p] = line.Source
+        }
+    }
+    return tree.build("."), src, nil
+}
@@ -0,0 +1,226 @@
+package aztech
+
+import (
+    "fmt"
+    "io"
+    "math"
+    "os"
+    "path"
+
+    "github.com/tcrayford/aztech/vp"
+)
+
+// PackStream packs inputDir into a single VP written to w, from where w is
+// now, writing each file's data as soon as the walk comes to it. Only the
+// index and the directory being listed are held in memory, where Pack
+// walks a whole directory in data and works out its TOC before writing
+// any of it. The header goes out with a placeholder first, and is patched
+// once the index is written after the data, leaving w at the end of the
+// VP.
+//
+// The VP holds data, or with NoDataCheck, everything in inputDir. There's
+// no splitting, so going over MaxVPSize is an error, as is anything in
+// opts that needs the whole TOC before writing (see streamUnsupported).
+// Options about output files don't apply.
+func PackStream(w io.WriteSeeker, inputDir string, opts Options) error {
+    opts = opts.withDefaults()
+    if err := opts.check(); err != nil {
+        return err
+    }
+    if err := streamUnsupported(opts); err != nil {
+        return err
+    }
+    inputDir = path.Clean(inputDir)
+    p := &packer{opts: opts, skipped: skipLog{}}
+    s := &streamer{
+        w: w,
+        inputDir: inputDir,
+        walkOpts: p.walkOptions(),
+        tocOpts: opts.tocOptions(),
+        maxSize: int64(opts.MaxVPSize),
+        offset: vp.HeaderSize,
+    }
+    if opts.FollowSymlinks {
+        var err error
+        if s.walkOpts.links, err = newLinkTracker(inputDir, s.walkOpts.skips); err != nil {
+            return err
+        }
+    }
+    start, err := w.Seek(0, io.SeekCurrent)
+    if err != nil {
+        return err
+    }
+    if _, err := w.Write(vp.EncodeHeader(0, 0)); err != nil {
+        return err
+    }
+
+    prefix := []string{}
+    if s.tocOpts.prefix != "" {
+        if prefix, err = prefixElements("prefix", s.tocOpts.prefix); err != nil {
+            return err
+        }
+        // the prefix is put in once, here, rather than around each file
+        s.tocOpts.prefix = ""
+    }
+    for _, name := range prefix {
+        s.index = append(s.index, vp.TOCEntry{Name: name, Path: name, IsDir: true, Offset: vp.HeaderSize})
+    }
+    if opts.NoDataCheck {
+        err = s.dir(inputDir, 0)
+    } else {
+        dataPath := path.Join(inputDir, "data")
+        var info os.FileInfo
+        if info, err = os.Stat(dataPath); err == nil && !info.IsDir() {
+            err = fmt.Errorf("%v is not a directory", dataPath)
+        }
+        if err == nil {
+            err = s.subdir(InputFileOrDir{dataPath, 0, info.ModTime(), true, []InputFileOrDir{}}, 1)
+        }
+    }
+    if err != nil {
+        return err
+    }
+    for range prefix {
+        s.index = append(s.index, vp.TOCEntry{Name: "..", IsDir: true, Offset: int32(s.offset)})
+    }
+    if err := checkChunkPaths(s.index); err != nil {
+        return err
+    }
+
+    indexOffset := s.offset
+    for _, entry := range s.index {
+        if _, err := w.Write(vp.EncodeIndexEntry(entry.Name, entry.Offset, entry.Size, entry.Timestamp, opts.NamePad)); err != nil {
+            return err
+        }
+    }
+    end := indexOffset + int64(len(s.index)) * vp.IndexEntrySize
+    if _, err := w.Seek(start, io.SeekStart); err != nil {
+        return err
+    }
+    if _, err := w.Write(vp.EncodeHeader(int32(indexOffset), int32(len(s.index)))); err != nil {
+        return err
+    }
+    _, err = w.Seek(start + end, io.SeekStart)
+    return err
+}
+
+// streamUnsupported fails if opts asks for something PackStream can't do,
+// as it needs the whole TOC before any data's written, or more than one
+// VP.
+func streamUnsupported(opts Options) error {
+    unsupported := []struct {
+        what string
+        asked bool
+    }{
+        {"a target size or entry limit, which split", opts.TargetSize > 0 || opts.MaxEntries > 0},
+        {"a budget", opts.Budget > 0},
+        {"only or skip directories, which pick VPs", len(opts.OnlyDirs) > 0 || len(opts.SkipDirs) > 0},
+        {"file lists", opts.FileLists},
+        {"a root name", opts.RootName != ""},
+        {"a prefix to trim", opts.TrimPrefix != ""},
+        {"full paths", opts.StoreFullPath},
+        {"the small-first layout", opts.Layout == "small-first"},
+        {"line ending normalization", opts.NormalizeEOL != ""},
+        {"a transform", opts.Transform != nil},
+        {"an embedded manifest", opts.EmbedManifest},
+        {"an embedded hash", opts.EmbedHash},
+        {"size exclusions", opts.ExcludeSmallerThan > 0 || opts.ExcludeLargerThan > 0},
+        {"an entry filter", opts.EntryFilter != ""},
+    }
+    for _, u := range unsupported {
+        if u.asked {
+            return fmt.Errorf("%v can't be streamed", u.what)
+        }
+    }
+    return nil
+}
+
+// streamer is what PackStream keeps track of as it walks.
+type streamer struct {
+    w io.Writer
+    inputDir string
+    walkOpts walkOptions
+    tocOpts tocOptions
+    maxSize int64
+    // where the next file's data goes, from the start of the VP
+    offset int64
+    // the index so far
+    index []vp.TOCEntry
+}
+
+// dir streams the contents of the directory at dirPath, depth levels
+// below the input, listing it alone and going into each directory in it
+// as it comes to it.
+func (s *streamer) dir(dirPath string, depth int) error {
+    walkOpts := s.walkOpts
+    walkOpts.stubDepth = depth + 1
+    listed, err := walkDirDepth(dirPath, walkOpts, depth)
+    if err != nil {
+        return err
+    }
+    if s.tocOpts.lowerExt {
+        if err := checkLowerExtConflicts(listed); err != nil {
+            return err
+        }
+    }
+    if err := checkNameConflicts(listed); err != nil {
+        return err
+    }
+    for _, c := range sortChildren(listed.children, s.tocOpts) {
+        if c.isDir {
+            err = s.subdir(c, depth + 1)
+        } else {
+            err = s.file(c)
+        }
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// subdir streams the directory d, depth levels below the input, between
+// its markers.
+func (s *streamer) subdir(d InputFileOrDir, depth int) error {
+    name, err := checkName(d.originalPath)
+    if err != nil {
+        return err
+    }
+    s.index = append(s.index, vp.TOCEntry{Name: name, Path: d.originalPath, IsDir: true, Offset: int32(s.offset)})
+    if err := s.dir(d.originalPath, depth); err != nil {
+        return err
+    }
+    s.index = append(s.index, vp.TOCEntry{Name: "..", Path: path.Join(d.originalPath, ".."), IsDir: true, Offset: int32(s.offset)})
+    return nil
+}
+
+// file writes the data of f and adds its entry to the index.
+func (s *streamer) file(f InputFileOrDir) error {
+    toc, err := produceTOC(s.inputDir, f, s.tocOpts)
+    if err != nil {
+        return err
+    }
+    entry := toc[0]
+    if s.offset - vp.HeaderSize + int64(entry.Size) > s.maxSize {
+        return fmt.Errorf("%v would take the VP's file data over %d bytes, and a stream can't be split", f.originalPath, s.maxSize)
+    }
+    if s.offset + int64(entry.Size) > math.MaxInt32 {
+        return fmt.Errorf("%w: %v would go past the 32-bit offsets", vp.ErrSizeOverflow, f.originalPath)
+    }
+    in, err := os.Open(f.originalPath)
+    if err != nil {
+        return err
+    }
+    defer in.Close()
+    copied, err := io.Copy(s.w, io.LimitReader(in, int64(entry.Size) + 1))
+    if err != nil {
+        return fmt.Errorf("writing %v: %w", f.originalPath, err)
+    }
+    if copied != int64(entry.Size) {
+        return fmt.Errorf("%v is %d bytes, but the walk said %d; did it change during the pack?", f.originalPath, copied, entry.Size)
+    }
+    entry.Offset = int32(s.offset)
+    s.offset += copied
+    s.index = append(s.index, entry)
+    return nil
+}
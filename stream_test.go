@@ -0,0 +1,84 @@
+package aztech
+
+import (
+    "context"
+    "os"
+    "path"
+    "runtime/debug"
+    "testing"
+)
+
+// packStreamFile is what PackStream writes for in, with opts, to a file
+// out.
+func packStreamFile(t testing.TB, in string, out string, opts Options) {
+    f, err := os.Create(out)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+    if err := PackStream(f, in, opts); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestPackStreamMatchesPack(t *testing.T) {
+    in := makeTree(t, 3, 20)
+    opts := Options{NoDataCheck: true}
+    out := t.TempDir()
+    if err := Pack(context.Background(), []string{in}, Options{OutputDir: out, NoDataCheck: true}); err != nil {
+        t.Fatal(err)
+    }
+    want, err := os.ReadFile(path.Join(out, "in.vp"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    streamed := path.Join(t.TempDir(), "streamed.vp")
+    packStreamFile(t, in, streamed, opts)
+    got, err := os.ReadFile(streamed)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if string(got) != string(want) {
+        t.Errorf("PackStream wrote %d bytes that differ from Pack's %d", len(got), len(want))
+    }
+}
+
+// BenchmarkPackPeakHeap packs 40 directories of 1000 files into one VP with
+// Pack and with PackStream, reporting the most heap each had in use. On
+// the machine this was written on, Pack peaked at 75 to 95MB, holding the
+// whole tree and its TOC, and PackStream at about 12MB.
+func BenchmarkPackPeakHeap(b *testing.B) {
+    in := makeTree(b, 40, 1000)
+    // collect almost as soon as anything's garbage, so the samples are
+    // close to what's really held
+    defer debug.SetGCPercent(debug.SetGCPercent(1))
+    run := func(b *testing.B, pack func(out string) error) {
+        var most uint64
+        for i := 0; i < b.N; i++ {
+            out := b.TempDir()
+            before := liveHeap()
+            var err error
+            peak := peakHeap(func() {
+                err = pack(out)
+            })
+            if err != nil {
+                b.Fatal(err)
+            }
+            if peak - before > most {
+                most = peak - before
+            }
+        }
+        b.ReportMetric(float64(most), "peak-heap-bytes")
+    }
+    b.Run("Pack", func(b *testing.B) {
+        run(b, func(out string) error {
+            return Pack(context.Background(), []string{in}, Options{OutputDir: out, NoDataCheck: true})
+        })
+    })
+    b.Run("PackStream", func(b *testing.B) {
+        run(b, func(out string) error {
+            packStreamFile(b, in, path.Join(out, "in.vp"), Options{NoDataCheck: true})
+            return nil
+        })
+    })
+}
@@ -0,0 +1,62 @@
+package aztech
+
+import (
+    "os"
+    "path"
+    "path/filepath"
+    "strings"
+)
+
+// linkTracker is what a walk following symlinks knows about where it's
+// been, so that a link to a directory that's packed anyway isn't packed
+// all over again under the link's name.
+type linkTracker struct {
+    // the real paths of the input and of each directory first reached
+    // through a link, with the path the walk reached each by
+    walked map[string]string
+    // where the links skipped are noted
+    skips skipLog
+}
+
+// newLinkTracker starts tracking a walk of inputDir, noting the links it
+// skips in skips.
+func newLinkTracker(inputDir string, skips skipLog) (*linkTracker, error) {
+    t := &linkTracker{walked: map[string]string{}, skips: skips}
+    real, err := filepath.EvalSymlinks(inputDir)
+    if err != nil {
+        return nil, err
+    }
+    t.walked[filepath.ToSlash(real)] = inputDir
+    return t, nil
+}
+
+// follow stats what the symlink at p points to, for the walk to pack in
+// its place. A link to a directory that's in the input, or in one already
+// reached through another link, is collapsed instead: reported, and
+// skipped. Which link to an outside directory gets packed doesn't depend
+// on the walk order, as any link into the input is collapsed; otherwise
+// it's the first one the walk comes to.
+func (t *linkTracker) follow(p string) (info os.FileInfo, skip bool, err error) {
+    info, err = os.Stat(p)
+    if err != nil {
+        t.skips.note(p, skipUnreadable)
+        return nil, true, complain(p, "skipping it", "%v is a symlink to something that can't be read: %v", p, err)
+    }
+    if !info.IsDir() {
+        return info, false, nil
+    }
+    real, err := filepath.EvalSymlinks(p)
+    if err != nil {
+        return nil, false, err
+    }
+    real = filepath.ToSlash(real)
+    for dir, reached := range t.walked {
+        if real == dir || strings.HasPrefix(real, strings.TrimSuffix(dir, "/") + "/") {
+            t.skips.note(p, skipDuplicate)
+            warnf(p, "%v is a symlink to %v, which is packed as %v already, skipping it", p, real, path.Join(reached, strings.TrimPrefix(real, dir)))
+            return nil, true, nil
+        }
+    }
+    t.walked[real] = p
+    return info, false, nil
+}
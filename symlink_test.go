@@ -0,0 +1,49 @@
+package aztech
+
+import (
+    "context"
+    "os"
+    "path"
+    "reflect"
+    "testing"
+)
+
+func TestPackFollowSymlinks(t *testing.T) {
+    base := t.TempDir()
+    in := path.Join(base, "in")
+    writeFiles(t, in, map[string]string{"data/maps/a.pof": "a", "data/maps/sub/b.pof": "b"})
+    writeFiles(t, base, map[string]string{"outside/c.pof": "c"})
+    for link, target := range map[string]string{
+        // a file, packed under the link's name
+        "data/maps/f.pof": "../../../outside/c.pof",
+        // two links to the same outside directory, the first packed
+        "data/maps/l1": "../../../outside",
+        "data/maps/l2": "../../../outside",
+        // links back into the input, and a loop, all collapsed
+        "data/maps/again": "sub",
+        "data/maps/sub/up": "..",
+    } {
+        if err := os.Symlink(target, path.Join(in, link)); err != nil {
+            t.Skipf("can't make symlinks to test with: %v", err)
+        }
+    }
+
+    out := t.TempDir()
+    if err := Pack(context.Background(), []string{in}, Options{OutputDir: out, FollowSymlinks: true}); err != nil {
+        t.Fatal(err)
+    }
+    want := []string{"data", "data/maps", "data/maps/a.pof", "data/maps/f.pof", "data/maps/l1", "data/maps/l1/c.pof", "data/maps", "data/maps/sub", "data/maps/sub/b.pof", "data/maps", "data", "."}
+    if got := vpPaths(t, path.Join(out, "maps.vp")); !reflect.DeepEqual(got, want) {
+        t.Errorf("following symlinks packed %q, want %q", got, want)
+    }
+
+    // without following them, they're all skipped
+    out = t.TempDir()
+    if err := Pack(context.Background(), []string{in}, Options{OutputDir: out}); err != nil {
+        t.Fatal(err)
+    }
+    want = []string{"data", "data/maps", "data/maps/a.pof", "data/maps/sub", "data/maps/sub/b.pof", "data/maps", "data", "."}
+    if got := vpPaths(t, path.Join(out, "maps.vp")); !reflect.DeepEqual(got, want) {
+        t.Errorf("not following symlinks packed %q, want %q", got, want)
+    }
+}
@@ -0,0 +1,68 @@
+package aztech
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "os"
+
+    "github.com/tcrayford/aztech/vp"
+)
+
+// A hash trailer is an optional addition after a VP's index: the magic
+// below followed by the SHA-256 of everything before it. The format has
+// no spare header bytes to put it in, so it relies on readers going by
+// the header's entry count and ignoring what follows the index, as the
+// engine and vp.ReadTOC do. Tools that insist the index ends the file will
+// reject VPs that have one, which is why it's opt-in.
+const (
+    hashTrailerMagic = "AZH1"
+    hashTrailerSize = 4 + sha256.Size
+)
+
+// appendHashTrailer adds a hash trailer to the VP at vpPath.
+func appendHashTrailer(vpPath string) error {
+    f, err := os.OpenFile(vpPath, os.O_RDWR, 0)
+    if err != nil {
+        return err
+    }
+    h := sha256.New()
+    _, err = io.Copy(h, f)
+    if err == nil {
+        _, err = f.Write(append([]byte(hashTrailerMagic), h.Sum(nil)...))
+    }
+    if closeErr := f.Close(); err == nil {
+        err = closeErr
+    }
+    return err
+}
+
+// ReadHashTrailer returns the hash from the trailer of the VP in r, which
+// is size bytes long, and whether it matches the rest of the file. The
+// hash is nil if there's no trailer.
+func ReadHashTrailer(r io.ReaderAt, size int64) ([]byte, bool, error) {
+    header := make([]byte, vp.HeaderSize)
+    if _, err := r.ReadAt(header, 0); err != nil {
+        return nil, false, fmt.Errorf("reading header: %w", err)
+    }
+    indexOffset := int64(int32(binary.LittleEndian.Uint32(header[8:12])))
+    count := int64(int32(binary.LittleEndian.Uint32(header[12:16])))
+    indexEnd := indexOffset + count * vp.IndexEntrySize
+    if size != indexEnd + hashTrailerSize {
+        return nil, false, nil
+    }
+    trailer := make([]byte, hashTrailerSize)
+    if _, err := r.ReadAt(trailer, indexEnd); err != nil {
+        return nil, false, fmt.Errorf("reading hash trailer: %w", err)
+    }
+    if string(trailer[:4]) != hashTrailerMagic {
+        return nil, false, nil
+    }
+    h := sha256.New()
+    if _, err := io.Copy(h, io.NewSectionReader(r, 0, indexEnd)); err != nil {
+        return nil, false, err
+    }
+    return trailer[4:], bytes.Equal(h.Sum(nil), trailer[4:]), nil
+}
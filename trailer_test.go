@@ -0,0 +1,67 @@
+package aztech
+
+import (
+    "bytes"
+    "context"
+    "crypto/sha256"
+    "os"
+    "path"
+    "reflect"
+    "testing"
+
+    "github.com/tcrayford/aztech/vp"
+)
+
+func TestHashTrailer(t *testing.T) {
+    in := path.Join(t.TempDir(), "in")
+    writeFiles(t, in, map[string]string{"data/maps/a.pof": "aaa", "data/maps/sub/b.pof": "bbb"})
+    plain := t.TempDir()
+    if err := Pack(context.Background(), []string{in}, Options{OutputDir: plain}); err != nil {
+        t.Fatal(err)
+    }
+    out := t.TempDir()
+    if err := Pack(context.Background(), []string{in}, Options{OutputDir: out, EmbedHash: true}); err != nil {
+        t.Fatal(err)
+    }
+    want, err := os.ReadFile(path.Join(plain, "maps.vp"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    b, err := os.ReadFile(path.Join(out, "maps.vp"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    // the trailer is the magic and the hash of the VP it's added to
+    sum := sha256.Sum256(want)
+    if trailer := append([]byte(hashTrailerMagic), sum[:]...); !bytes.Equal(b, append(want, trailer...)) {
+        t.Fatalf("packed %d bytes, want the %d of the VP without a trailer and the trailer", len(b), len(want))
+    }
+    // and readers going by the header don't see it
+    if got := vpPaths(t, path.Join(out, "maps.vp")); !reflect.DeepEqual(got, vpPaths(t, path.Join(plain, "maps.vp"))) {
+        t.Errorf("the VP with a trailer holds %q", got)
+    }
+
+    flipped := append([]byte{}, b...)
+    flipped[vp.HeaderSize] ^= 1
+    for _, c := range []struct {
+        what string
+        vp []byte
+        hash []byte
+        ok bool
+    }{
+        {"the VP with a trailer", b, sum[:], true},
+        {"a changed byte of data", flipped, sum[:], false},
+        {"no trailer", want, nil, false},
+        {"a cut off trailer", b[:len(b) - 1], nil, false},
+        {"something else after the index", append(append([]byte{}, want...), bytes.Repeat([]byte("x"), hashTrailerSize)...), nil, false},
+    } {
+        hash, ok, err := ReadHashTrailer(bytes.NewReader(c.vp), int64(len(c.vp)))
+        if err != nil {
+            t.Errorf("%v: %v", c.what, err)
+            continue
+        }
+        if !bytes.Equal(hash, c.hash) || ok != c.ok {
+            t.Errorf("%v: read hash %x, matching %v, want %x, %v", c.what, hash, ok, c.hash, c.ok)
+        }
+    }
+}
@@ -0,0 +1,191 @@
+package aztech
+
+import (
+    "context"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "path"
+    "strings"
+
+    "github.com/tcrayford/aztech/vp"
+)
+
+// UpdateStats is what UpdateVP did to each file.
+type UpdateStats struct {
+    // copied across from the old VP
+    Kept int
+    // read from disk again, having changed
+    Changed int
+    // only in the source
+    Added int
+    // only in the old VP, and left out
+    Removed int
+}
+
+// UpdateVP writes a VP of srcDir to outPath, as a pack with group would,
+// taking the data of every file whose size and timestamp match its entry in
+// the VP at vpPath from there rather than from disk. With byContent, those
+// are compared byte for byte as well. Like RebuildVP, it goes by way of a
+// temporary file, so outPath can be vpPath itself.
+//
+// Only plain packs can be updated, as the TOC is worked out with
+// sourceTOC, which knows nothing of the options a VP was packed with. One
+// part of a split pack is refused, since which part a new file would have
+// gone in depends on the split as a whole, as are VPs with anything a plain
+// pack wouldn't put in: a hash trailer, an embedded manifest, or no files
+// where it would put them, as with --prefix. Options that don't show, like
+// --eol, are lost.
+func UpdateVP(vpPath string, srcDir string, outPath string, group string, byContent bool) (UpdateStats, error) {
+    stats := UpdateStats{}
+    opts := tocOptions{group: group}
+    f, err := OpenVP(vpPath)
+    if err != nil {
+        return stats, err
+    }
+    defer f.Close()
+    // the updated VP isn't compressed, so can't take a compressed one's
+    // name
+    if _, raw := f.ReaderAt.(*os.File); !raw && outPath == vpPath {
+        return stats, fmt.Errorf("%v is compressed, so the updated VP needs a path of its own (-o)", vpPath)
+    }
+    info, err := f.f.Stat()
+    if err != nil {
+        return stats, err
+    }
+    entries, err := vp.ReadTOC(f, f.Size)
+    if err != nil {
+        return stats, err
+    }
+    if err := checkUpdatable(vpPath, f, entries); err != nil {
+        return stats, err
+    }
+    toc, paths, err := sourceTOC(entries, srcDir, opts)
+    if err != nil {
+        return stats, err
+    }
+
+    old := map[string]vp.TOCEntry{}
+    for _, entry := range entries {
+        if !entry.IsDir {
+            old[strings.ToLower(entry.Path)] = entry
+        }
+    }
+    src := updateSource{f, map[string]vp.TOCEntry{}}
+    for i, entry := range toc {
+        if entry.IsDir {
+            continue
+        }
+        key := strings.ToLower(paths[i])
+        was, ok := old[key]
+        delete(old, key)
+        switch {
+        case !ok:
+            stats.Added++
+            continue
+        case was.Size != entry.Size || was.Timestamp != entry.Timestamp:
+            stats.Changed++
+            continue
+        case byContent:
+            same, err := sameContents(vp.OpenEntry(f, was), entry.Path)
+            if err != nil {
+                return stats, err
+            }
+            if !same {
+                stats.Changed++
+                continue
+            }
+        }
+        stats.Kept++
+        src.kept[entry.Path] = was
+    }
+    stats.Removed = len(old)
+    if stats.Kept + stats.Changed == 0 && stats.Removed > 0 {
+        return stats, fmt.Errorf("none of the files in %v are where a plain pack of %v would put them, so it was packed with options update can't redo, such as --prefix; pack it again instead", vpPath, srcDir)
+    }
+    for _, entry := range old {
+        if embedded, err := isEmbeddedManifest(f, entry); err != nil {
+            return stats, err
+        } else if embedded {
+            return stats, fmt.Errorf("%v has a manifest embedded at %v, which update can't write; pack it again instead", vpPath, entry.Path)
+        }
+    }
+
+    tmp, err := ioutil.TempFile(path.Dir(outPath), "." + path.Base(outPath) + ".*")
+    if err != nil {
+        return stats, err
+    }
+    in := InputFileOrDir{srcDir, 0, info.ModTime(), true, []InputFileOrDir{}}
+    err = printVP(context.Background(), in, toc, src, tmp, printOptions{})
+    if err == nil {
+        err = tmp.Chmod(info.Mode().Perm())
+    }
+    if closeErr := tmp.Close(); err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        os.Remove(tmp.Name())
+        return stats, err
+    }
+    return stats, os.Rename(tmp.Name(), outPath)
+}
+
+// checkUpdatable fails if the VP at vpPath, with index entries, is
+// something UpdateVP would get wrong: one part of a split pack, going by
+// the name nameParts gives it, or one with more after its index, such as
+// a hash trailer.
+func checkUpdatable(vpPath string, f *VPFile, entries []vp.TOCEntry) error {
+    name := strings.ToLower(path.Base(vpPath))
+    for _, method := range []string{"gzip", "zstd"} {
+        name = strings.TrimSuffix(name, compressExt(method))
+    }
+    name = strings.TrimSuffix(name, ".vp")
+    if i := strings.LastIndex(name, "-"); i >= 0 && len(name) - i > 2 && strings.Trim(name[i + 1:], "0123456789") == "" {
+        for _, entry := range entries {
+            if entry.IsDir && path.Dir(entry.Path) == "data" && strings.ToLower(entry.Name) == name[:i] {
+                return fmt.Errorf("%v is one part of data/%v split over several VPs, and update can't tell which part new files would go in; pack it again instead", vpPath, entry.Name)
+            }
+        }
+    }
+    header := make([]byte, vp.HeaderSize)
+    if _, err := f.ReadAt(header, 0); err != nil {
+        return err
+    }
+    indexEnd := int64(int32(binary.LittleEndian.Uint32(header[8:12]))) + int64(len(entries)) * vp.IndexEntrySize
+    if f.Size > indexEnd {
+        return fmt.Errorf("%v has %d bytes after its index, such as a hash trailer, which update wouldn't keep; pack it again instead", vpPath, f.Size - indexEnd)
+    }
+    return nil
+}
+
+// isEmbeddedManifest says whether entry is a manifest embedded by pack,
+// going by the first line manifestText gives it.
+func isEmbeddedManifest(f *VPFile, entry vp.TOCEntry) (bool, error) {
+    head := make([]byte, 512)
+    n, err := vp.OpenEntry(f, entry).Read(head)
+    if err != nil && err != io.EOF {
+        return false, err
+    }
+    line := strings.SplitN(string(head[:n]), "\n", 2)[0]
+    return strings.Contains(line, ", packed by aztech "), nil
+}
+
+// updateSource reads the files UpdateVP kept out of the old VP, by the
+// path they have on disk, and everything else off disk.
+type updateSource struct {
+    r io.ReaderAt
+    kept map[string]vp.TOCEntry
+}
+
+func (s updateSource) Open(name string) (io.ReadCloser, error) {
+    if entry, ok := s.kept[name]; ok {
+        return ioutil.NopCloser(vp.OpenEntry(s.r, entry)), nil
+    }
+    return os.Open(name)
+}
+
+func (s updateSource) Close() error {
+    return nil
+}
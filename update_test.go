@@ -0,0 +1,151 @@
+package aztech
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "os/exec"
+    "path"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestUpdateVP(t *testing.T) {
+    in := path.Join(t.TempDir(), "in")
+    writeFiles(t, in, map[string]string{
+        "data/maps/a.pof": "a",
+        "data/maps/b.pof": "b",
+        "data/maps/sub/c.dds": "c",
+        "data/tables/ships.tbl": "ships",
+    })
+    out := t.TempDir()
+    if err := Pack(context.Background(), []string{in}, Options{OutputDir: out}); err != nil {
+        t.Fatal(err)
+    }
+
+    // a changes, b goes, d is new, and c stays as it was
+    later := time.Now().Add(time.Hour)
+    writeFiles(t, in, map[string]string{"data/maps/a.pof": "a, changed", "data/maps/sub/d.dds": "d"})
+    if err := os.Chtimes(path.Join(in, "data/maps/a.pof"), later, later); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.Remove(path.Join(in, "data/maps/b.pof")); err != nil {
+        t.Fatal(err)
+    }
+    vpPath := path.Join(out, "maps.vp")
+    stats, err := UpdateVP(vpPath, in, vpPath, "mixed", false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if want := (UpdateStats{Kept: 1, Changed: 1, Added: 1, Removed: 1}); stats != want {
+        t.Errorf("update did %+v, want %+v", stats, want)
+    }
+
+    fresh := t.TempDir()
+    if err := Pack(context.Background(), []string{in}, Options{OutputDir: fresh}); err != nil {
+        t.Fatal(err)
+    }
+    if got, want := readVP(t, vpPath), readVP(t, path.Join(fresh, "maps.vp")); !reflect.DeepEqual(got, want) {
+        t.Errorf("updated VP holds %q, want %q as packed afresh", got, want)
+    }
+}
+
+func TestUpdateVPRefuses(t *testing.T) {
+    in := path.Join(t.TempDir(), "in")
+    writeFiles(t, in, map[string]string{"data/maps/a.pof": "aaa", "data/maps/sub/b.pof": "bbb"})
+    for _, c := range []struct {
+        what string
+        opts Options
+        vp string
+        err string
+    }{
+        {"one part of a split", Options{TargetSize: 4}, "maps-01.vp", "one part of data/maps split"},
+        {"a prefix", Options{Prefix: "mods/x"}, "maps.vp", "such as --prefix"},
+        {"a hash trailer", Options{EmbedHash: true}, "maps.vp", "such as a hash trailer"},
+        {"an embedded manifest", Options{EmbedManifest: true}, "maps.vp", "has a manifest embedded"},
+    } {
+        out := t.TempDir()
+        c.opts.OutputDir = out
+        if err := Pack(context.Background(), []string{in}, c.opts); err != nil {
+            t.Fatalf("%v: %v", c.what, err)
+        }
+        vpPath := path.Join(out, c.vp)
+        before, err := os.ReadFile(vpPath)
+        if err != nil {
+            t.Fatal(err)
+        }
+        _, err = UpdateVP(vpPath, in, vpPath, "mixed", false)
+        if err == nil || !strings.Contains(err.Error(), c.err) {
+            t.Errorf("%v: updating gave %v, want an error saying %q", c.what, err, c.err)
+        }
+        if after, err := os.ReadFile(vpPath); err != nil || string(after) != string(before) {
+            t.Errorf("%v: the VP changed", c.what)
+        }
+    }
+}
+
+// dropPageCache empties the page cache, so what's read next comes off
+// disk, and says whether it could: that takes root, on Linux.
+func dropPageCache() bool {
+    exec.Command("sync").Run()
+    return os.WriteFile("/proc/sys/vm/drop_caches", []byte("3"), 0) == nil
+}
+
+// BenchmarkUpdateVP updates a VP of 40000 files of 4KB, 10 of which have
+// changed, against packing it afresh, with the page cache dropped first
+// where it can be: it's where the timings in updateMain's comment come
+// from.
+func BenchmarkUpdateVP(b *testing.B) {
+    in := path.Join(b.TempDir(), "in")
+    content := strings.Repeat("x", 4096)
+    files := map[string]string{}
+    for d := 0; d < 40; d++ {
+        for f := 0; f < 1000; f++ {
+            files[fmt.Sprintf("data/maps/dir%02d/file%04d.dds", d, f)] = content
+        }
+    }
+    writeFiles(b, in, files)
+    out := b.TempDir()
+    if err := Pack(context.Background(), []string{in}, Options{OutputDir: out}); err != nil {
+        b.Fatal(err)
+    }
+    vpPath := path.Join(out, "maps.vp")
+    changed := strings.Repeat("y", 4096)
+    for f := 0; f < 10; f++ {
+        p := path.Join(in, fmt.Sprintf("data/maps/dir%02d/file%04d.dds", f, f))
+        if err := os.WriteFile(p, []byte(changed), 0644); err != nil {
+            b.Fatal(err)
+        }
+        later := time.Now().Add(time.Hour)
+        if err := os.Chtimes(p, later, later); err != nil {
+            b.Fatal(err)
+        }
+    }
+    if !dropPageCache() {
+        b.Log("can't drop the page cache, so these are with it warm")
+    }
+
+    b.Run("Update", func(b *testing.B) {
+        for i := 0; i < b.N; i++ {
+            b.StopTimer()
+            dropPageCache()
+            b.StartTimer()
+            if _, err := UpdateVP(vpPath, in, path.Join(out, "updated.vp"), "mixed", false); err != nil {
+                b.Fatal(err)
+            }
+        }
+    })
+    b.Run("Pack", func(b *testing.B) {
+        for i := 0; i < b.N; i++ {
+            fresh := b.TempDir()
+            b.StopTimer()
+            dropPageCache()
+            b.StartTimer()
+            if err := Pack(context.Background(), []string{in}, Options{OutputDir: fresh}); err != nil {
+                b.Fatal(err)
+            }
+        }
+    })
+}
@@ -0,0 +1,45 @@
+package aztech
+
+import (
+    "runtime/debug"
+    "strings"
+)
+
+// Build metadata, meant to be set at build time with something like:
+//
+//   go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Without them the commit and date come from the Go toolchain's own VCS
+// stamping, where it has any.
+var (
+    version = "dev"
+    commit = ""
+    buildDate = ""
+)
+
+// Version describes this build of aztech: the version, followed by the
+// commit and build date if they're known.
+func Version() string {
+    c, d := commit, buildDate
+    if info, ok := debug.ReadBuildInfo(); ok {
+        for _, s := range info.Settings {
+            switch {
+            case s.Key == "vcs.revision" && c == "":
+                c = s.Value
+            case s.Key == "vcs.time" && d == "":
+                d = s.Value
+            }
+        }
+    }
+    details := []string{}
+    if c != "" {
+        details = append(details, "commit " + c)
+    }
+    if d != "" {
+        details = append(details, "built " + d)
+    }
+    if len(details) == 0 {
+        return version
+    }
+    return version + " (" + strings.Join(details, ", ") + ")"
+}
@@ -0,0 +1,16 @@
+package vp
+
+import (
+    "errors"
+)
+
+// The errors writing a VP can fail with that a caller might want to
+// handle, wrapped with the details, to be picked out with errors.Is.
+var (
+    // ErrNameTooLong is a name that doesn't fit the index, or is longer
+    // than a limit of the caller's own.
+    ErrNameTooLong = errors.New("name too long")
+    // ErrSizeOverflow is a VP that would be too big for the format's
+    // 32-bit offsets and sizes.
+    ErrSizeOverflow = errors.New("too big for a VP")
+)
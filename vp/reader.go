@@ -0,0 +1,203 @@
+package vp
+
+import (
+    "bytes"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "iter"
+    "path"
+    "strings"
+)
+
+// ReadTOC parses the header and index of the VP in r, which is size bytes
+// long. Entries come back in index order, with Offset filled in and Path
+// set to the entry's path inside the archive, rebuilt from
+// the directory markers. Markers that don't pair up are an error, as is
+// an index or a file's data that lies outside the file.
+//
+// Like the engine, any entry with a size of 0 is treated as a directory
+// marker, and one named ".." closes the current directory.
+//
+// Only the header and the index are read, in one ReadAt each, and no
+// file data, so r can be backed by something slow to reach, like HTTP
+// range requests. OpenEntry likewise reads only the entry asked for.
+func ReadTOC(r io.ReaderAt, size int64) ([]TOCEntry, error) {
+    out, err := ReadIndex(r, size)
+    if err != nil {
+        return nil, err
+    }
+    paths, err := ArchivePaths(out)
+    if err != nil {
+        return nil, err
+    }
+    for i, p := range paths {
+        out[i].Path = p
+    }
+    return out, nil
+}
+
+// ReadIndex is ReadTOC without working out the paths.
+//
+// The header's entry count is taken as the length of the index, so bytes
+// after the last entry, such as padding some tools add for alignment, are
+// ignored. A count that would run the index past the end of the file is
+// an error.
+func ReadIndex(r io.ReaderAt, size int64) ([]TOCEntry, error) {
+    if size < HeaderSize {
+        return nil, fmt.Errorf("file is %d bytes, too short for a %d byte header", size, HeaderSize)
+    }
+    header := make([]byte, HeaderSize)
+    if n, err := r.ReadAt(header, 0); err != nil && !(err == io.EOF && n == len(header)) {
+        return nil, fmt.Errorf("reading header: %w", err)
+    }
+    if string(header[0:4]) != Magic {
+        return nil, fmt.Errorf("bad magic %q, not a VP file", header[0:4])
+    }
+    version := int32(binary.LittleEndian.Uint32(header[4:8]))
+    if version < 0 || version >= implausibleHeaderValue {
+        return nil, fmt.Errorf("VP version %d is implausible, possibly wrong endianness or corrupt header (header bytes % x)", version, header)
+    }
+    if version != Version2 {
+        return nil, fmt.Errorf("unsupported VP version %d", version)
+    }
+    indexOffset := int32(binary.LittleEndian.Uint32(header[8:12]))
+    count := int32(binary.LittleEndian.Uint32(header[12:16]))
+    if indexOffset < HeaderSize {
+        return nil, fmt.Errorf("index offset %d is inside the header", indexOffset)
+    }
+    if count < 0 || count >= implausibleHeaderValue {
+        return nil, fmt.Errorf("entry count %d is implausible, possibly wrong endianness or corrupt header (header bytes % x)", count, header)
+    }
+    if int64(indexOffset) > size {
+        return nil, fmt.Errorf("index offset %d exceeds file size %d", indexOffset, size)
+    }
+    if indexEnd := int64(indexOffset) + int64(count) * IndexEntrySize; indexEnd > size {
+        return nil, fmt.Errorf("index of %d entries at %d runs to %d, past the end of the %d byte file", count, indexOffset, indexEnd, size)
+    }
+
+    // the whole index in one read, so a remote r costs one request for it.
+    // ReaderAt can give io.EOF with a full read that ends at the end of the
+    // file, which an index always does unless there's padding after it.
+    index := make([]byte, int64(count) * IndexEntrySize)
+    if n, err := r.ReadAt(index, int64(indexOffset)); err != nil && !(err == io.EOF && n == len(index)) {
+        return nil, fmt.Errorf("reading index of %d entries at %d: %w", count, indexOffset, err)
+    }
+    out := []TOCEntry{}
+    for i := int32(0); i < count; i++ {
+        entry := parseIndexEntry(index[i * IndexEntrySize:(i + 1) * IndexEntrySize])
+        if entry.Size < 0 {
+            return nil, fmt.Errorf("index entry %d (%v) has negative size %d", i, entry.Name, entry.Size)
+        }
+        // the data lives between the header and the index; directories
+        // have no data, so their offsets don't matter
+        end := int64(entry.Offset) + int64(entry.Size)
+        if !entry.IsDir && (entry.Offset < HeaderSize || end > int64(indexOffset)) {
+            return nil, fmt.Errorf("index entry %d (%v) covers bytes %d to %d, outside the data region %d to %d", i, entry.Name, entry.Offset, end, HeaderSize, indexOffset)
+        }
+        out = append(out, entry)
+    }
+    return out, nil
+}
+
+// ArchivePaths works out the path inside the archive of each entry in toc
+// by following the directory markers with a stack of the directories
+// open. A ".." marker's path is the directory it returns to.
+//
+// If the markers don't pair up, the error says where, but the best paths
+// that can be made out are still returned: a ".." with nothing open is
+// ignored, and directories left open are taken to close at the end.
+func ArchivePaths(toc []TOCEntry) ([]string, error) {
+    type openDir struct {
+        path string
+        index int
+    }
+    out := make([]string, len(toc))
+    stack := []openDir{}
+    dir := func() string {
+        if len(stack) == 0 {
+            return "."
+        }
+        return stack[len(stack) - 1].path
+    }
+    problems := []string{}
+    for i, entry := range toc {
+        switch {
+        case entry.IsDir && entry.Name == "..":
+            if len(stack) == 0 {
+                problems = append(problems, fmt.Sprintf("entry %d closes a directory when none is open", i))
+            } else {
+                stack = stack[:len(stack) - 1]
+            }
+            out[i] = dir()
+        case entry.IsDir:
+            stack = append(stack, openDir{path.Join(dir(), entry.Name), i})
+            out[i] = dir()
+        default:
+            out[i] = path.Join(dir(), entry.Name)
+        }
+    }
+    for _, d := range stack {
+        problems = append(problems, fmt.Sprintf("%v, opened at entry %d, is never closed", d.path, d.index))
+    }
+    if len(problems) > 0 {
+        return out, fmt.Errorf("unbalanced directory markers: %v", strings.Join(problems, "; "))
+    }
+    return out, nil
+}
+
+// parseIndexEntry decodes a single 44 byte index entry. The name runs up
+// to the first NUL; anything after it is padding.
+func parseIndexEntry(buf []byte) TOCEntry {
+    name := buf[8:8 + NameFieldSize]
+    if i := bytes.IndexByte(name, 0); i >= 0 {
+        name = name[:i]
+    }
+    size := int32(binary.LittleEndian.Uint32(buf[4:8]))
+    return TOCEntry{
+        Offset: int32(binary.LittleEndian.Uint32(buf[0:4])),
+        Size: size,
+        Name: string(name),
+        Timestamp: int32(binary.LittleEndian.Uint32(buf[40:44])),
+        IsDir: size == 0,
+    }
+}
+
+// OpenEntry gives access to the contents of a single entry from the VP in
+// r, as returned by ReadTOC, without reading anything up front. Reads and
+// seeks are confined to the entry, so it can serve arbitrary byte ranges.
+func OpenEntry(r io.ReaderAt, entry TOCEntry) *io.SectionReader {
+    return io.NewSectionReader(r, int64(entry.Offset), int64(entry.Size))
+}
+
+// Entry is an index entry from Entries, with its data to hand.
+type Entry struct {
+    TOCEntry
+    r io.ReaderAt
+}
+
+// Open is OpenEntry for e. Each call gives a reader of its own, so
+// entries can be read in any order, or at the same time.
+func (e Entry) Open() *io.SectionReader {
+    return OpenEntry(e.r, e.TOCEntry)
+}
+
+// Entries goes through the index of the VP in r, which is size bytes
+// long, as ReadTOC gives it, without reading any file data until an
+// entry's Open reader is read. Only the index is held, so memory stays
+// bounded however big the VP is. If the VP can't be read, the only thing
+// yielded is the error.
+func Entries(r io.ReaderAt, size int64) iter.Seq2[Entry, error] {
+    return func(yield func(Entry, error) bool) {
+        toc, err := ReadTOC(r, size)
+        if err != nil {
+            yield(Entry{}, err)
+            return
+        }
+        for _, entry := range toc {
+            if !yield(Entry{entry, r}, nil) {
+                return
+            }
+        }
+    }
+}
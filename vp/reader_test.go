@@ -0,0 +1,290 @@
+package vp
+
+import (
+    "bytes"
+    "encoding/binary"
+    "io"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+// name32 is a name field: name, its NUL, then pad to fill the 32 bytes.
+func name32(name string, pad byte) []byte {
+    return append(append([]byte(name), 0), bytes.Repeat([]byte{pad}, NameFieldSize - len(name) - 1)...)
+}
+
+func le32(v int32) []byte {
+    return binary.LittleEndian.AppendUint32(nil, uint32(v))
+}
+
+func concat(parts ...[]byte) []byte {
+    return bytes.Join(parts, nil)
+}
+
+// smallVP is data/a.tbl, holding "hi\n", laid out by hand: the header,
+// three bytes of data at 16, and an index of three entries at 19.
+var smallVP = concat(
+    []byte{'V', 'P', 'V', 'P', 2, 0, 0, 0, 19, 0, 0, 0, 3, 0, 0, 0},
+    []byte("hi\n"),
+    []byte{16, 0, 0, 0, 0, 0, 0, 0}, name32("data", 0), []byte{0, 0, 0, 0},
+    []byte{16, 0, 0, 0, 3, 0, 0, 0}, name32("a.tbl", 0), []byte{0x10, 0x27, 0, 0},
+    []byte{19, 0, 0, 0, 0, 0, 0, 0}, name32("..", 0), []byte{0, 0, 0, 0},
+)
+
+// unclosedVP is smallVP without the ".." closing data.
+var unclosedVP = concat(
+    []byte{'V', 'P', 'V', 'P', 2, 0, 0, 0, 19, 0, 0, 0, 2, 0, 0, 0},
+    []byte("hi\n"),
+    []byte{16, 0, 0, 0, 0, 0, 0, 0}, name32("data", 0), []byte{0, 0, 0, 0},
+    []byte{16, 0, 0, 0, 3, 0, 0, 0}, name32("a.tbl", 0), []byte{0x10, 0x27, 0, 0},
+)
+
+// strayCloseVP is a ".." with no directory open, then a.tbl.
+var strayCloseVP = concat(
+    []byte{'V', 'P', 'V', 'P', 2, 0, 0, 0, 19, 0, 0, 0, 2, 0, 0, 0},
+    []byte("hi\n"),
+    []byte{16, 0, 0, 0, 0, 0, 0, 0}, name32("..", 0), []byte{0, 0, 0, 0},
+    []byte{16, 0, 0, 0, 3, 0, 0, 0}, name32("a.tbl", 0), []byte{0x10, 0x27, 0, 0},
+)
+
+func readTOCBytes(b []byte) ([]TOCEntry, error) {
+    return ReadTOC(bytes.NewReader(b), int64(len(b)))
+}
+
+func TestReadTOC(t *testing.T) {
+    toc, err := readTOCBytes(smallVP)
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []TOCEntry{
+        {Offset: 16, Size: 0, Name: "data", Timestamp: 0, Path: "data", IsDir: true},
+        {Offset: 16, Size: 3, Name: "a.tbl", Timestamp: 10000, Path: "data/a.tbl"},
+        {Offset: 19, Size: 0, Name: "..", Timestamp: 0, Path: ".", IsDir: true},
+    }
+    if !reflect.DeepEqual(toc, want) {
+        t.Errorf("got %+v, want %+v", toc, want)
+    }
+    data, err := io.ReadAll(OpenEntry(bytes.NewReader(smallVP), toc[1]))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if string(data) != "hi\n" {
+        t.Errorf("a.tbl holds %q, want %q", data, "hi\n")
+    }
+}
+
+func TestReadTOCEmpty(t *testing.T) {
+    empty := []byte{'V', 'P', 'V', 'P', 2, 0, 0, 0, 16, 0, 0, 0, 0, 0, 0, 0}
+    toc, err := readTOCBytes(empty)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(toc) != 0 {
+        t.Errorf("got %d entries from an empty VP, want none", len(toc))
+    }
+}
+
+func TestReadTOCNamePadding(t *testing.T) {
+    // some tools fill the name field with junk after the NUL
+    vp := append([]byte{}, smallVP...)
+    copy(vp[19 + IndexEntrySize + 8:], name32("a.tbl", 0xcd))
+    toc, err := readTOCBytes(vp)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if toc[1].Name != "a.tbl" {
+        t.Errorf("name is %q, want %q", toc[1].Name, "a.tbl")
+    }
+}
+
+func TestReadTOCTrailingBytes(t *testing.T) {
+    // after the index, past the count the header gives: padding, junk that
+    // looks like more index entries, and a hash trailer
+    for name, trailing := range map[string][]byte{
+        "padding": {0, 0, 0, 0},
+        "garbage": []byte("\xde\xad\xbe\xef and some text"),
+        "index-like": concat([]byte{16, 0, 0, 0, 3, 0, 0, 0}, name32("b.tbl", 0), []byte{0, 0, 0, 0}),
+        "hash trailer": append([]byte("AZH1"), bytes.Repeat([]byte{0xab}, 32)...),
+    } {
+        vp := append(append([]byte{}, smallVP...), trailing...)
+        toc, err := readTOCBytes(vp)
+        if err != nil {
+            t.Errorf("%v: %v", name, err)
+            continue
+        }
+        paths := []string{}
+        for _, entry := range toc {
+            paths = append(paths, entry.Path)
+        }
+        if !reflect.DeepEqual(paths, []string{"data", "data/a.tbl", "."}) {
+            t.Errorf("%v: got %+v, want smallVP's three entries", name, toc)
+            continue
+        }
+        data, err := io.ReadAll(OpenEntry(bytes.NewReader(vp), toc[1]))
+        if err != nil || string(data) != "hi\n" {
+            t.Errorf("%v: a.tbl holds %q, %v", name, data, err)
+        }
+    }
+}
+
+// patched is smallVP with b written over it at offset.
+func patched(offset int, b []byte) []byte {
+    out := append([]byte{}, smallVP...)
+    copy(out[offset:], b)
+    return out
+}
+
+func TestReadTOCMalformed(t *testing.T) {
+    tests := []struct {
+        name string
+        vp []byte
+        want string
+    }{
+        {"empty file", []byte{}, "too short"},
+        {"short header", smallVP[:10], "too short"},
+        {"bad magic", patched(0, []byte("VPVQ")), "bad magic"},
+        {"old version", patched(4, le32(1)), "unsupported VP version 1"},
+        {"big-endian version", patched(4, []byte{0, 0, 0, 2}), "implausible"},
+        {"negative version", patched(4, le32(-1)), "implausible"},
+        {"index in header", patched(8, le32(8)), "inside the header"},
+        {"index past the end", patched(8, le32(1000)), "exceeds file size"},
+        {"big-endian count", patched(12, []byte{0, 0, 0, 3}), "implausible"},
+        {"negative count", patched(12, le32(-1)), "implausible"},
+        {"count past the end", patched(12, le32(4)), "past the end"},
+        {"truncated index", smallVP[:len(smallVP) - 1], "past the end"},
+        {"negative size", patched(19 + IndexEntrySize + 4, le32(-3)), "negative size"},
+        {"data in header", patched(19 + IndexEntrySize, le32(4)), "outside the data region"},
+        {"data into index", patched(19 + IndexEntrySize + 4, le32(4)), "outside the data region"},
+        {"unclosed directory", unclosedVP, "data, opened at entry 0, is never closed"},
+        {"stray close", strayCloseVP, "entry 0 closes a directory when none is open"},
+    }
+    for _, test := range tests {
+        _, err := readTOCBytes(test.vp)
+        if err == nil {
+            t.Errorf("%v: no error", test.name)
+            continue
+        }
+        if !strings.Contains(err.Error(), test.want) {
+            t.Errorf("%v: error %q doesn't mention %q", test.name, err, test.want)
+        }
+    }
+}
+
+func TestArchivePathsUnbalanced(t *testing.T) {
+    toc := []TOCEntry{
+        {Name: "..", IsDir: true},
+        {Name: "data", IsDir: true},
+        {Name: "a.tbl", Size: 3},
+    }
+    paths, err := ArchivePaths(toc)
+    if err == nil {
+        t.Fatal("no error for unbalanced markers")
+    }
+    for _, want := range []string{"entry 0 closes a directory when none is open", "data, opened at entry 1, is never closed"} {
+        if !strings.Contains(err.Error(), want) {
+            t.Errorf("error %q doesn't mention %q", err, want)
+        }
+    }
+    // the best paths that can be made out come back all the same
+    if want := []string{".", "data", "data/a.tbl"}; !reflect.DeepEqual(paths, want) {
+        t.Errorf("got paths %q, want %q", paths, want)
+    }
+}
+
+// markers is a TOC of the given names, where a name ending in / is a
+// directory, ".." a marker closing one, and anything else a file.
+func markers(names ...string) []TOCEntry {
+    toc := []TOCEntry{}
+    for _, name := range names {
+        switch {
+        case name == "..":
+            toc = append(toc, TOCEntry{Name: name, IsDir: true})
+        case strings.HasSuffix(name, "/"):
+            toc = append(toc, TOCEntry{Name: strings.TrimSuffix(name, "/"), IsDir: true})
+        default:
+            toc = append(toc, TOCEntry{Name: name, Size: 1})
+        }
+    }
+    return toc
+}
+
+func TestArchivePathsCorruptMarkers(t *testing.T) {
+    tests := []struct {
+        name string
+        toc []TOCEntry
+        paths []string
+        problems []string
+    }{
+        {"balanced", markers("data/", "maps/", "a.pof", "..", "..", "b.tbl"), []string{"data", "data/maps", "data/maps/a.pof", "data", ".", "b.tbl"}, nil},
+        {"one close too many", markers("data/", "a.tbl", "..", "..", "b.tbl"), []string{"data", "data/a.tbl", ".", ".", "b.tbl"}, []string{"entry 3 closes a directory when none is open"}},
+        {"closes before anything", markers("..", "..", "a.tbl"), []string{".", ".", "a.tbl"}, []string{"entry 0 closes", "entry 1 closes"}},
+        {"nested, none closed", markers("data/", "maps/", "a.pof"), []string{"data", "data/maps", "data/maps/a.pof"}, []string{"data/maps, opened at entry 1, is never closed", "data, opened at entry 0, is never closed"}},
+        {"inner one closed", markers("data/", "maps/", "..", "a.tbl"), []string{"data", "data/maps", "data", "data/a.tbl"}, []string{"data, opened at entry 0, is never closed"}},
+        {"stray close between balanced runs", markers("a/", "..", "..", "b/", "x", ".."), []string{"a", ".", ".", "b", "b/x", "."}, []string{"entry 2 closes"}},
+    }
+    for _, test := range tests {
+        paths, err := ArchivePaths(test.toc)
+        if !reflect.DeepEqual(paths, test.paths) {
+            t.Errorf("%v: got paths %q, want %q", test.name, paths, test.paths)
+        }
+        if test.problems == nil {
+            if err != nil {
+                t.Errorf("%v: %v", test.name, err)
+            }
+            continue
+        }
+        if err == nil {
+            t.Errorf("%v: no error", test.name)
+            continue
+        }
+        for _, want := range test.problems {
+            if !strings.Contains(err.Error(), want) {
+                t.Errorf("%v: error %q doesn't mention %q", test.name, err, want)
+            }
+        }
+    }
+}
+
+func TestEntries(t *testing.T) {
+    names := []string{}
+    for entry, err := range Entries(bytes.NewReader(smallVP), int64(len(smallVP))) {
+        if err != nil {
+            t.Fatal(err)
+        }
+        names = append(names, entry.Path)
+        if entry.Name == "a.tbl" {
+            data, err := io.ReadAll(entry.Open())
+            if err != nil {
+                t.Fatal(err)
+            }
+            if string(data) != "hi\n" {
+                t.Errorf("a.tbl holds %q, want %q", data, "hi\n")
+            }
+        }
+    }
+    if want := []string{"data", "data/a.tbl", "."}; !reflect.DeepEqual(names, want) {
+        t.Errorf("got %q, want %q", names, want)
+    }
+
+    // stopping early stops the iteration
+    n := 0
+    for range Entries(bytes.NewReader(smallVP), int64(len(smallVP))) {
+        n++
+        break
+    }
+    if n != 1 {
+        t.Errorf("went through %d entries after breaking at the first", n)
+    }
+
+    errs := 0
+    for _, err := range Entries(bytes.NewReader(smallVP[:10]), 10) {
+        if err == nil {
+            t.Error("got an entry from a truncated VP")
+        }
+        errs++
+    }
+    if errs != 1 {
+        t.Errorf("got %d errors from a truncated VP, want 1", errs)
+    }
+}
@@ -0,0 +1,53 @@
+// Package vp reads and writes VP archives, the format FreeSpace 2 and its
+// engine load their data from.
+//
+// A VP is a 16 byte header, then the data of every file one after another,
+// then an index of 44 byte entries, all little-endian:
+//
+//  header: "VPVP", the version (2), the index's offset, its entry count
+//  entry:  data offset (4), size (4), name (32, NUL terminated), timestamp (4)
+//
+// The index describes a tree: an entry with a size of 0 opens a directory,
+// which the ones after it are in, until one named ".." closes it again.
+// ReadTOC follows those markers to give each entry its path; Writer writes
+// a VP an entry at a time.
+package vp
+
+// Every VP starts with Magic, then the format version, which is always
+// Version2: it's the only one the engine has loaded.
+const (
+    Magic = "VPVP"
+    Version2 = 2
+)
+
+const (
+    HeaderSize = 16
+    // offset (4) + size (4) + name (32) + timestamp (4)
+    IndexEntrySize = 44
+    NameFieldSize = 32
+    // MaxNameLength is the longest name that fits the name field along
+    // with its terminating NUL.
+    MaxNameLength = NameFieldSize - 1
+    // header values at or above this are taken as a sign the header was
+    // written big-endian, or is corrupt
+    implausibleHeaderValue = 1 << 24
+)
+
+// TOCEntry is an entry in a VP's index.
+type TOCEntry struct {
+    // Offset is where the entry's data starts in the VP. ReadTOC fills it
+    // in; it's not needed to write one, as writers work offsets out as
+    // they go.
+    Offset int32
+    Size int32
+    Name string
+    // Timestamp is the file's modification time, in seconds since 1970.
+    Timestamp int32
+    // Path is the entry's path: inside the archive, for an entry ReadTOC
+    // gives, or of the file it's to be packed from, for one that's about
+    // to be written.
+    Path string
+    // IsDir is set for directory markers, ".." included. The format has
+    // nothing to say so but a size of 0.
+    IsDir bool
+}
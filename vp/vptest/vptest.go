@@ -0,0 +1,149 @@
+// Package vptest builds and reads VPs in memory, for tests that would
+// rather not go through temporary directories.
+package vptest
+
+import (
+    "bytes"
+    "fmt"
+    "path"
+    "sort"
+    "strings"
+
+    "github.com/tcrayford/aztech/vp"
+)
+
+// node is a directory or file in the tree BuildArchive lays out.
+type node struct {
+    children map[string]*node
+    content []byte
+}
+
+func (n *node) sortedNames() []string {
+    names := []string{}
+    for name := range n.children {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// BuildArchive packs entries, file contents keyed by their path inside
+// the archive, into a VP in memory, laid out as a pack of the same tree
+// would be: the children of each directory sorted by name, with the data
+// in index order. Timestamps are all 0.
+//
+// An empty file is an error rather than being left out or packed: the
+// engine takes any zero-size entry for a directory, so it couldn't come
+// back out of ParseArchive.
+func BuildArchive(entries map[string][]byte) ([]byte, error) {
+    root := &node{children: map[string]*node{}}
+    for p, content := range entries {
+        if p == "" || path.IsAbs(p) || path.Clean(p) != p || p == "." || strings.HasPrefix(p, "../") || p == ".." {
+            return nil, fmt.Errorf("vptest: %q isn't a clean relative path", p)
+        }
+        if len(content) == 0 {
+            return nil, fmt.Errorf("vptest: %v is empty; the engine takes a zero-size entry for a directory, so it can't be stored", p)
+        }
+        dir := root
+        parts := strings.Split(p, "/")
+        for _, part := range parts[:len(parts) - 1] {
+            child, ok := dir.children[part]
+            if !ok {
+                child = &node{children: map[string]*node{}}
+                dir.children[part] = child
+            }
+            if child.children == nil {
+                return nil, fmt.Errorf("vptest: %v is under %v, which is a file", p, part)
+            }
+            dir = child
+        }
+        name := parts[len(parts) - 1]
+        if _, ok := dir.children[name]; ok {
+            return nil, fmt.Errorf("vptest: %v is a directory as well as a file", p)
+        }
+        dir.children[name] = &node{content: content}
+    }
+
+    // count up the index and the data first, for the header
+    var count, totalSize int32
+    var measure func(n *node)
+    measure = func(n *node) {
+        for _, name := range n.sortedNames() {
+            child := n.children[name]
+            if child.children == nil {
+                count++
+                totalSize += int32(len(child.content))
+                continue
+            }
+            count += 2
+            measure(child)
+        }
+    }
+    measure(root)
+
+    var b bytes.Buffer
+    w := vp.NewWriter(&b)
+    if err := w.WriteHeader(count, totalSize); err != nil {
+        return nil, err
+    }
+    type indexEntry struct {
+        name string
+        offset int32
+        size int32
+    }
+    index := []indexEntry{}
+    var write func(n *node) error
+    write = func(n *node) error {
+        for _, name := range n.sortedNames() {
+            child := n.children[name]
+            offset := w.Offset()
+            if child.children == nil {
+                if _, err := w.WriteFileData(bytes.NewReader(child.content)); err != nil {
+                    return err
+                }
+                index = append(index, indexEntry{name, offset, int32(len(child.content))})
+                continue
+            }
+            index = append(index, indexEntry{name, offset, 0})
+            if err := write(child); err != nil {
+                return err
+            }
+            index = append(index, indexEntry{"..", w.Offset(), 0})
+        }
+        return nil
+    }
+    if err := write(root); err != nil {
+        return nil, err
+    }
+    for _, entry := range index {
+        if err := w.WriteIndexEntry(entry.name, entry.offset, entry.size, 0); err != nil {
+            return nil, err
+        }
+    }
+    if err := w.Close(); err != nil {
+        return nil, err
+    }
+    return b.Bytes(), nil
+}
+
+// ParseArchive is the reverse of BuildArchive: it reads the VP in b and
+// returns the contents of each file in it, keyed by path.
+func ParseArchive(b []byte) (map[string][]byte, error) {
+    r := bytes.NewReader(b)
+    toc, err := vp.ReadTOC(r, int64(len(b)))
+    if err != nil {
+        return nil, err
+    }
+    out := map[string][]byte{}
+    for _, entry := range toc {
+        if entry.IsDir {
+            continue
+        }
+        content := make([]byte, entry.Size)
+        if _, err := vp.OpenEntry(r, entry).ReadAt(content, 0); err != nil {
+            return nil, err
+        }
+        out[entry.Path] = content
+    }
+    return out, nil
+}
@@ -0,0 +1,68 @@
+package vptest
+
+import (
+    "bytes"
+    "reflect"
+    "strings"
+    "testing"
+
+    "github.com/tcrayford/aztech/vp"
+)
+
+func TestRoundTrip(t *testing.T) {
+    entries := map[string][]byte{
+        "data/tables/ships.tbl": []byte("ships"),
+        "data/maps/b.pof": []byte("bbb"),
+        "data/a.tbl": []byte("a"),
+        "top.txt": []byte("top"),
+    }
+    b, err := BuildArchive(entries)
+    if err != nil {
+        t.Fatal(err)
+    }
+    got, err := ParseArchive(b)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !reflect.DeepEqual(got, entries) {
+        t.Errorf("got %q, want %q", got, entries)
+    }
+
+    toc, err := vp.ReadTOC(bytes.NewReader(b), int64(len(b)))
+    if err != nil {
+        t.Fatal(err)
+    }
+    paths := []string{}
+    for _, entry := range toc {
+        paths = append(paths, entry.Path)
+    }
+    want := []string{"data", "data/a.tbl", "data/maps", "data/maps/b.pof", "data", "data/tables", "data/tables/ships.tbl", "data", ".", "top.txt"}
+    if !reflect.DeepEqual(paths, want) {
+        t.Errorf("laid out as %q, want %q", paths, want)
+    }
+}
+
+func TestBuildArchiveErrors(t *testing.T) {
+    tests := []struct {
+        name string
+        entries map[string][]byte
+        want string
+    }{
+        {"empty file", map[string][]byte{"data/empty.tbl": {}}, "is empty"},
+        {"absolute path", map[string][]byte{"/data/a.tbl": []byte("a")}, "clean relative path"},
+        {"unclean path", map[string][]byte{"data//a.tbl": []byte("a")}, "clean relative path"},
+        {"outside the root", map[string][]byte{"../a.tbl": []byte("a")}, "clean relative path"},
+        {"file and directory", map[string][]byte{"data/a": []byte("a"), "data/a/b": []byte("b")}, "file"},
+        {"name too long", map[string][]byte{"data/" + strings.Repeat("a", vp.MaxNameLength + 1): []byte("a")}, "too long"},
+    }
+    for _, test := range tests {
+        _, err := BuildArchive(test.entries)
+        if err == nil {
+            t.Errorf("%v: no error", test.name)
+            continue
+        }
+        if !strings.Contains(err.Error(), test.want) {
+            t.Errorf("%v: error %q doesn't mention %q", test.name, err, test.want)
+        }
+    }
+}
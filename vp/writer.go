@@ -0,0 +1,193 @@
+package vp
+
+import (
+    "bytes"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "math"
+    "strings"
+)
+
+// EncodeHeader is the 16 byte header of a VP with count index entries,
+// the index starting at indexOffset.
+func EncodeHeader(indexOffset int32, count int32) []byte {
+    buf := make([]byte, HeaderSize)
+    copy(buf, Magic)
+    binary.LittleEndian.PutUint32(buf[4:], uint32(Version2))
+    binary.LittleEndian.PutUint32(buf[8:], uint32(indexOffset))
+    binary.LittleEndian.PutUint32(buf[12:], uint32(count))
+    return buf
+}
+
+// EncodeIndexEntry is one 44 byte index entry. The name field is filled
+// with pad after the NUL ending name, which has to fit before it.
+func EncodeIndexEntry(name string, offset int32, size int32, timestamp int32, pad byte) []byte {
+    buf := make([]byte, 0, IndexEntrySize)
+    buf = binary.LittleEndian.AppendUint32(buf, uint32(offset))
+    buf = binary.LittleEndian.AppendUint32(buf, uint32(size))
+    buf = append(buf, name...)
+    buf = append(buf, 0)
+    buf = append(buf, bytes.Repeat([]byte{pad}, NameFieldSize - (len(name) + 1))...)
+    return binary.LittleEndian.AppendUint32(buf, uint32(timestamp))
+}
+
+// Writer assembles a VP an entry at a time, for building one from
+// something other than files on disk, like generated content. The calls
+// have to come in the order the format lays things out:
+//
+//  1. WriteHeader, once, with how many index entries there'll be and
+//     the total bytes of file data.
+//  2. WriteFileData for each file's data, one after another. Offset,
+//     called before, says where the data will be, for its index entry.
+//  3. WriteIndexEntry for every entry, in index order: files, and the
+//     directory markers around them, which have a size of 0 (see
+//     ReadTOC). Files' data needn't be in the same order as the index.
+//  4. Close, which checks the header's figures came out right.
+//
+// Anything out of order fails, as does every call after one that has.
+// Writer doesn't check the index makes sense; ReadTOC does, when it's
+// read back.
+type Writer struct {
+    cw *CountingWriter
+    // NamePad fills name fields after the NUL ending each name; 0 unless
+    // set, as the engine expects.
+    NamePad byte
+    headerDone bool
+    count int32
+    totalSize int32
+    entries int32
+    err error
+}
+
+// NewWriter returns a Writer writing a VP to w.
+func NewWriter(w io.Writer) *Writer {
+    return &Writer{cw: &CountingWriter{W: w}}
+}
+
+// fail records err as the first error, if there isn't one, and returns
+// whichever that is.
+func (w *Writer) fail(err error) error {
+    if w.err == nil {
+        w.err = err
+    }
+    return w.err
+}
+
+// WriteHeader writes the header of a VP with count index entries and
+// totalSize bytes of file data.
+func (w *Writer) WriteHeader(count int32, totalSize int32) error {
+    if w.err != nil {
+        return w.err
+    }
+    if w.headerDone {
+        return w.fail(fmt.Errorf("header written twice"))
+    }
+    if count < 0 || totalSize < 0 || totalSize > math.MaxInt32 - HeaderSize {
+        return w.fail(fmt.Errorf("%w: header of %d entries and %d bytes of data won't fit the format", ErrSizeOverflow, count, totalSize))
+    }
+    w.headerDone = true
+    w.count, w.totalSize = count, totalSize
+    w.cw.Write(EncodeHeader(totalSize + HeaderSize, count))
+    if w.cw.Err != nil {
+        return w.fail(w.cw.Err)
+    }
+    return nil
+}
+
+// Offset is the offset the data from the next WriteFileData goes at.
+func (w *Writer) Offset() int32 {
+    return int32(w.cw.N)
+}
+
+// WriteFileData copies everything in r into the VP as a file's data, and
+// returns how many bytes that was. It fails if that's more than the
+// header left room for.
+func (w *Writer) WriteFileData(r io.Reader) (int64, error) {
+    if w.err != nil {
+        return 0, w.err
+    }
+    if !w.headerDone {
+        return 0, w.fail(fmt.Errorf("file data written before the header"))
+    }
+    if w.entries > 0 {
+        return 0, w.fail(fmt.Errorf("file data written after the index was started"))
+    }
+    room := int64(w.totalSize) + HeaderSize - w.cw.N
+    size, err := io.Copy(w.cw, io.LimitReader(r, room + 1))
+    if err != nil {
+        return size, w.fail(err)
+    }
+    if size > room {
+        return size, w.fail(fmt.Errorf("file data runs past the %d bytes the header gives", w.totalSize))
+    }
+    return size, nil
+}
+
+// WriteIndexEntry writes the next index entry, once all the file data is
+// written. name is the entry's name alone, not a path; a size of 0 makes
+// it a directory marker, or with a name of "..", the end of one.
+func (w *Writer) WriteIndexEntry(name string, offset int32, size int32, ts int32) error {
+    if w.err != nil {
+        return w.err
+    }
+    if !w.headerDone {
+        return w.fail(fmt.Errorf("index entry written before the header"))
+    }
+    if w.entries == 0 && w.cw.N != int64(w.totalSize) + HeaderSize {
+        return w.fail(fmt.Errorf("index entry written after %d bytes of file data, but the header gives %d", w.cw.N - HeaderSize, w.totalSize))
+    }
+    if w.entries == w.count {
+        return w.fail(fmt.Errorf("more index entries than the %d the header gives", w.count))
+    }
+    if len(name) > MaxNameLength {
+        return w.fail(fmt.Errorf("%w: index entry name %q is %d bytes, more than the %d that fit", ErrNameTooLong, name, len(name), MaxNameLength))
+    }
+    if strings.ContainsAny(name, "\x00/\\") {
+        return w.fail(fmt.Errorf("index entry name %q has a NUL or path separator in it", name))
+    }
+    if offset < HeaderSize || size < 0 || int64(offset) + int64(size) > int64(w.totalSize) + HeaderSize {
+        return w.fail(fmt.Errorf("index entry %q has data at %d, %d bytes long, outside the file data", name, offset, size))
+    }
+    w.entries++
+    w.cw.Write(EncodeIndexEntry(name, offset, size, ts, w.NamePad))
+    if w.cw.Err != nil {
+        return w.fail(w.cw.Err)
+    }
+    return nil
+}
+
+// Close checks every index entry the header gave was written. It doesn't
+// close what the VP was written to.
+func (w *Writer) Close() error {
+    if w.err != nil {
+        return w.err
+    }
+    if !w.headerDone {
+        return w.fail(fmt.Errorf("closed before the header was written"))
+    }
+    if w.entries != w.count {
+        return w.fail(fmt.Errorf("%d index entries written, but the header gives %d", w.entries, w.count))
+    }
+    return nil
+}
+
+// CountingWriter counts the bytes written through it to W in N. It also
+// holds on to the first error in Err, after which every write fails, so a
+// run of writes only needs checking once at the end. Writer uses one, as
+// do writers of VPs that need to move N back to write something again.
+type CountingWriter struct {
+    W io.Writer
+    N int64
+    Err error
+}
+
+func (c *CountingWriter) Write(p []byte) (int, error) {
+    if c.Err != nil {
+        return 0, c.Err
+    }
+    n, err := c.W.Write(p)
+    c.N += int64(n)
+    c.Err = err
+    return n, err
+}
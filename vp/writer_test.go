@@ -0,0 +1,222 @@
+package vp
+
+import (
+    "bytes"
+    "errors"
+    "strings"
+    "testing"
+)
+
+// writeSmallVP writes smallVP's entries through a Writer to w.
+func writeSmallVP(w *Writer) error {
+    if err := w.WriteHeader(3, 3); err != nil {
+        return err
+    }
+    offset := w.Offset()
+    if _, err := w.WriteFileData(strings.NewReader("hi\n")); err != nil {
+        return err
+    }
+    end := w.Offset()
+    if err := w.WriteIndexEntry("data", offset, 0, 0); err != nil {
+        return err
+    }
+    if err := w.WriteIndexEntry("a.tbl", offset, 3, 10000); err != nil {
+        return err
+    }
+    if err := w.WriteIndexEntry("..", end, 0, 0); err != nil {
+        return err
+    }
+    return w.Close()
+}
+
+func TestWriter(t *testing.T) {
+    buf := &bytes.Buffer{}
+    if err := writeSmallVP(NewWriter(buf)); err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(buf.Bytes(), smallVP) {
+        t.Errorf("got\n% x\nwant\n% x", buf.Bytes(), smallVP)
+    }
+}
+
+func TestWriterNamePad(t *testing.T) {
+    buf := &bytes.Buffer{}
+    w := NewWriter(buf)
+    w.NamePad = 0xcd
+    if err := writeSmallVP(w); err != nil {
+        t.Fatal(err)
+    }
+    field := buf.Bytes()[19 + IndexEntrySize + 8:19 + IndexEntrySize + 8 + NameFieldSize]
+    if want := name32("a.tbl", 0xcd); !bytes.Equal(field, want) {
+        t.Errorf("name field is % x, want % x", field, want)
+    }
+    toc, err := readTOCBytes(buf.Bytes())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if toc[1].Path != "data/a.tbl" {
+        t.Errorf("read back %q, want data/a.tbl", toc[1].Path)
+    }
+}
+
+func TestEncodeHeader(t *testing.T) {
+    got := EncodeHeader(19, 3)
+    if !bytes.Equal(got, smallVP[:HeaderSize]) {
+        t.Errorf("got % x, want % x", got, smallVP[:HeaderSize])
+    }
+    if string(got[:4]) != Magic || got[4] != Version2 {
+        t.Errorf("header % x doesn't start with %q and version %d", got, Magic, Version2)
+    }
+}
+
+func TestEncodeIndexEntry(t *testing.T) {
+    got := EncodeIndexEntry("a.tbl", 16, 3, 10000, 0)
+    want := smallVP[19 + IndexEntrySize:19 + 2 * IndexEntrySize]
+    if !bytes.Equal(got, want) {
+        t.Errorf("got % x, want % x", got, want)
+    }
+}
+
+// failingWriter takes n bytes, then fails.
+type failingWriter struct {
+    n int
+}
+
+var errWrite = errors.New("disk full")
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+    if len(p) > f.n {
+        n := f.n
+        f.n = 0
+        return n, errWrite
+    }
+    f.n -= len(p)
+    return len(p), nil
+}
+
+func TestWriterErrors(t *testing.T) {
+    tests := []struct {
+        name string
+        write func(w *Writer) error
+        want string
+    }{
+        {"data before header", func(w *Writer) error {
+            _, err := w.WriteFileData(strings.NewReader("hi\n"))
+            return err
+        }, "before the header"},
+        {"index before header", func(w *Writer) error {
+            return w.WriteIndexEntry("a.tbl", 16, 3, 0)
+        }, "before the header"},
+        {"close before header", func(w *Writer) error {
+            return w.Close()
+        }, "before the header"},
+        {"header twice", func(w *Writer) error {
+            w.WriteHeader(1, 3)
+            return w.WriteHeader(1, 3)
+        }, "twice"},
+        {"negative count", func(w *Writer) error {
+            return w.WriteHeader(-1, 3)
+        }, "won't fit"},
+        {"data past the header's total", func(w *Writer) error {
+            w.WriteHeader(1, 2)
+            _, err := w.WriteFileData(strings.NewReader("hi\n"))
+            return err
+        }, "runs past"},
+        {"index before all the data", func(w *Writer) error {
+            w.WriteHeader(1, 3)
+            w.WriteFileData(strings.NewReader("hi"))
+            return w.WriteIndexEntry("a.tbl", 16, 2, 0)
+        }, "after 2 bytes of file data, but the header gives 3"},
+        {"data after the index", func(w *Writer) error {
+            w.WriteHeader(2, 3)
+            w.WriteFileData(strings.NewReader("hi\n"))
+            w.WriteIndexEntry("a.tbl", 16, 3, 0)
+            _, err := w.WriteFileData(strings.NewReader("x"))
+            return err
+        }, "after the index was started"},
+        {"too many entries", func(w *Writer) error {
+            w.WriteHeader(1, 3)
+            w.WriteFileData(strings.NewReader("hi\n"))
+            w.WriteIndexEntry("a.tbl", 16, 3, 0)
+            return w.WriteIndexEntry("b.tbl", 16, 3, 0)
+        }, "more index entries than the 1"},
+        {"too few entries", func(w *Writer) error {
+            w.WriteHeader(2, 3)
+            w.WriteFileData(strings.NewReader("hi\n"))
+            w.WriteIndexEntry("a.tbl", 16, 3, 0)
+            return w.Close()
+        }, "1 index entries written, but the header gives 2"},
+        {"path in name", func(w *Writer) error {
+            w.WriteHeader(1, 3)
+            w.WriteFileData(strings.NewReader("hi\n"))
+            return w.WriteIndexEntry("data/a.tbl", 16, 3, 0)
+        }, "path separator"},
+        {"backslash in name", func(w *Writer) error {
+            w.WriteHeader(1, 3)
+            w.WriteFileData(strings.NewReader("hi\n"))
+            return w.WriteIndexEntry(`data\a.tbl`, 16, 3, 0)
+        }, "path separator"},
+        {"NUL in name", func(w *Writer) error {
+            w.WriteHeader(1, 3)
+            w.WriteFileData(strings.NewReader("hi\n"))
+            return w.WriteIndexEntry("a\x00.tbl", 16, 3, 0)
+        }, "NUL"},
+        {"entry in the header", func(w *Writer) error {
+            w.WriteHeader(1, 3)
+            w.WriteFileData(strings.NewReader("hi\n"))
+            return w.WriteIndexEntry("a.tbl", 8, 3, 0)
+        }, "outside the file data"},
+        {"entry past the data", func(w *Writer) error {
+            w.WriteHeader(1, 3)
+            w.WriteFileData(strings.NewReader("hi\n"))
+            return w.WriteIndexEntry("a.tbl", 17, 3, 0)
+        }, "outside the file data"},
+        {"first error sticks", func(w *Writer) error {
+            w.WriteFileData(strings.NewReader("hi\n"))
+            w.WriteHeader(0, 0)
+            return w.Close()
+        }, "file data written before the header"},
+    }
+    for _, test := range tests {
+        err := test.write(NewWriter(&bytes.Buffer{}))
+        if err == nil {
+            t.Errorf("%v: no error", test.name)
+            continue
+        }
+        if !strings.Contains(err.Error(), test.want) {
+            t.Errorf("%v: error %q doesn't mention %q", test.name, err, test.want)
+        }
+    }
+}
+
+func TestWriterNameTooLong(t *testing.T) {
+    w := NewWriter(&bytes.Buffer{})
+    w.WriteHeader(1, 0)
+    // the longest name that fits is fine; one more byte isn't
+    if err := w.WriteIndexEntry(strings.Repeat("a", MaxNameLength), HeaderSize, 0, 0); err != nil {
+        t.Fatal(err)
+    }
+    w = NewWriter(&bytes.Buffer{})
+    w.WriteHeader(1, 0)
+    err := w.WriteIndexEntry(strings.Repeat("a", MaxNameLength + 1), HeaderSize, 0, 0)
+    if !errors.Is(err, ErrNameTooLong) {
+        t.Errorf("got %v, want ErrNameTooLong", err)
+    }
+}
+
+func TestWriterSizeOverflow(t *testing.T) {
+    err := NewWriter(&bytes.Buffer{}).WriteHeader(1, 1 << 31 - 1)
+    if !errors.Is(err, ErrSizeOverflow) {
+        t.Errorf("got %v, want ErrSizeOverflow", err)
+    }
+}
+
+func TestWriterWriteError(t *testing.T) {
+    // fails part way through the data, then in the index
+    for _, n := range []int{0, 10, 17, 19 + 50} {
+        err := writeSmallVP(NewWriter(&failingWriter{n}))
+        if !errors.Is(err, errWrite) {
+            t.Errorf("failing after %d bytes: got %v, want the write's error", n, err)
+        }
+    }
+}
@@ -0,0 +1,1036 @@
+package aztech
+
+import (
+    "bufio"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "math/bits"
+)
+
+// Zstandard, as RFC 8878 describes it: enough to read any frame the zstd
+// tool writes, short of ones needing a dictionary, and to write frames it
+// reads (see zstdWriter). Like blake2b.go, it's here rather than pulled in
+// as a dependency so aztech still builds from the standard library alone.
+const (
+    zstdFrameMagic = 0xfd2fb528
+    // skippable frames have any magic from here to zstdSkippableMagic+15
+    zstdSkippableMagic = 0x184d2a50
+    zstdMaxBlockSize = 128 << 10
+    // the largest window that's read, as with zstd's own default limit;
+    // it's how much of what's been decompressed has to be held
+    zstdMaxWindowLog = 27
+)
+
+var errZstdCorrupt = errors.New("corrupt zstd data")
+
+// zstdCorrupt is errZstdCorrupt, saying what's wrong.
+func zstdCorrupt(format string, args ...interface{}) error {
+    return fmt.Errorf("%w: %s", errZstdCorrupt, fmt.Sprintf(format, args...))
+}
+
+// The codes sequences' literal and match lengths are sent as: each is a
+// baseline, with that many extra bits added on.
+var zstdLitLengthBase = [36]uint32{
+    0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+    16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+    8192, 16384, 32768, 65536,
+}
+
+var zstdLitLengthBits = [36]uint8{
+    0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+    1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+    13, 14, 15, 16,
+}
+
+var zstdMatchLengthBase = [53]uint32{
+    3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+    19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+    35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+    4099, 8195, 16387, 32771, 65539,
+}
+
+var zstdMatchLengthBits = [53]uint8{
+    0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+    0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+    1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+    12, 13, 14, 15, 16,
+}
+
+// The distributions the predefined sequence code tables are built from,
+// where -1 is a symbol less likely than the others.
+var zstdLitLengthDefault = []int16{
+    4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+    2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+    -1, -1, -1, -1,
+}
+
+var zstdMatchLengthDefault = []int16{
+    1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+    1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+    1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+    -1, -1, -1, -1, -1,
+}
+
+var zstdOffsetDefault = []int16{
+    1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+    1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+}
+
+const (
+    zstdLitLengthDefaultLog = 6
+    zstdMatchLengthDefaultLog = 6
+    zstdOffsetDefaultLog = 5
+)
+
+// fseSpread lays out the symbols of the distribution norm, which sums to
+// 1<<accuracyLog, over a table of that size, as both ends of FSE do.
+func fseSpread(norm []int16, accuracyLog uint) []uint8 {
+    size := 1 << accuracyLog
+    symbols := make([]uint8, size)
+    high := size - 1
+    for s, n := range norm {
+        if n == -1 {
+            symbols[high] = uint8(s)
+            high--
+        }
+    }
+    step := size >> 1 + size >> 3 + 3
+    pos := 0
+    for s, n := range norm {
+        for i := 0; i < int(n); i++ {
+            symbols[pos] = uint8(s)
+            pos = (pos + step) & (size - 1)
+            for pos > high {
+                pos = (pos + step) & (size - 1)
+            }
+        }
+    }
+    return symbols
+}
+
+// fseDecodeEntry is one state of an FSE decoding table: the symbol it
+// gives, and how to get to the next state, which is baseline plus the
+// next nbBits bits read.
+type fseDecodeEntry struct {
+    symbol uint8
+    nbBits uint8
+    baseline uint16
+}
+
+type fseDecodeTable struct {
+    accuracyLog uint
+    entries []fseDecodeEntry
+}
+
+func newFSEDecodeTable(norm []int16, accuracyLog uint) *fseDecodeTable {
+    size := 1 << accuracyLog
+    symbols := fseSpread(norm, accuracyLog)
+    next := make([]int, len(norm))
+    for s, n := range norm {
+        if n == -1 {
+            next[s] = 1
+        } else {
+            next[s] = int(n)
+        }
+    }
+    entries := make([]fseDecodeEntry, size)
+    for u, s := range symbols {
+        state := next[s]
+        next[s]++
+        nbBits := int(accuracyLog) - (bits.Len(uint(state)) - 1)
+        entries[u] = fseDecodeEntry{s, uint8(nbBits), uint16(state << nbBits - size)}
+    }
+    return &fseDecodeTable{accuracyLog, entries}
+}
+
+// rleDecodeTable is the table of a sequence code that's always symbol.
+func rleDecodeTable(symbol uint8) *fseDecodeTable {
+    return &fseDecodeTable{0, []fseDecodeEntry{{symbol, 0, 0}}}
+}
+
+var (
+    zstdLitLengthDefaultTable = newFSEDecodeTable(zstdLitLengthDefault, zstdLitLengthDefaultLog)
+    zstdMatchLengthDefaultTable = newFSEDecodeTable(zstdMatchLengthDefault, zstdMatchLengthDefaultLog)
+    zstdOffsetDefaultTable = newFSEDecodeTable(zstdOffsetDefault, zstdOffsetDefaultLog)
+)
+
+// forwardBits reads bits from the start of b, lowest bit of each byte
+// first, as FSE table descriptions are written.
+type forwardBits struct {
+    b []byte
+    pos int
+}
+
+func (r *forwardBits) read(n int) (uint32, error) {
+    if r.pos + n > len(r.b) * 8 {
+        return 0, zstdCorrupt("table description runs past its block")
+    }
+    var v uint32
+    for i := 0; i < n; i++ {
+        bit := r.b[(r.pos + i) >> 3] >> uint((r.pos + i) & 7) & 1
+        v |= uint32(bit) << uint(i)
+    }
+    r.pos += n
+    return v, nil
+}
+
+// readFSETable reads an FSE table description from the start of b, for
+// symbols up to maxSymbol and an accuracy log up to maxLog, returning the
+// table and how many bytes the description took.
+func readFSETable(b []byte, maxSymbol int, maxLog uint) (*fseDecodeTable, int, error) {
+    r := &forwardBits{b: b}
+    v, err := r.read(4)
+    if err != nil {
+        return nil, 0, err
+    }
+    accuracyLog := uint(v) + 5
+    if accuracyLog > maxLog {
+        return nil, 0, zstdCorrupt("FSE accuracy log %d is over the limit of %d", accuracyLog, maxLog)
+    }
+    remaining := 1 << accuracyLog
+    norm := []int16{}
+    for remaining > 0 {
+        if len(norm) > maxSymbol {
+            return nil, 0, zstdCorrupt("FSE table has symbols past %d", maxSymbol)
+        }
+        nbBits := bits.Len(uint(remaining + 1))
+        val, err := r.read(nbBits)
+        if err != nil {
+            return nil, 0, err
+        }
+        lowerMask := uint32(1) << uint(nbBits - 1) - 1
+        threshold := uint32(1) << uint(nbBits) - 1 - uint32(remaining + 1)
+        if val & lowerMask < threshold {
+            // a small value, in a bit fewer
+            r.pos--
+            val &= lowerMask
+        } else if val > lowerMask {
+            val -= threshold
+        }
+        count := int16(val) - 1
+        if count < 0 {
+            remaining -= int(-count)
+        } else {
+            remaining -= int(count)
+        }
+        norm = append(norm, count)
+        if count == 0 {
+            for {
+                repeat, err := r.read(2)
+                if err != nil {
+                    return nil, 0, err
+                }
+                for i := uint32(0); i < repeat; i++ {
+                    norm = append(norm, 0)
+                }
+                if repeat != 3 {
+                    break
+                }
+            }
+        }
+    }
+    if remaining != 0 || len(norm) > maxSymbol + 1 {
+        return nil, 0, zstdCorrupt("FSE table's probabilities don't add up")
+    }
+    return newFSEDecodeTable(norm, accuracyLog), (r.pos + 7) / 8, nil
+}
+
+// backwardBits reads a bitstream from its end, where a 1 bit marks where
+// the bits start, back towards its beginning, as FSE and Huffman coded
+// streams are written. Reading past the beginning gives 0 bits, which is
+// how a stream that's been read too far is told apart (see overread).
+type backwardBits struct {
+    b []byte
+    // how many bits there are left to read
+    left int
+}
+
+func newBackwardBits(b []byte) (*backwardBits, error) {
+    if len(b) == 0 || b[len(b) - 1] == 0 {
+        return nil, zstdCorrupt("bitstream has no start marker")
+    }
+    return &backwardBits{b, (len(b) - 1) * 8 + bits.Len8(b[len(b) - 1]) - 1}, nil
+}
+
+// load64 is the 8 bytes of b from i, with 0s for any past its end.
+func (r *backwardBits) load64(i int) uint64 {
+    if i + 8 <= len(r.b) {
+        return binary.LittleEndian.Uint64(r.b[i:])
+    }
+    var v uint64
+    for j := 0; i + j < len(r.b) && j < 8; j++ {
+        v |= uint64(r.b[i + j]) << uint(8 * j)
+    }
+    return v
+}
+
+// peek is the next n bits, n at most 56, without reading them.
+func (r *backwardBits) peek(n uint) uint64 {
+    if n == 0 {
+        return 0
+    }
+    start := r.left - int(n)
+    var v uint64
+    if start >= 0 {
+        v = r.load64(start >> 3) >> uint(start & 7)
+    } else {
+        v = r.load64(0) << uint(-start)
+    }
+    return v & (1 << n - 1)
+}
+
+func (r *backwardBits) read(n uint) uint64 {
+    v := r.peek(n)
+    r.left -= int(n)
+    return v
+}
+
+// overread reports whether more bits have been read than there were.
+func (r *backwardBits) overread() bool {
+    return r.left < 0
+}
+
+// huffmanTable decodes Huffman coded literals: indexed by the next
+// maxBits bits, it gives the symbol they start with and how many of them
+// its code takes.
+type huffmanTable struct {
+    maxBits uint
+    symbols []uint8
+    lengths []uint8
+}
+
+// huffmanFromWeights builds the table for the weights of all but the last
+// symbol, whose weight is whatever makes the total a power of 2.
+func huffmanFromWeights(weights []uint8) (*huffmanTable, error) {
+    if len(weights) == 0 || len(weights) > 255 {
+        return nil, zstdCorrupt("Huffman table has %d weights", len(weights))
+    }
+    total := 0
+    for _, w := range weights {
+        if w > 11 {
+            return nil, zstdCorrupt("Huffman weight %d is over 11", w)
+        }
+        if w > 0 {
+            total += 1 << (w - 1)
+        }
+    }
+    if total == 0 {
+        return nil, zstdCorrupt("Huffman weights are all 0")
+    }
+    maxBits := uint(bits.Len(uint(total)))
+    if maxBits > 11 {
+        return nil, zstdCorrupt("Huffman codes are over 11 bits")
+    }
+    left := 1 << maxBits - total
+    if left & (left - 1) != 0 {
+        return nil, zstdCorrupt("Huffman weights don't leave a power of 2")
+    }
+    weights = append(weights, uint8(bits.Len(uint(left))))
+    t := &huffmanTable{maxBits, make([]uint8, 1 << maxBits), make([]uint8, 1 << maxBits)}
+    pos := 0
+    for w := uint8(1); w <= uint8(maxBits); w++ {
+        for s, sw := range weights {
+            if sw != w {
+                continue
+            }
+            n := 1 << (w - 1)
+            for i := 0; i < n; i++ {
+                t.symbols[pos + i] = uint8(s)
+                t.lengths[pos + i] = uint8(maxBits + 1) - w
+            }
+            pos += n
+        }
+    }
+    return t, nil
+}
+
+// readHuffmanTable reads a Huffman tree description from the start of b,
+// returning the table and how many bytes the description took.
+func readHuffmanTable(b []byte) (*huffmanTable, int, error) {
+    if len(b) == 0 {
+        return nil, 0, zstdCorrupt("Huffman tree description is missing")
+    }
+    header := int(b[0])
+    weights := []uint8{}
+    if header >= 128 {
+        // given directly, 4 bits each
+        n := header - 127
+        size := (n + 1) / 2
+        if 1 + size > len(b) {
+            return nil, 0, zstdCorrupt("Huffman weights run past their block")
+        }
+        for i := 0; i < n; i++ {
+            w := b[1 + i / 2]
+            if i % 2 == 0 {
+                w >>= 4
+            }
+            weights = append(weights, w & 15)
+        }
+        t, err := huffmanFromWeights(weights)
+        return t, 1 + size, err
+    }
+    // FSE compressed, by two states taking turns
+    if 1 + header > len(b) {
+        return nil, 0, zstdCorrupt("Huffman weights run past their block")
+    }
+    data := b[1:1 + header]
+    table, n, err := readFSETable(data, 255, 6)
+    if err != nil {
+        return nil, 0, err
+    }
+    r, err := newBackwardBits(data[n:])
+    if err != nil {
+        return nil, 0, err
+    }
+    states := [2]uint64{r.read(table.accuracyLog), r.read(table.accuracyLog)}
+    for i := 0; ; i ^= 1 {
+        if len(weights) > 255 {
+            return nil, 0, zstdCorrupt("too many Huffman weights")
+        }
+        entry := table.entries[states[i]]
+        weights = append(weights, entry.symbol)
+        states[i] = uint64(entry.baseline) + r.read(uint(entry.nbBits))
+        if r.overread() {
+            weights = append(weights, table.entries[states[i ^ 1]].symbol)
+            break
+        }
+    }
+    t, err := huffmanFromWeights(weights)
+    return t, 1 + header, err
+}
+
+// decodeHuffmanStream appends the n symbols Huffman coded in b to out.
+func decodeHuffmanStream(t *huffmanTable, b []byte, n int, out []byte) ([]byte, error) {
+    r, err := newBackwardBits(b)
+    if err != nil {
+        return nil, err
+    }
+    for i := 0; i < n; i++ {
+        idx := r.peek(t.maxBits)
+        out = append(out, t.symbols[idx])
+        r.left -= int(t.lengths[idx])
+    }
+    if r.left != 0 {
+        return nil, zstdCorrupt("Huffman stream doesn't end where its literals do")
+    }
+    return out, nil
+}
+
+// zstdSequence is a run of literals followed by a match.
+type zstdSequence struct {
+    litLength uint32
+    matchLength uint32
+    offset uint32
+}
+
+// zstdDecoder holds what carries over from one block of a frame to the
+// next.
+type zstdDecoder struct {
+    huffman *huffmanTable
+    litLength, matchLength, offset *fseDecodeTable
+    repeats [3]uint32
+}
+
+func (d *zstdDecoder) reset() {
+    *d = zstdDecoder{repeats: [3]uint32{1, 4, 8}}
+}
+
+// readLiterals reads the literals section at the start of a compressed
+// block, returning the literals and how many bytes the section took.
+func (d *zstdDecoder) readLiterals(b []byte) ([]byte, int, error) {
+    if len(b) == 0 {
+        return nil, 0, zstdCorrupt("block has no literals section")
+    }
+    kind := b[0] & 3
+    sizeFormat := b[0] >> 2 & 3
+    if kind < 2 {
+        // raw or RLE
+        var size, headerSize int
+        switch sizeFormat {
+        case 0, 2:
+            size, headerSize = int(b[0] >> 3), 1
+        case 1:
+            if len(b) < 2 {
+                return nil, 0, zstdCorrupt("literals header runs past its block")
+            }
+            size, headerSize = int(b[0] >> 4) + int(b[1]) << 4, 2
+        case 3:
+            if len(b) < 3 {
+                return nil, 0, zstdCorrupt("literals header runs past its block")
+            }
+            size, headerSize = int(b[0] >> 4) + int(b[1]) << 4 + int(b[2]) << 12, 3
+        }
+        if size > zstdMaxBlockSize {
+            return nil, 0, zstdCorrupt("%d literals are more than a block holds", size)
+        }
+        if kind == 0 {
+            if headerSize + size > len(b) {
+                return nil, 0, zstdCorrupt("literals run past their block")
+            }
+            return b[headerSize:headerSize + size], headerSize + size, nil
+        }
+        if headerSize + 1 > len(b) {
+            return nil, 0, zstdCorrupt("literals run past their block")
+        }
+        out := make([]byte, size)
+        for i := range out {
+            out[i] = b[headerSize]
+        }
+        return out, headerSize + 1, nil
+    }
+
+    // Huffman coded, with a new table or the last one
+    var regenerated, compressed, headerSize int
+    streams := 4
+    switch sizeFormat {
+    case 0, 1:
+        if len(b) < 3 {
+            return nil, 0, zstdCorrupt("literals header runs past its block")
+        }
+        v := int(b[0]) | int(b[1]) << 8 | int(b[2]) << 16
+        regenerated, compressed, headerSize = v >> 4 & 0x3ff, v >> 14 & 0x3ff, 3
+        if sizeFormat == 0 {
+            streams = 1
+        }
+    case 2:
+        if len(b) < 4 {
+            return nil, 0, zstdCorrupt("literals header runs past its block")
+        }
+        v := int(binary.LittleEndian.Uint32(b))
+        regenerated, compressed, headerSize = v >> 4 & 0x3fff, v >> 18 & 0x3fff, 4
+    case 3:
+        if len(b) < 5 {
+            return nil, 0, zstdCorrupt("literals header runs past its block")
+        }
+        v := int(binary.LittleEndian.Uint32(b)) | int(b[4]) << 32
+        regenerated, compressed, headerSize = v >> 4 & 0x3ffff, v >> 22 & 0x3ffff, 5
+    }
+    if regenerated > zstdMaxBlockSize {
+        return nil, 0, zstdCorrupt("%d literals are more than a block holds", regenerated)
+    }
+    if headerSize + compressed > len(b) {
+        return nil, 0, zstdCorrupt("literals run past their block")
+    }
+    data := b[headerSize:headerSize + compressed]
+    if kind == 2 {
+        t, n, err := readHuffmanTable(data)
+        if err != nil {
+            return nil, 0, err
+        }
+        d.huffman = t
+        data = data[n:]
+    } else if d.huffman == nil {
+        return nil, 0, zstdCorrupt("literals use the last Huffman table, but there isn't one")
+    }
+    out := make([]byte, 0, regenerated)
+    var err error
+    if streams == 1 {
+        out, err = decodeHuffmanStream(d.huffman, data, regenerated, out)
+        if err != nil {
+            return nil, 0, err
+        }
+        return out, headerSize + compressed, nil
+    }
+    if len(data) < 6 {
+        return nil, 0, zstdCorrupt("literals' jump table runs past their block")
+    }
+    sizes := [4]int{int(binary.LittleEndian.Uint16(data)), int(binary.LittleEndian.Uint16(data[2:])), int(binary.LittleEndian.Uint16(data[4:]))}
+    sizes[3] = len(data) - 6 - sizes[0] - sizes[1] - sizes[2]
+    if sizes[3] < 0 {
+        return nil, 0, zstdCorrupt("literals' streams run past their block")
+    }
+    data = data[6:]
+    each := (regenerated + 3) / 4
+    for i, size := range sizes {
+        n := each
+        if i == 3 {
+            n = regenerated - 3 * each
+        }
+        if n < 0 {
+            return nil, 0, zstdCorrupt("too few literals for 4 streams")
+        }
+        if out, err = decodeHuffmanStream(d.huffman, data[:size], n, out); err != nil {
+            return nil, 0, err
+        }
+        data = data[size:]
+    }
+    return out, headerSize + compressed, nil
+}
+
+// readSequenceTable reads the table for one sequence code, in the given
+// mode, from the start of b, returning how many bytes it took.
+func readSequenceTable(b []byte, mode byte, last **fseDecodeTable, predefined *fseDecodeTable, maxSymbol int, maxLog uint) (int, error) {
+    switch mode {
+    case 0:
+        *last = predefined
+        return 0, nil
+    case 1:
+        if len(b) == 0 {
+            return 0, zstdCorrupt("sequence table runs past its block")
+        }
+        if int(b[0]) > maxSymbol {
+            return 0, zstdCorrupt("sequence code %d is out of range", b[0])
+        }
+        *last = rleDecodeTable(b[0])
+        return 1, nil
+    case 2:
+        t, n, err := readFSETable(b, maxSymbol, maxLog)
+        if err != nil {
+            return 0, err
+        }
+        *last = t
+        return n, nil
+    }
+    if *last == nil {
+        return 0, zstdCorrupt("sequences use the last table, but there isn't one")
+    }
+    return 0, nil
+}
+
+// readSequences reads the sequences section that makes up the rest of a
+// compressed block.
+func (d *zstdDecoder) readSequences(b []byte) ([]zstdSequence, error) {
+    if len(b) == 0 {
+        return nil, zstdCorrupt("block has no sequences section")
+    }
+    count := int(b[0])
+    switch {
+    case count == 0:
+        return nil, nil
+    case count < 128:
+        b = b[1:]
+    case count < 255:
+        if len(b) < 2 {
+            return nil, zstdCorrupt("sequences header runs past its block")
+        }
+        count = (count - 128) << 8 + int(b[1])
+        b = b[2:]
+    default:
+        if len(b) < 3 {
+            return nil, zstdCorrupt("sequences header runs past its block")
+        }
+        count = int(b[1]) + int(b[2]) << 8 + 0x7f00
+        b = b[3:]
+    }
+    if len(b) == 0 {
+        return nil, zstdCorrupt("sequences header runs past its block")
+    }
+    modes := b[0]
+    if modes & 3 != 0 {
+        return nil, zstdCorrupt("reserved bits set in sequence modes")
+    }
+    b = b[1:]
+    n, err := readSequenceTable(b, modes >> 6, &d.litLength, zstdLitLengthDefaultTable, 35, 9)
+    if err != nil {
+        return nil, err
+    }
+    b = b[n:]
+    if n, err = readSequenceTable(b, modes >> 4 & 3, &d.offset, zstdOffsetDefaultTable, 31, 8); err != nil {
+        return nil, err
+    }
+    b = b[n:]
+    if n, err = readSequenceTable(b, modes >> 2 & 3, &d.matchLength, zstdMatchLengthDefaultTable, 52, 9); err != nil {
+        return nil, err
+    }
+    b = b[n:]
+
+    r, err := newBackwardBits(b)
+    if err != nil {
+        return nil, err
+    }
+    ll, of, ml := d.litLength, d.offset, d.matchLength
+    llState := r.read(ll.accuracyLog)
+    ofState := r.read(of.accuracyLog)
+    mlState := r.read(ml.accuracyLog)
+    seqs := make([]zstdSequence, count)
+    for i := range seqs {
+        llEntry, ofEntry, mlEntry := ll.entries[llState], of.entries[ofState], ml.entries[mlState]
+        ofCode, mlCode, llCode := uint(ofEntry.symbol), mlEntry.symbol, llEntry.symbol
+        if ofCode > 31 {
+            return nil, zstdCorrupt("offset code %d is out of range", ofCode)
+        }
+        offsetValue := uint32(1) << ofCode + uint32(r.read(ofCode))
+        matchLength := zstdMatchLengthBase[mlCode] + uint32(r.read(uint(zstdMatchLengthBits[mlCode])))
+        litLength := zstdLitLengthBase[llCode] + uint32(r.read(uint(zstdLitLengthBits[llCode])))
+        if i < count - 1 {
+            llState = uint64(llEntry.baseline) + r.read(uint(llEntry.nbBits))
+            mlState = uint64(mlEntry.baseline) + r.read(uint(mlEntry.nbBits))
+            ofState = uint64(ofEntry.baseline) + r.read(uint(ofEntry.nbBits))
+        }
+        if r.overread() {
+            return nil, zstdCorrupt("sequences run past their bitstream")
+        }
+
+        // 1 to 3 are the last offsets used, shifted along by one when
+        // there are no literals before the match
+        var offset uint32
+        if offsetValue > 3 {
+            offset = offsetValue - 3
+            d.repeats = [3]uint32{offset, d.repeats[0], d.repeats[1]}
+        } else {
+            idx := offsetValue - 1
+            if litLength == 0 {
+                idx++
+            }
+            switch idx {
+            case 0:
+                offset = d.repeats[0]
+            case 3:
+                offset = d.repeats[0] - 1
+                d.repeats = [3]uint32{offset, d.repeats[0], d.repeats[1]}
+            case 1:
+                offset = d.repeats[1]
+                d.repeats = [3]uint32{offset, d.repeats[0], d.repeats[2]}
+            case 2:
+                offset = d.repeats[2]
+                d.repeats = [3]uint32{offset, d.repeats[0], d.repeats[1]}
+            }
+        }
+        if offset == 0 {
+            return nil, zstdCorrupt("match offset of 0")
+        }
+        seqs[i] = zstdSequence{litLength, matchLength, offset}
+    }
+    if r.left != 0 {
+        return nil, zstdCorrupt("sequences don't end where their bitstream does")
+    }
+    return seqs, nil
+}
+
+// zstdReader decompresses the zstd frames read from r as a stream, only
+// holding onto a window's worth of what it's decompressed.
+type zstdReader struct {
+    r *bufio.Reader
+    d zstdDecoder
+    // what's been decompressed of this frame: its last window, and after
+    // that, what's yet to be read out
+    hist []byte
+    unread int
+    windowSize int
+    // sawFrame is whether there's been a frame, as there has to be one
+    sawFrame bool
+    inFrame bool
+    lastBlock bool
+    checksum bool
+    xxh *xxh64
+    block []byte
+    err error
+}
+
+func newZstdReader(r io.Reader) *zstdReader {
+    return &zstdReader{r: bufio.NewReader(r)}
+}
+
+func (z *zstdReader) Read(p []byte) (int, error) {
+    for z.unread == 0 {
+        if z.err != nil {
+            return 0, z.err
+        }
+        z.err = z.next()
+    }
+    n := copy(p, z.hist[len(z.hist) - z.unread:])
+    z.unread -= n
+    return n, nil
+}
+
+// next decompresses the next block, starting a new frame first if the
+// last has ended.
+func (z *zstdReader) next() error {
+    if !z.inFrame {
+        return z.readFrameHeader()
+    }
+    if z.lastBlock {
+        z.inFrame = false
+        if z.checksum {
+            var sum [4]byte
+            if _, err := io.ReadFull(z.r, sum[:]); err != nil {
+                return zstdCorrupt("frame ends without its checksum")
+            }
+            if binary.LittleEndian.Uint32(sum[:]) != uint32(z.xxh.sum64()) {
+                return zstdCorrupt("checksum doesn't match the content")
+            }
+        }
+        return nil
+    }
+    var header [3]byte
+    if _, err := io.ReadFull(z.r, header[:]); err != nil {
+        return zstdCorrupt("frame ends before its last block")
+    }
+    v := int(header[0]) | int(header[1]) << 8 | int(header[2]) << 16
+    z.lastBlock = v & 1 == 1
+    size := v >> 3
+    maxSize := zstdMaxBlockSize
+    if z.windowSize < maxSize {
+        maxSize = z.windowSize
+    }
+    // drop all but the window now and then, rather than after every
+    // block, so it isn't copied over and over
+    if keep := z.windowSize; len(z.hist) > 2 * keep + zstdMaxBlockSize {
+        z.hist = append(z.hist[:0], z.hist[len(z.hist) - keep:]...)
+    }
+    start := len(z.hist)
+    switch v >> 1 & 3 {
+    case 0:
+        if size > maxSize {
+            return zstdCorrupt("block of %d bytes is over the limit of %d", size, maxSize)
+        }
+        z.hist = append(z.hist, make([]byte, size)...)
+        if _, err := io.ReadFull(z.r, z.hist[start:]); err != nil {
+            return zstdCorrupt("block runs past the end of the data")
+        }
+    case 1:
+        if size > maxSize {
+            return zstdCorrupt("block of %d bytes is over the limit of %d", size, maxSize)
+        }
+        c, err := z.r.ReadByte()
+        if err != nil {
+            return zstdCorrupt("block runs past the end of the data")
+        }
+        for i := 0; i < size; i++ {
+            z.hist = append(z.hist, c)
+        }
+    case 2:
+        if size > maxSize {
+            return zstdCorrupt("block of %d bytes is over the limit of %d", size, maxSize)
+        }
+        if cap(z.block) < size {
+            z.block = make([]byte, size)
+        }
+        z.block = z.block[:size]
+        if _, err := io.ReadFull(z.r, z.block); err != nil {
+            return zstdCorrupt("block runs past the end of the data")
+        }
+        if err := z.decompressBlock(z.block, maxSize); err != nil {
+            return err
+        }
+    default:
+        return zstdCorrupt("reserved block type")
+    }
+    z.unread = len(z.hist) - start
+    if z.checksum {
+        z.xxh.Write(z.hist[start:])
+    }
+    return nil
+}
+
+// decompressBlock appends what the compressed block b holds to z.hist.
+func (z *zstdReader) decompressBlock(b []byte, maxSize int) error {
+    literals, n, err := z.d.readLiterals(b)
+    if err != nil {
+        return err
+    }
+    seqs, err := z.d.readSequences(b[n:])
+    if err != nil {
+        return err
+    }
+    start := len(z.hist)
+    for _, seq := range seqs {
+        if int(seq.litLength) > len(literals) {
+            return zstdCorrupt("sequence takes more literals than there are")
+        }
+        z.hist = append(z.hist, literals[:seq.litLength]...)
+        literals = literals[seq.litLength:]
+        if int(seq.offset) > len(z.hist) || int(seq.offset) > z.windowSize {
+            return zstdCorrupt("match offset %d is further back than there's data", seq.offset)
+        }
+        if len(z.hist) - start + int(seq.matchLength) > maxSize {
+            return zstdCorrupt("block decompresses to more than %d bytes", maxSize)
+        }
+        from := len(z.hist) - int(seq.offset)
+        if seq.offset >= seq.matchLength {
+            z.hist = append(z.hist, z.hist[from:from + int(seq.matchLength)]...)
+            continue
+        }
+        // byte by byte, as the match overlaps what it's copying
+        for i := 0; i < int(seq.matchLength); i++ {
+            z.hist = append(z.hist, z.hist[from + i])
+        }
+    }
+    z.hist = append(z.hist, literals...)
+    if len(z.hist) - start > maxSize {
+        return zstdCorrupt("block decompresses to more than %d bytes", maxSize)
+    }
+    return nil
+}
+
+// readFrameHeader starts the next frame, skipping any skippable ones, or
+// returns io.EOF if there are no more.
+func (z *zstdReader) readFrameHeader() error {
+    for {
+        var magic [4]byte
+        n, err := io.ReadFull(z.r, magic[:])
+        if n == 0 && err == io.EOF && z.sawFrame {
+            return io.EOF
+        }
+        if n == 0 && err == io.EOF {
+            return zstdCorrupt("no frame in the data")
+        }
+        if err != nil {
+            return zstdCorrupt("trailing bytes after the last frame")
+        }
+        m := binary.LittleEndian.Uint32(magic[:])
+        if m & 0xfffffff0 == zstdSkippableMagic {
+            var size [4]byte
+            if _, err := io.ReadFull(z.r, size[:]); err != nil {
+                return zstdCorrupt("skippable frame is cut short")
+            }
+            if _, err := z.r.Discard(int(binary.LittleEndian.Uint32(size[:]))); err != nil {
+                return zstdCorrupt("skippable frame is cut short")
+            }
+            continue
+        }
+        if m != zstdFrameMagic {
+            return zstdCorrupt("bad frame magic %#x", m)
+        }
+        z.sawFrame = true
+        break
+    }
+    descriptor, err := z.r.ReadByte()
+    if err != nil {
+        return zstdCorrupt("frame header is cut short")
+    }
+    if descriptor & 8 != 0 {
+        return zstdCorrupt("reserved bit set in frame header")
+    }
+    singleSegment := descriptor & 0x20 != 0
+    header := []byte{}
+    size := 0
+    if !singleSegment {
+        size++
+    }
+    dictSize := []int{0, 1, 2, 4}[descriptor & 3]
+    size += dictSize
+    contentSize := []int{0, 2, 4, 8}[descriptor >> 6]
+    if contentSize == 0 && singleSegment {
+        contentSize = 1
+    }
+    size += contentSize
+    header = make([]byte, size)
+    if _, err := io.ReadFull(z.r, header); err != nil {
+        return zstdCorrupt("frame header is cut short")
+    }
+    if !singleSegment {
+        exponent := int(header[0] >> 3)
+        if 10 + exponent > zstdMaxWindowLog {
+            return fmt.Errorf("zstd window of 2^%d bytes is more than the 2^%d that can be read", 10 + exponent, zstdMaxWindowLog)
+        }
+        base := 1 << (10 + exponent)
+        z.windowSize = base + base / 8 * int(header[0] & 7)
+        header = header[1:]
+    }
+    for i := 0; i < dictSize; i++ {
+        if header[i] != 0 {
+            return fmt.Errorf("zstd data needs a dictionary, which isn't supported")
+        }
+    }
+    header = header[dictSize:]
+    if singleSegment {
+        var fcs uint64
+        for i := len(header) - 1; i >= 0; i-- {
+            fcs = fcs << 8 | uint64(header[i])
+        }
+        if contentSize == 2 {
+            fcs += 256
+        }
+        if fcs > 1 << zstdMaxWindowLog {
+            return fmt.Errorf("zstd window of %d bytes is more than the 2^%d that can be read", fcs, zstdMaxWindowLog)
+        }
+        z.windowSize = int(fcs)
+    }
+    z.d.reset()
+    z.hist = z.hist[:0]
+    z.inFrame = true
+    z.lastBlock = false
+    z.checksum = descriptor & 4 != 0
+    z.xxh = newXXH64()
+    return nil
+}
+
+// XXH64, with a seed of 0, which zstd checksums frames with.
+const (
+    xxhPrime1 uint64 = 11400714785074694791
+    xxhPrime2 uint64 = 14029467366897019727
+    xxhPrime3 uint64 = 1609587929392839161
+    xxhPrime4 uint64 = 9650029242287828579
+    xxhPrime5 uint64 = 2870177450012600261
+)
+
+type xxh64 struct {
+    v [4]uint64
+    total uint64
+    buf [32]byte
+    n int
+}
+
+func newXXH64() *xxh64 {
+    // a variable, as the sums wrap around, which constants can't
+    p1 := xxhPrime1
+    return &xxh64{v: [4]uint64{p1 + xxhPrime2, xxhPrime2, 0, -p1}}
+}
+
+func xxhRound(acc uint64, input uint64) uint64 {
+    acc += input * xxhPrime2
+    acc = bits.RotateLeft64(acc, 31)
+    return acc * xxhPrime1
+}
+
+func (x *xxh64) stripe(b []byte) {
+    for i := range x.v {
+        x.v[i] = xxhRound(x.v[i], binary.LittleEndian.Uint64(b[8 * i:]))
+    }
+}
+
+func (x *xxh64) Write(p []byte) {
+    x.total += uint64(len(p))
+    if x.n > 0 {
+        c := copy(x.buf[x.n:], p)
+        x.n += c
+        p = p[c:]
+        if x.n < 32 {
+            return
+        }
+        x.stripe(x.buf[:])
+        x.n = 0
+    }
+    for len(p) >= 32 {
+        x.stripe(p)
+        p = p[32:]
+    }
+    x.n = copy(x.buf[:], p)
+}
+
+func (x *xxh64) sum64() uint64 {
+    var h uint64
+    if x.total >= 32 {
+        h = bits.RotateLeft64(x.v[0], 1) + bits.RotateLeft64(x.v[1], 7) + bits.RotateLeft64(x.v[2], 12) + bits.RotateLeft64(x.v[3], 18)
+        for _, v := range x.v {
+            h ^= xxhRound(0, v)
+            h = h * xxhPrime1 + xxhPrime4
+        }
+    } else {
+        h = x.v[2] + xxhPrime5
+    }
+    h += x.total
+    b := x.buf[:x.n]
+    for ; len(b) >= 8; b = b[8:] {
+        h ^= xxhRound(0, binary.LittleEndian.Uint64(b))
+        h = bits.RotateLeft64(h, 27) * xxhPrime1 + xxhPrime4
+    }
+    if len(b) >= 4 {
+        h ^= uint64(binary.LittleEndian.Uint32(b)) * xxhPrime1
+        h = bits.RotateLeft64(h, 23) * xxhPrime2 + xxhPrime3
+        b = b[4:]
+    }
+    for _, c := range b {
+        h ^= uint64(c) * xxhPrime5
+        h = bits.RotateLeft64(h, 11) * xxhPrime1
+    }
+    h ^= h >> 33
+    h *= xxhPrime2
+    h ^= h >> 29
+    h *= xxhPrime3
+    h ^= h >> 32
+    return h
+}
@@ -0,0 +1,234 @@
+package aztech
+
+import (
+    "bytes"
+    "encoding/base64"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "math/rand"
+    "testing"
+)
+
+// zstdSample is 3681 bytes of table-like text compressed by the zstd tool
+// at level 19, with Huffman and FSE tables of its own, and a checksum.
+const zstdSample = "" +
+    "KLUv/WRhDSUVAKIFExeAqw4IUcXO+hDoof1Ynt5voQAmy8UNGP//q6YrQQ2q6a3pRa6a2iOAJ3RJ" +
+    "M3D5acyQBfnWcQnLtzHykYuXNVBG7lWSFdftllzdW6CBSqiRL0iytW8hBAjEcdJC2QMRRCSBKSCS" +
+    "nElRMWtaArqrg3KYVyttM4NpO1urnqlOOedCUYGbjfoEyi+YENsllz1TRJq+j3EDIRgJ1WVTvCOM" +
+    "9CBtiGW7pFLX0fCQzcCq2d5VAZl40nQuQEK4SLKvKBW8fsbqAVFVQ1QN1DZOk+6ufVJLCEbonl3b" +
+    "d5B7aNKPqE5Qoy1uG0FI7zip2+WCKKB6iPwtwKOEGoJRSGmL6+dkUwtkcV6uIcUjDgshD0y7VzJ4" +
+    "00P6S5q9VOreA4Q7UVorbX6HZCUO6lj4SXVa8iFpG8YcPMD/48wyUP9zmkFLpLUOKAFj2vcwIeYy" +
+    "ZSEVRbTtVvtkHQ5yBldpqo2ZM6J6hKutrCps52pnYOUhYmmPwd5JWWGSGflfUu7sTirrynH0qI3t" +
+    "QANmhbbUHNGTh9LeaZ6ESOZko3aiu+K/VfDQeCaSg6KhdUpcbVh+9MLIbMHcP7i5A54d28YdbeRl" +
+    "IQMvRSfu/PKfuH4jYHEXxIUbc4p+62boryTbM+iYYTdxhl2Ftf0+TsBKeZcuQm1aWvkgQUH5J4wq" +
+    "UIqJCCIe00cDTji2vAaSmOF1a61Rr/47ihax3xCzIlZgnokJQ3PfVpcuzuPAiC49YM8IjiKnBIJc" +
+    "24NPE+LWsheUysoy6fGMdxpTpRAt41/jDsroC5L2Ajojqj5yEoRu6xtIc1Cv3wL1KssK8YsXChMr" +
+    "OurLtoLzkJeXmEma1StmgE4y4GLM9Qu8G48GQZSzf6AT06NxgNM/R3zvUORu8YP9FAb5E3yU7eo+" +
+    "uaqh5hUJ"
+
+// zstdTestData is n bytes of each sort zstdWriter has to cope with.
+func zstdTestData(n int) map[string][]byte {
+    r := rand.New(rand.NewSource(int64(n)))
+    random := make([]byte, n)
+    r.Read(random)
+    words := []string{"$Name:", "$Mass:", "#End", "Terran", "Vasudan", "laser", "+Speed:", "200", "\n"}
+    text := []byte{}
+    for len(text) < n {
+        text = append(text, words[r.Intn(len(words))]...)
+        text = append(text, ' ')
+    }
+    // bytes from most of the range, unevenly, with some repeats far back
+    skewed := make([]byte, n)
+    for i := range skewed {
+        if i > 1000 && r.Intn(4) == 0 {
+            skewed[i] = skewed[i - 1 - r.Intn(i)]
+        } else {
+            skewed[i] = byte(255 - r.Intn(16) * r.Intn(16))
+        }
+    }
+    mixed := append(append(append([]byte{}, text[:n / 3]...), random[:n / 3]...), make([]byte, n - 2 * (n / 3))...)
+    return map[string][]byte{"random": random, "text": text[:n], "skewed": skewed, "zeros": make([]byte, n), "mixed": mixed}
+}
+
+func zstdCompress(t testing.TB, data []byte, level int) []byte {
+    var out bytes.Buffer
+    w := newZstdWriter(&out, level)
+    // in uneven pieces, so blocks don't line up with writes
+    for len(data) > 0 {
+        n := 70001
+        if n > len(data) {
+            n = len(data)
+        }
+        if _, err := w.Write(data[:n]); err != nil {
+            t.Fatal(err)
+        }
+        data = data[n:]
+    }
+    if err := w.Close(); err != nil {
+        t.Fatal(err)
+    }
+    return out.Bytes()
+}
+
+func TestZstdRoundTrip(t *testing.T) {
+    sizes := []int{0, 1, 4, 31, 32, 255, 256, 1023, 1024, 16384, zstdMaxBlockSize, zstdMaxBlockSize + 1, 700000}
+    if !testing.Short() {
+        // past a level 1 window twice over, so it slides
+        sizes = append(sizes, 1500000)
+    }
+    for _, n := range sizes {
+        for name, data := range zstdTestData(n) {
+            for _, level := range []int{1, 3, 4, 9, 19} {
+                compressed := zstdCompress(t, data, level)
+                got, err := io.ReadAll(newZstdReader(bytes.NewReader(compressed)))
+                if err != nil {
+                    t.Fatalf("%d bytes of %v at level %d: %v", n, name, level, err)
+                }
+                if !bytes.Equal(got, data) {
+                    t.Fatalf("%d bytes of %v at level %d came back as %d different bytes", n, name, level, len(got))
+                }
+                if n >= 16384 && (name == "text" || name == "zeros") && len(compressed) > n / 2 {
+                    t.Errorf("%d bytes of %v at level %d compressed to %d", n, name, level, len(compressed))
+                }
+            }
+        }
+    }
+}
+
+func TestZstdReaderSample(t *testing.T) {
+    compressed, err := base64.StdEncoding.DecodeString(zstdSample)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // the checksum makes sure of the rest
+    got, err := io.ReadAll(newZstdReader(bytes.NewReader(compressed)))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(got) != 3681 || !bytes.HasPrefix(got, []byte("$Mass: missile laser $Name: Terran")) {
+        t.Errorf("decompressed to %d bytes starting %q", len(got), got[:20])
+    }
+}
+
+// zstdFrame is a frame of blocks, with no checksum and a 1KB window, the
+// last block marked as such.
+func zstdFrame(blocks ...[]byte) []byte {
+    b := binary.LittleEndian.AppendUint32(nil, zstdFrameMagic)
+    b = append(b, 0, 0)
+    for i, block := range blocks {
+        block = append([]byte{}, block...)
+        if i == len(blocks) - 1 {
+            block[0] |= 1
+        }
+        b = append(b, block...)
+    }
+    return b
+}
+
+// A raw block of hello, an RLE block of three !s, and a skippable frame,
+// to make frames of by hand.
+var (
+    zstdRawBlock = []byte{5 << 3, 0, 0, 'h', 'e', 'l', 'l', 'o'}
+    zstdRLEBlock = []byte{3 << 3 | 1 << 1, 0, 0, '!'}
+    zstdSkippable = append(binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint32(nil, zstdSkippableMagic + 3), 4), "skip"...)
+)
+
+func TestZstdReaderFrames(t *testing.T) {
+    frame, raw, rle, skippable := zstdFrame, zstdRawBlock, zstdRLEBlock, zstdSkippable
+    in := append(append(frame(raw, rle), skippable...), frame(raw)...)
+    got, err := io.ReadAll(newZstdReader(bytes.NewReader(in)))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if string(got) != "hello!!!hello" {
+        t.Errorf("got %q", got)
+    }
+
+    for name, in := range map[string][]byte{
+        "empty": {},
+        "only a skippable frame": skippable,
+        "not zstd": []byte("\x1f\x8b\x08\x00 not zstd"),
+        "cut short": frame(raw)[:9],
+        "no last block": frame(raw, raw)[:14],
+        "dictionary": append(binary.LittleEndian.AppendUint32(nil, zstdFrameMagic), 1, 0, 7),
+    } {
+        if _, err := io.ReadAll(newZstdReader(bytes.NewReader(in))); err == nil {
+            t.Errorf("%v: no error", name)
+        }
+    }
+}
+
+func TestZstdReaderCorrupt(t *testing.T) {
+    data := zstdTestData(300000)
+    compressed := [][]byte{}
+    for _, name := range []string{"text", "skewed", "mixed"} {
+        compressed = append(compressed, zstdCompress(t, data[name], 5))
+    }
+    r := rand.New(rand.NewSource(1))
+    for i := 0; i < 300; i++ {
+        c := append([]byte{}, compressed[i % len(compressed)]...)
+        for flips := 1 + r.Intn(4); flips > 0; flips-- {
+            c[r.Intn(len(c))] ^= 1 << uint(r.Intn(8))
+        }
+        if r.Intn(5) == 0 {
+            c = c[:r.Intn(len(c))]
+        }
+        func() {
+            defer func() {
+                if p := recover(); p != nil {
+                    t.Fatalf("panicked on corrupt input: %v", p)
+                }
+            }()
+            got, err := io.ReadAll(newZstdReader(bytes.NewReader(c)))
+            if err == nil && !bytes.Equal(got, data[[]string{"text", "skewed", "mixed"}[i % len(compressed)]]) {
+                t.Errorf("corrupt input decompressed to %d wrong bytes with no error", len(got))
+            }
+        }()
+    }
+}
+
+func TestZstdWriterLevels(t *testing.T) {
+    data := zstdTestData(400000)["text"]
+    sizes := []int{}
+    for _, level := range []int{1, 3, 9, 19} {
+        sizes = append(sizes, len(zstdCompress(t, data, level)))
+    }
+    for i := 1; i < len(sizes); i++ {
+        if sizes[i] > sizes[i - 1] {
+            t.Errorf("sizes by level went up: %v", fmt.Sprint(sizes))
+        }
+    }
+}
+
+// FuzzZstdReader checks that no input makes zstdReader panic or run away,
+// and that whatever it does decompress compresses and comes back the same.
+func FuzzZstdReader(f *testing.F) {
+    sample, err := base64.StdEncoding.DecodeString(zstdSample)
+    if err != nil {
+        f.Fatal(err)
+    }
+    f.Add(sample)
+    for name, data := range zstdTestData(5000) {
+        for _, level := range []int{1, 19} {
+            if name != "random" || level == 1 {
+                f.Add(zstdCompress(f, data, level))
+            }
+        }
+    }
+    f.Add(zstdFrame(zstdRawBlock, zstdRLEBlock))
+    f.Add(append(append([]byte{}, zstdSkippable...), zstdFrame(zstdRLEBlock)...))
+    f.Fuzz(func(t *testing.T, in []byte) {
+        // a few bytes of RLE blocks can stand for a lot, so only so much
+        // is read
+        const most = 4 << 20
+        got, err := io.ReadAll(io.LimitReader(newZstdReader(bytes.NewReader(in)), most + 1))
+        if err != nil || len(got) > most {
+            return
+        }
+        again, err := io.ReadAll(newZstdReader(bytes.NewReader(zstdCompress(t, got, 3))))
+        if err != nil || !bytes.Equal(again, got) {
+            t.Errorf("%d bytes decompressed didn't survive compressing again (%v)", len(got), err)
+        }
+    })
+}
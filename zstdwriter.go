@@ -0,0 +1,762 @@
+package aztech
+
+import (
+    "encoding/binary"
+    "errors"
+    "io"
+    "math/bits"
+    "sort"
+)
+
+// zstdDefaultLevel is the level zstd compresses at when none is given, as
+// with the zstd tool, and zstdMaxLevel the highest there is.
+const (
+    zstdDefaultLevel = 3
+    zstdMaxLevel = 19
+)
+
+// zstdLevel is how hard zstdWriter looks for matches at one level.
+type zstdLevel struct {
+    windowLog uint
+    hashLog uint
+    // how many earlier positions with the same hash are tried
+    depth int
+    // lazy checks whether a match one byte on is longer before taking one
+    lazy bool
+}
+
+func zstdLevelParams(level int) zstdLevel {
+    p := zstdLevel{windowLog: 19, hashLog: 16, depth: 1 << uint((level + 1) / 2), lazy: level >= 4}
+    switch {
+    case level >= 10:
+        p.windowLog, p.hashLog = 22, 20
+    case level >= 6:
+        p.windowLog, p.hashLog = 21, 18
+    case level >= 3:
+        p.windowLog, p.hashLog = 20, 17
+    }
+    return p
+}
+
+// bitWriter writes a bitstream a value at a time, lowest bits first, the
+// way backwardBits reads one back from its end.
+type bitWriter struct {
+    out []byte
+    acc uint64
+    n uint
+}
+
+func (w *bitWriter) add(v uint64, n uint) {
+    w.acc |= (v & (1 << n - 1)) << w.n
+    w.n += n
+    for w.n >= 8 {
+        w.out = append(w.out, byte(w.acc))
+        w.acc >>= 8
+        w.n -= 8
+    }
+}
+
+// close marks where the stream starts, for reading it back from the end,
+// and returns it.
+func (w *bitWriter) close() []byte {
+    w.add(1, 1)
+    if w.n > 0 {
+        w.out = append(w.out, byte(w.acc))
+    }
+    return w.out
+}
+
+// fseEncodeTable is the encoding side of an FSE table, as zstd builds it.
+type fseEncodeTable struct {
+    accuracyLog uint
+    states []uint16
+    deltaNbBits []int
+    deltaFindState []int
+}
+
+func newFSEEncodeTable(norm []int16, accuracyLog uint) *fseEncodeTable {
+    size := 1 << accuracyLog
+    t := &fseEncodeTable{accuracyLog, make([]uint16, size), make([]int, len(norm)), make([]int, len(norm))}
+    next := make([]int, len(norm))
+    total := 0
+    for s, n := range norm {
+        next[s] = total
+        switch n {
+        case 0:
+            t.deltaNbBits[s] = int(accuracyLog + 1) << 16 - size
+        case -1, 1:
+            t.deltaNbBits[s] = int(accuracyLog) << 16 - size
+            t.deltaFindState[s] = total - 1
+            total++
+        default:
+            maxBitsOut := int(accuracyLog) - (bits.Len(uint(n - 1)) - 1)
+            t.deltaNbBits[s] = maxBitsOut << 16 - int(n) << uint(maxBitsOut)
+            t.deltaFindState[s] = total - int(n)
+            total += int(n)
+        }
+    }
+    for u, s := range fseSpread(norm, accuracyLog) {
+        t.states[next[s]] = uint16(size + u)
+        next[s]++
+    }
+    return t
+}
+
+// init is the state to start encoding with, for symbol s last.
+func (t *fseEncodeTable) init(s uint8) int {
+    nbBits := (t.deltaNbBits[s] + 1 << 15) >> 16
+    value := nbBits << 16 - t.deltaNbBits[s]
+    return int(t.states[value >> uint(nbBits) + t.deltaFindState[s]])
+}
+
+// encode moves state on past s, writing the bits that tell the decoder how
+// to get back.
+func (t *fseEncodeTable) encode(w *bitWriter, state int, s uint8) int {
+    nbBits := uint((state + t.deltaNbBits[s]) >> 16)
+    w.add(uint64(state), nbBits)
+    return int(t.states[state >> nbBits + t.deltaFindState[s]])
+}
+
+// flush writes the final state, for the decoder to start from.
+func (t *fseEncodeTable) flush(w *bitWriter, state int) {
+    w.add(uint64(state), t.accuracyLog)
+}
+
+var (
+    zstdLitLengthEncodeTable = newFSEEncodeTable(zstdLitLengthDefault, zstdLitLengthDefaultLog)
+    zstdMatchLengthEncodeTable = newFSEEncodeTable(zstdMatchLengthDefault, zstdMatchLengthDefaultLog)
+    zstdOffsetEncodeTable = newFSEEncodeTable(zstdOffsetDefault, zstdOffsetDefaultLog)
+)
+
+// normalizeCounts scales counts to add up to 1<<accuracyLog, leaving
+// every symbol that's there at least 1.
+func normalizeCounts(counts []int, accuracyLog uint) []int16 {
+    total := 0
+    for _, c := range counts {
+        total += c
+    }
+    target := 1 << accuracyLog
+    norm := make([]int16, len(counts))
+    sum := 0
+    for s, c := range counts {
+        if c == 0 {
+            continue
+        }
+        n := (c * target + total / 2) / total
+        if n < 1 {
+            n = 1
+        }
+        norm[s] = int16(n)
+        sum += n
+    }
+    // take the difference from, or give it to, the most likely symbols
+    for sum != target {
+        largest := 0
+        for s := range norm {
+            if norm[s] > norm[largest] {
+                largest = s
+            }
+        }
+        if sum > target {
+            norm[largest]--
+            sum--
+        } else {
+            norm[largest]++
+            sum++
+        }
+    }
+    return norm
+}
+
+// writeFSETable writes the description readFSETable reads of norm.
+func writeFSETable(w *bitWriter, norm []int16, accuracyLog uint) {
+    w.add(uint64(accuracyLog - 5), 4)
+    remaining := 1 << accuracyLog
+    for s := 0; remaining > 0; s++ {
+        count := int(norm[s])
+        val := uint64(count + 1)
+        nbBits := uint(bits.Len(uint(remaining + 1)))
+        lowerMask := uint64(1) << (nbBits - 1) - 1
+        threshold := uint64(1) << nbBits - 1 - uint64(remaining + 1)
+        switch {
+        case val < threshold:
+            w.add(val, nbBits - 1)
+        case val <= lowerMask:
+            w.add(val, nbBits)
+        default:
+            w.add(val + threshold, nbBits)
+        }
+        if count < 0 {
+            remaining += count
+        } else {
+            remaining -= count
+        }
+        if count == 0 {
+            zeros := 0
+            for s + 1 + zeros < len(norm) && norm[s + 1 + zeros] == 0 {
+                zeros++
+            }
+            s += zeros
+            for ; zeros >= 3; zeros -= 3 {
+                w.add(3, 2)
+            }
+            w.add(uint64(zeros), 2)
+        }
+    }
+}
+
+// huffmanLengths gives each symbol with a count a code length of at most
+// maxBits, by building a Huffman tree, and if it comes out too deep,
+// flattening the counts and trying again.
+func huffmanLengths(counts []int, maxBits int) []uint8 {
+    type node struct {
+        count int
+        left, right int
+    }
+    for {
+        nodes := []node{}
+        for s, c := range counts {
+            if c > 0 {
+                nodes = append(nodes, node{c, -1 - s, 0})
+            }
+        }
+        sort.SliceStable(nodes, func(i, j int) bool {
+            return nodes[i].count < nodes[j].count
+        })
+        // leaves in nodes[:leaves], joined up in order after them, so the
+        // two smallest are always at the front of one or the other
+        leaves := len(nodes)
+        li, ni := 0, leaves
+        pick := func() int {
+            if li < leaves && (ni >= len(nodes) || nodes[li].count <= nodes[ni].count) {
+                li++
+                return li - 1
+            }
+            ni++
+            return ni - 1
+        }
+        for i := 1; i < leaves; i++ {
+            a := pick()
+            b := pick()
+            nodes = append(nodes, node{nodes[a].count + nodes[b].count, a, b})
+        }
+        lengths := make([]uint8, len(counts))
+        tooDeep := false
+        var walk func(i int, depth int)
+        walk = func(i int, depth int) {
+            if i < leaves {
+                lengths[-1 - nodes[i].left] = uint8(depth)
+                tooDeep = tooDeep || depth > maxBits
+                return
+            }
+            walk(nodes[i].left, depth + 1)
+            walk(nodes[i].right, depth + 1)
+        }
+        walk(len(nodes) - 1, 0)
+        if !tooDeep {
+            return lengths
+        }
+        for s := range counts {
+            if counts[s] > 0 {
+                counts[s] = (counts[s] + 1) / 2
+            }
+        }
+    }
+}
+
+// huffmanEncoder holds the codes for compressing a block's literals.
+type huffmanEncoder struct {
+    codes []uint16
+    lengths []uint8
+}
+
+// encode Huffman codes literals as one stream, for decodeHuffmanStream.
+func (h *huffmanEncoder) encode(literals []byte) []byte {
+    w := &bitWriter{}
+    for i := len(literals) - 1; i >= 0; i-- {
+        c := literals[i]
+        w.add(uint64(h.codes[c]), uint(h.lengths[c]))
+    }
+    return w.close()
+}
+
+// huffmanDescription is the tree description readHuffmanTable reads of
+// weights, FSE compressed if that's smaller or the only way, or nil if
+// there's no way that reads back right.
+func huffmanDescription(weights []uint8) []byte {
+    var direct []byte
+    if len(weights) <= 128 {
+        direct = []byte{byte(127 + len(weights))}
+        for i := 0; i < len(weights); i += 2 {
+            b := weights[i] << 4
+            if i + 1 < len(weights) {
+                b |= weights[i + 1]
+            }
+            direct = append(direct, b)
+        }
+    }
+    compressed := fseWeights(weights)
+    out, usedCompressed := direct, false
+    if compressed != nil && (out == nil || len(compressed) < len(out)) {
+        out, usedCompressed = compressed, true
+    }
+    if out == nil {
+        return nil
+    }
+    // the last weight is left to be worked out, so check it is
+    want, err := huffmanFromWeights(weights)
+    if err != nil {
+        return nil
+    }
+    got, n, err := readHuffmanTable(out)
+    if err != nil || n != len(out) || got.maxBits != want.maxBits || string(got.symbols) != string(want.symbols) || string(got.lengths) != string(want.lengths) {
+        if usedCompressed && direct != nil {
+            return direct
+        }
+        return nil
+    }
+    return out
+}
+
+// fseWeights is weights FSE compressed by two states taking turns, as
+// readHuffmanTable reads them, or nil if that won't fit its header byte.
+func fseWeights(weights []uint8) []byte {
+    if len(weights) < 2 {
+        return nil
+    }
+    counts := make([]int, 12)
+    for _, w := range weights {
+        counts[w]++
+    }
+    last := 0
+    for s, c := range counts {
+        if c > 0 {
+            last = s
+        }
+    }
+    const accuracyLog = 6
+    norm := normalizeCounts(counts[:last + 1], accuracyLog)
+    table := newFSEEncodeTable(norm, accuracyLog)
+    header := &bitWriter{}
+    writeFSETable(header, norm, accuracyLog)
+    description := header.out
+    if header.n > 0 {
+        description = append(description, byte(header.acc))
+    }
+
+    w := &bitWriter{}
+    i := len(weights)
+    var states [2]int
+    if i % 2 == 1 {
+        states[0] = table.init(weights[i - 1])
+        states[1] = table.init(weights[i - 2])
+        states[0] = table.encode(w, states[0], weights[i - 3])
+        i -= 3
+    } else {
+        states[1] = table.init(weights[i - 1])
+        states[0] = table.init(weights[i - 2])
+        i -= 2
+    }
+    for i > 0 {
+        states[1] = table.encode(w, states[1], weights[i - 1])
+        states[0] = table.encode(w, states[0], weights[i - 2])
+        i -= 2
+    }
+    table.flush(w, states[1])
+    table.flush(w, states[0])
+    out := append(description, w.close()...)
+    if len(out) >= 128 {
+        return nil
+    }
+    return append([]byte{byte(len(out))}, out...)
+}
+
+// newHuffmanEncoder works out the codes for literals, returning them and
+// the tree description to send, or nil if Huffman coding them won't work.
+func newHuffmanEncoder(literals []byte) (*huffmanEncoder, []byte) {
+    counts := make([]int, 256)
+    for _, c := range literals {
+        counts[c]++
+    }
+    distinct, last := 0, 0
+    for s, c := range counts {
+        if c > 0 {
+            distinct++
+            last = s
+        }
+    }
+    if distinct < 2 {
+        return nil, nil
+    }
+    lengths := huffmanLengths(counts[:last + 1], 11)
+    maxBits := uint8(0)
+    for _, l := range lengths {
+        if l > maxBits {
+            maxBits = l
+        }
+    }
+    weights := make([]uint8, last + 1)
+    for s, l := range lengths {
+        if l > 0 {
+            weights[s] = maxBits + 1 - l
+        }
+    }
+    description := huffmanDescription(weights[:last])
+    if description == nil {
+        return nil, nil
+    }
+    // codes are given out as huffmanFromWeights lays out the decoding
+    // table: by weight, then symbol
+    h := &huffmanEncoder{make([]uint16, 256), make([]uint8, 256)}
+    pos := 0
+    for w := uint8(1); w <= maxBits; w++ {
+        for s, sw := range weights {
+            if sw == w {
+                h.codes[s] = uint16(pos >> (w - 1))
+                h.lengths[s] = lengths[s]
+                pos += 1 << (w - 1)
+            }
+        }
+    }
+    return h, description
+}
+
+// literalsSection is the literals section of a compressed block holding
+// literals, Huffman coded if that's smaller.
+func literalsSection(literals []byte) []byte {
+    n := len(literals)
+    raw := []byte{}
+    switch {
+    case n < 32:
+        raw = append(raw, byte(n << 3))
+    case n < 4096:
+        raw = append(raw, byte(n << 4 | 1 << 2), byte(n >> 4))
+    default:
+        raw = append(raw, byte(n << 4 | 3 << 2), byte(n >> 4), byte(n >> 12))
+    }
+    raw = append(raw, literals...)
+    if n < 32 {
+        return raw
+    }
+    h, description := newHuffmanEncoder(literals)
+    if h == nil {
+        return raw
+    }
+    data := description
+    streams := 4
+    if n < 256 {
+        streams = 1
+        data = append(data, h.encode(literals)...)
+    } else {
+        each := (n + 3) / 4
+        parts := [][]byte{}
+        for i := 0; i < 4; i++ {
+            end := (i + 1) * each
+            if end > n {
+                end = n
+            }
+            parts = append(parts, h.encode(literals[i * each:end]))
+        }
+        for _, part := range parts[:3] {
+            data = binary.LittleEndian.AppendUint16(data, uint16(len(part)))
+        }
+        for _, part := range parts {
+            data = append(data, part...)
+        }
+    }
+    compressed := len(data)
+    var header []byte
+    switch {
+    case streams == 1 && compressed < 1024:
+        v := 2 | n << 4 | compressed << 14
+        header = []byte{byte(v), byte(v >> 8), byte(v >> 16)}
+    case streams == 1:
+        return raw
+    case n < 1024 && compressed < 1024:
+        v := 2 | 1 << 2 | n << 4 | compressed << 14
+        header = []byte{byte(v), byte(v >> 8), byte(v >> 16)}
+    case n < 16384 && compressed < 16384:
+        v := 2 | 2 << 2 | n << 4 | compressed << 18
+        header = binary.LittleEndian.AppendUint32(nil, uint32(v))
+    default:
+        v := 2 | 3 << 2 | n << 4 | compressed << 22
+        header = binary.LittleEndian.AppendUint32(nil, uint32(v))
+        header = append(header, byte(v >> 32))
+    }
+    if len(header) + compressed >= len(raw) {
+        return raw
+    }
+    return append(header, data...)
+}
+
+// litLengthCode and matchLengthCode are the codes a literal or match
+// length is sent as.
+func litLengthCode(ll uint32) uint8 {
+    if ll >= 64 {
+        return uint8(bits.Len32(ll) - 1 + 19)
+    }
+    code := uint8(0)
+    for code + 1 < uint8(len(zstdLitLengthBase)) && zstdLitLengthBase[code + 1] <= ll {
+        code++
+    }
+    return code
+}
+
+func matchLengthCode(ml uint32) uint8 {
+    if ml - 3 >= 128 {
+        return uint8(bits.Len32(ml - 3) - 1 + 36)
+    }
+    code := uint8(0)
+    for code + 1 < uint8(len(zstdMatchLengthBase)) && zstdMatchLengthBase[code + 1] <= ml {
+        code++
+    }
+    return code
+}
+
+// sequencesSection is the sequences section of a compressed block, coded
+// with the predefined tables.
+func sequencesSection(seqs []zstdSequence) []byte {
+    n := len(seqs)
+    out := []byte{}
+    switch {
+    case n < 128:
+        out = append(out, byte(n))
+    case n < 0x7f00:
+        out = append(out, byte(n >> 8 + 128), byte(n))
+    default:
+        out = append(out, 255, byte(n - 0x7f00), byte((n - 0x7f00) >> 8))
+    }
+    if n == 0 {
+        return out
+    }
+    // all three codes use the predefined tables
+    out = append(out, 0)
+
+    ll, ml, of := zstdLitLengthEncodeTable, zstdMatchLengthEncodeTable, zstdOffsetEncodeTable
+    llCodes, mlCodes, ofCodes := make([]uint8, n), make([]uint8, n), make([]uint8, n)
+    for i, seq := range seqs {
+        llCodes[i] = litLengthCode(seq.litLength)
+        mlCodes[i] = matchLengthCode(seq.matchLength)
+        // offsets are all sent as new ones, never as repeats
+        ofCodes[i] = uint8(bits.Len32(seq.offset + 3) - 1)
+    }
+    w := &bitWriter{}
+    extra := func(i int) {
+        seq := seqs[i]
+        w.add(uint64(seq.litLength - zstdLitLengthBase[llCodes[i]]), uint(zstdLitLengthBits[llCodes[i]]))
+        w.add(uint64(seq.matchLength - zstdMatchLengthBase[mlCodes[i]]), uint(zstdMatchLengthBits[mlCodes[i]]))
+        w.add(uint64(seq.offset + 3), uint(ofCodes[i]))
+    }
+    // backwards, as they're read from the end
+    mlState := ml.init(mlCodes[n - 1])
+    ofState := of.init(ofCodes[n - 1])
+    llState := ll.init(llCodes[n - 1])
+    extra(n - 1)
+    for i := n - 2; i >= 0; i-- {
+        ofState = of.encode(w, ofState, ofCodes[i])
+        mlState = ml.encode(w, mlState, mlCodes[i])
+        llState = ll.encode(w, llState, llCodes[i])
+        extra(i)
+    }
+    ml.flush(w, mlState)
+    of.flush(w, ofState)
+    ll.flush(w, llState)
+    return append(out, w.close()...)
+}
+
+// zstdWriter compresses what's written to it into a zstd frame, written
+// to w a block at a time as it fills up. It finds matches with hash
+// chains, going further down them, and checking a byte on before taking a
+// match, at higher levels; literals are Huffman coded, and sequences use
+// the predefined tables.
+type zstdWriter struct {
+    w io.Writer
+    level zstdLevel
+    started bool
+    // what's been written that's still in the window, then what's yet to
+    // be compressed, from buf[pending] on
+    buf []byte
+    pending int
+    // for each hash, the last position with it, plus 1, and for each
+    // position, the one before it with the same hash, plus 1
+    head []int32
+    chain []int32
+    xxh *xxh64
+    err error
+}
+
+func newZstdWriter(w io.Writer, level int) *zstdWriter {
+    if level == 0 {
+        level = zstdDefaultLevel
+    }
+    p := zstdLevelParams(level)
+    return &zstdWriter{
+        w: w,
+        level: p,
+        head: make([]int32, 1 << p.hashLog),
+        chain: make([]int32, 1 << p.windowLog),
+        xxh: newXXH64(),
+    }
+}
+
+func (z *zstdWriter) Write(p []byte) (int, error) {
+    if z.err != nil {
+        return 0, z.err
+    }
+    z.xxh.Write(p)
+    z.buf = append(z.buf, p...)
+    for len(z.buf) - z.pending > zstdMaxBlockSize {
+        if z.err = z.writeBlock(z.pending + zstdMaxBlockSize, false); z.err != nil {
+            return 0, z.err
+        }
+    }
+    return len(p), nil
+}
+
+// Close writes what's left as the last block, then the checksum. It
+// doesn't close w.
+func (z *zstdWriter) Close() error {
+    if z.err != nil {
+        return z.err
+    }
+    if z.err = z.writeBlock(len(z.buf), true); z.err != nil {
+        return z.err
+    }
+    _, z.err = z.w.Write(binary.LittleEndian.AppendUint32(nil, uint32(z.xxh.sum64())))
+    if z.err != nil {
+        return z.err
+    }
+    z.err = errZstdClosed
+    return nil
+}
+
+var errZstdClosed = errors.New("zstd: write after close")
+
+// writeBlock compresses buf[pending:end] into a block, and writes it out,
+// after the frame header if it's the first.
+func (z *zstdWriter) writeBlock(end int, last bool) error {
+    out := []byte{}
+    if !z.started {
+        // no dictionary or content size, a checksum, and the window
+        out = binary.LittleEndian.AppendUint32(out, zstdFrameMagic)
+        out = append(out, 4, byte(z.level.windowLog - 10) << 3)
+        z.started = true
+    }
+    start := z.pending
+    literals, seqs := z.findSequences(start, end)
+    body := append(literalsSection(literals), sequencesSection(seqs)...)
+    kind := 2
+    if len(body) >= end - start {
+        kind, body = 0, z.buf[start:end]
+    }
+    header := len(body) << 3 | kind << 1
+    if last {
+        header |= 1
+    }
+    out = append(out, byte(header), byte(header >> 8), byte(header >> 16))
+    out = append(out, body...)
+    if _, err := z.w.Write(out); err != nil {
+        return err
+    }
+    z.pending = end
+    z.slide()
+    return nil
+}
+
+// slide drops a window's worth of buf once there's more than twice that
+// behind what's pending, so it doesn't grow without end. Positions in the
+// tables move down with it; a whole window's worth, so each keeps its
+// place in chain.
+func (z *zstdWriter) slide() {
+    window := 1 << z.level.windowLog
+    if z.pending < 2 * window {
+        return
+    }
+    z.buf = append(z.buf[:0], z.buf[window:]...)
+    z.pending -= window
+    move := func(t []int32) {
+        for i, v := range t {
+            if v > int32(window) {
+                t[i] = v - int32(window)
+            } else {
+                t[i] = 0
+            }
+        }
+    }
+    move(z.head)
+    move(z.chain)
+}
+
+func (z *zstdWriter) hash(i int) uint32 {
+    return binary.LittleEndian.Uint32(z.buf[i:]) * 2654435761 >> (32 - z.level.hashLog)
+}
+
+func (z *zstdWriter) insert(i int) {
+    h := z.hash(i)
+    z.chain[i & (len(z.chain) - 1)] = z.head[h]
+    z.head[h] = int32(i + 1)
+}
+
+// match finds the longest match for buf[i:end] among the positions with
+// the same hash, returning its length and how far back it is.
+func (z *zstdWriter) match(i int, end int) (int, int) {
+    window := 1 << z.level.windowLog
+    best, offset := 0, 0
+    c := int(z.head[z.hash(i)]) - 1
+    for tries := 0; tries < z.level.depth && c >= 0 && i - c < window; tries++ {
+        if i + best < end && z.buf[c + best] == z.buf[i + best] {
+            n := 0
+            for i + n < end && z.buf[c + n] == z.buf[i + n] {
+                n++
+            }
+            if n > best {
+                best, offset = n, i - c
+            }
+        }
+        next := int(z.chain[c & (len(z.chain) - 1)]) - 1
+        if next >= c {
+            break
+        }
+        c = next
+    }
+    return best, offset
+}
+
+// findSequences splits buf[start:end] into sequences of literals then a
+// match, and the literals left after the last.
+func (z *zstdWriter) findSequences(start int, end int) ([]byte, []zstdSequence) {
+    literals := []byte{}
+    seqs := []zstdSequence{}
+    anchor := start
+    i := start
+    for i + 4 <= end {
+        n, offset := z.match(i, end)
+        z.insert(i)
+        if n < 4 {
+            // go faster through what doesn't match at the lower levels
+            step := 1
+            if !z.level.lazy {
+                step += (i - anchor) >> 6
+            }
+            i += step
+            continue
+        }
+        for z.level.lazy && i + 5 <= end {
+            n2, offset2 := z.match(i + 1, end)
+            if n2 <= n {
+                break
+            }
+            i++
+            z.insert(i)
+            n, offset = n2, offset2
+        }
+        literals = append(literals, z.buf[anchor:i]...)
+        seqs = append(seqs, zstdSequence{uint32(i - anchor), uint32(n), uint32(offset)})
+        for p := i + 1; p < i + n && p + 4 <= end; p++ {
+            z.insert(p)
+        }
+        i += n
+        anchor = i
+    }
+    return append(literals, z.buf[anchor:end]...), seqs
+}